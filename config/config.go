@@ -0,0 +1,454 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"time"
+
+	"barrelman/utils"
+
+	v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// APIVersion is the only supported version of the config file
+	APIVersion = "barrelman.tfw.io/v1alpha1"
+	// Kind is the kind of the config file
+	Kind = "Config"
+)
+
+// EndpointMode defines which kind of objects NodeEndpointController maintains for services
+type EndpointMode string
+
+const (
+	// EndpointModeEndpoints maintains (legacy) v1.Endpoints only
+	EndpointModeEndpoints EndpointMode = "endpoints"
+	// EndpointModeEndpointSlices maintains discovery.k8s.io EndpointSlices only
+	EndpointModeEndpointSlices EndpointMode = "endpointslices"
+	// EndpointModeBoth maintains v1.Endpoints and EndpointSlices
+	EndpointModeBoth EndpointMode = "both"
+)
+
+// Config is the versioned barrelman configuration.
+// Fields marked as "startup only" are not applied on reload.
+type Config struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+
+	// ResyncPeriod defines how often all objects are considered "old" and processed again (startup only)
+	ResyncPeriod metaV1.Duration `json:"resyncPeriod"`
+
+	// Workers defines the number of workers per controller (startup only)
+	Workers Workers `json:"workers"`
+
+	// LabelAnnotationKey is the key used for all barrelman specific labels and annotations (startup only)
+	LabelAnnotationKey string `json:"labelAnnotationKey"`
+
+	// RemoteClusters are the clusters to watch for nodes and services
+	RemoteClusters []utils.RemoteClusterConfig `json:"remoteClusters"`
+
+	// LocalClusterName is the name remote barrelman instances use for the local cluster (startup only).
+	// Remote services mirrored from it are never mirrored back, see utils.SetLocalClusterName.
+	LocalClusterName string `json:"localClusterName,omitempty"`
+
+	// IgnoredNamespaces is the complete list of namespaces to ignore remote services in. Entries may be globs
+	// ("ci-*") or regular expressions prefixed with "~", see utils.ValidateNamespacePattern.
+	IgnoredNamespaces []string `json:"ignoredNamespaces"`
+
+	// IncludedNamespaces restricts the mirrored remote namespaces (namespaces or patterns as in IgnoredNamespaces).
+	// All namespaces are included if it's empty, IgnoredNamespaces are excluded anyway.
+	IncludedNamespaces []string `json:"includedNamespaces,omitempty"`
+
+	// NamespaceSelector is a label selector restricting the mirrored remote namespaces (startup only)
+	NamespaceSelector string `json:"namespaceSelector,omitempty"`
+
+	// OptIn only mirrors remote services that are exported explicitly (annotated with "<labelAnnotationKey>: export"
+	// or matching ExportSelector). Otherwise all remote services but ignored ones are mirrored, see utils.IsExported.
+	OptIn bool `json:"optIn"`
+
+	// ExportSelector is a label selector of remote services to mirror in opt-in mode
+	ExportSelector string `json:"exportSelector,omitempty"`
+
+	// NamespaceMappings map remote namespaces to local namespaces, the first matching mapping is used.
+	// Namespaces not matching any mapping are mirrored to the same namespace.
+	NamespaceMappings []utils.NamespaceMapping `json:"namespaceMappings,omitempty"`
+
+	// ServiceNameTemplate is the name template of local (dummy) services (text/template, see
+	// utils.ExternalNameData), e.g. {{.Name}}-remote or {{.Cluster}}-{{.Name}}. Empty keeps the remote name.
+	ServiceNameTemplate string `json:"serviceNameTemplate,omitempty"`
+
+	// PortSelections select (and renumber) the mirrored ports of remote services (keyed by namespace/name),
+	// see utils.ParsePortSelection. The annotation on the remote service takes precedence.
+	PortSelections map[string]string `json:"portSelections,omitempty"`
+
+	// PropagatedLabels are the labels copied from remote to local services, by key or prefix ("app.kubernetes.io/*"),
+	// see utils.ParsePropagationRules. Labels of barrelman are never propagated.
+	PropagatedLabels []string `json:"propagatedLabels,omitempty"`
+
+	// PropagatedAnnotations are the annotations copied from remote to local services, like PropagatedLabels
+	PropagatedAnnotations []string `json:"propagatedAnnotations,omitempty"`
+
+	// ServiceType is the type of services created in local cluster (ClusterIP or NodePort)
+	ServiceType v1.ServiceType `json:"serviceType"`
+
+	// NamespaceServiceTypes overrides ServiceType per namespace
+	NamespaceServiceTypes map[string]v1.ServiceType `json:"namespaceServiceTypes,omitempty"`
+
+	// EndpointMode defines whether Endpoints, EndpointSlices or both are maintained
+	EndpointMode EndpointMode `json:"endpointMode"`
+
+	// EndpointSliceMaxSize limits the number of endpoints (nodes) in one EndpointSlice
+	EndpointSliceMaxSize int `json:"endpointSliceMaxSize"`
+
+	// NodeSelector is a label selector restricting the remote nodes used as endpoints
+	NodeSelector string `json:"nodeSelector,omitempty"`
+
+	// NodeAddressTypes is the ordered list of node address types to use as endpoint address, see
+	// utils.ParseNodeAddressTypes
+	NodeAddressTypes []string `json:"nodeAddressTypes"`
+
+	// IPFamilies are the IP families of endpoints for services without a cluster IP (e.g. headless services).
+	// Services with a cluster IP get endpoints of its family.
+	IPFamilies []utils.IPFamily `json:"ipFamilies"`
+
+	// MirrorLoadBalancers mirrors remote LoadBalancer services (endpoints point to their load balancer IPs).
+	// Single services may opt in or out via annotation, see utils.IsLoadBalancerMirrored.
+	MirrorLoadBalancers bool `json:"mirrorLoadBalancers"`
+
+	// PodIPs mirrors remote services via the pod IPs of their endpoints instead of node ports (startup only).
+	// Requires a routable pod network between the clusters. Single services may opt out via annotation,
+	// see utils.IsPodIPMirrored.
+	PodIPs bool `json:"podIPs"`
+
+	// MirrorHeadless mirrors remote headless services via the pod IPs (and hostnames) of their endpoints
+	// (startup only). Requires a routable pod network between the clusters.
+	MirrorHeadless bool `json:"mirrorHeadless"`
+
+	// ExternalName mirrors remote services as local ExternalName services pointing to ExternalNameTemplate.
+	// Single services may opt in or out via annotation, see utils.IsExternalNameMirrored.
+	ExternalName bool `json:"externalName"`
+
+	// ExternalNameTemplate is the hostname template of ExternalName services (text/template, see
+	// utils.ExternalNameData), e.g. {{.Name}}.{{.Namespace}}.remote.example.com
+	ExternalNameTemplate string `json:"externalNameTemplate,omitempty"`
+
+	// Probe configures active health checking of remote node ports (startup only)
+	Probe ProbeConfig `json:"probe"`
+
+	// NamespaceGC configures the removal of unused namespaces created by barrelman (startup only)
+	NamespaceGC NamespaceGCConfig `json:"namespaceGC"`
+
+	// OrphanSweep configures the periodic removal of orphaned services and endpoints (startup only)
+	OrphanSweep OrphanSweepConfig `json:"orphanSweep"`
+}
+
+// ProbeConfig configures active health checking of remote node ports
+type ProbeConfig struct {
+	// Enabled enables probing. If disabled, only node conditions decide if a node is ready.
+	Enabled bool `json:"enabled"`
+	// Interval between two probes of the same node port
+	Interval metaV1.Duration `json:"interval"`
+	// Timeout of a single probe
+	Timeout metaV1.Duration `json:"timeout"`
+	// SuccessThreshold is the number of consecutive successful probes for a failing address to become ready again
+	SuccessThreshold int `json:"successThreshold"`
+	// FailureThreshold is the number of consecutive failed probes for an address to become not ready
+	FailureThreshold int `json:"failureThreshold"`
+}
+
+// NamespaceGCConfig configures the removal of unused namespaces created by barrelman
+type NamespaceGCConfig struct {
+	// Enabled enables the garbage collector. If disabled, namespaces are never removed.
+	Enabled bool `json:"enabled"`
+	// Interval between two checks of all namespaces
+	Interval metaV1.Duration `json:"interval"`
+	// GracePeriod is the time a namespace has to be unused before it's deleted
+	GracePeriod metaV1.Duration `json:"gracePeriod"`
+}
+
+// OrphanSweepConfig configures the periodic removal of orphaned services and endpoints
+type OrphanSweepConfig struct {
+	// Enabled enables the sweeper. If disabled, orphans are only removed on remote or local delete events.
+	Enabled bool `json:"enabled"`
+	// Interval between two sweeps
+	Interval metaV1.Duration `json:"interval"`
+	// MaxDeletions limits the number of objects removed by a single sweep
+	MaxDeletions int `json:"maxDeletions"`
+}
+
+// Workers defines the number of workers per controller
+type Workers struct {
+	NodeEndpointController int `json:"nodeEndpointController"`
+	ServiceController      int `json:"serviceController"`
+}
+
+// Default returns a Config with all defaults set
+func Default() *Config {
+	return &Config{
+		APIVersion:   APIVersion,
+		Kind:         Kind,
+		ResyncPeriod: metaV1.Duration{Duration: 2 * time.Hour},
+		Workers: Workers{
+			NodeEndpointController: 4,
+			ServiceController:      2,
+		},
+		LabelAnnotationKey:   utils.DefaultLabelAnnotationKey,
+		IgnoredNamespaces:    []string{"kube-system"},
+		ServiceType:          v1.ServiceTypeClusterIP,
+		EndpointMode:         EndpointModeEndpoints,
+		EndpointSliceMaxSize: 100,
+		NodeAddressTypes:     append([]string(nil), utils.DefaultNodeAddressTypes...),
+		IPFamilies:           append([]utils.IPFamily(nil), utils.DefaultIPFamilies...),
+		Probe: ProbeConfig{
+			Interval:         metaV1.Duration{Duration: 10 * time.Second},
+			Timeout:          metaV1.Duration{Duration: 2 * time.Second},
+			SuccessThreshold: 2,
+			FailureThreshold: 2,
+		},
+		NamespaceGC: NamespaceGCConfig{
+			Interval:    metaV1.Duration{Duration: 10 * time.Minute},
+			GracePeriod: metaV1.Duration{Duration: time.Hour},
+		},
+		OrphanSweep: OrphanSweepConfig{
+			Interval:     metaV1.Duration{Duration: 10 * time.Minute},
+			MaxDeletions: 10,
+		},
+	}
+}
+
+// Load reads the config file at path on top of a copy of base.
+// Fields not set in the file keep the values of base.
+func Load(path string, base *Config) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data, base)
+}
+
+// Parse parses data (YAML or JSON) on top of a copy of base
+func Parse(data []byte, base *Config) (*Config, error) {
+	cfg := base.DeepCopy()
+	// Version and kind have to be given in the file
+	cfg.APIVersion, cfg.Kind = "", ""
+
+	jsonData, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(jsonData))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil {
+		return nil, err
+	}
+
+	if cfg.APIVersion != APIVersion || cfg.Kind != Kind {
+		return nil, fmt.Errorf("unsupported config apiVersion %q, kind %q (expected %q, %q)",
+			cfg.APIVersion, cfg.Kind, APIVersion, Kind)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate checks the config for errors
+func (c *Config) Validate() error {
+	if c.ResyncPeriod.Duration < 0 {
+		return fmt.Errorf("resyncPeriod must not be negative")
+	}
+	if c.Workers.NodeEndpointController < 1 || c.Workers.ServiceController < 1 {
+		return fmt.Errorf("workers must be greater than 0")
+	}
+	if c.LabelAnnotationKey == "" {
+		return fmt.Errorf("labelAnnotationKey must not be empty")
+	}
+
+	names := make(map[string]struct{}, len(c.RemoteClusters))
+	for _, r := range c.RemoteClusters {
+		if err := utils.ValidateRemoteClusterName(r.Name); err != nil {
+			return err
+		}
+		if _, exists := names[r.Name]; exists {
+			return fmt.Errorf("duplicate remote cluster name %q", r.Name)
+		}
+		names[r.Name] = struct{}{}
+		if _, err := r.NewProvider(); err != nil {
+			return fmt.Errorf("remote cluster %q: %v", r.Name, err)
+		}
+	}
+
+	for _, ns := range c.IgnoredNamespaces {
+		if err := utils.ValidateNamespacePattern(ns); err != nil {
+			return fmt.Errorf("ignoredNamespaces: %v", err)
+		}
+	}
+	for _, ns := range c.IncludedNamespaces {
+		if err := utils.ValidateNamespacePattern(ns); err != nil {
+			return fmt.Errorf("includedNamespaces: %v", err)
+		}
+	}
+	if _, err := labels.Parse(c.NamespaceSelector); err != nil {
+		return fmt.Errorf("invalid namespaceSelector %q: %v", c.NamespaceSelector, err)
+	}
+
+	if _, err := labels.Parse(c.ExportSelector); err != nil {
+		return fmt.Errorf("invalid exportSelector %q: %v", c.ExportSelector, err)
+	}
+
+	if err := utils.ValidateNamespaceMappings(c.NamespaceMappings); err != nil {
+		return fmt.Errorf("namespaceMappings: %v", err)
+	}
+
+	if c.ServiceNameTemplate != "" {
+		if err := utils.ValidateServiceNameTemplate(c.ServiceNameTemplate); err != nil {
+			return err
+		}
+	}
+
+	if err := utils.ValidatePortSelections(c.PortSelections); err != nil {
+		return fmt.Errorf("portSelections: %v", err)
+	}
+
+	if _, err := utils.ParsePropagationRules(c.PropagatedLabels); err != nil {
+		return fmt.Errorf("propagatedLabels: %v", err)
+	}
+	if _, err := utils.ParsePropagationRules(c.PropagatedAnnotations); err != nil {
+		return fmt.Errorf("propagatedAnnotations: %v", err)
+	}
+
+	if err := validateServiceType(c.ServiceType); err != nil {
+		return err
+	}
+	for ns, t := range c.NamespaceServiceTypes {
+		if err := validateServiceType(t); err != nil {
+			return fmt.Errorf("namespace %q: %v", ns, err)
+		}
+	}
+
+	switch c.EndpointMode {
+	case EndpointModeEndpoints, EndpointModeEndpointSlices, EndpointModeBoth:
+	default:
+		return fmt.Errorf("invalid endpointMode %q", c.EndpointMode)
+	}
+	if c.EndpointSliceMaxSize < 1 {
+		return fmt.Errorf("endpointSliceMaxSize must be greater than 0")
+	}
+
+	if _, err := labels.Parse(c.NodeSelector); err != nil {
+		return fmt.Errorf("invalid nodeSelector %q: %v", c.NodeSelector, err)
+	}
+
+	if err := utils.ValidateNodeAddressTypes(c.NodeAddressTypes); err != nil {
+		return fmt.Errorf("nodeAddressTypes: %v", err)
+	}
+
+	if err := utils.ValidateIPFamilies(c.IPFamilies); err != nil {
+		return fmt.Errorf("ipFamilies: %v", err)
+	}
+
+	if c.ExternalName && c.ExternalNameTemplate == "" {
+		return fmt.Errorf("externalName requires externalNameTemplate")
+	}
+	if c.ExternalNameTemplate != "" {
+		if err := utils.ValidateExternalNameTemplate(c.ExternalNameTemplate); err != nil {
+			return err
+		}
+	}
+
+	if c.Probe.Interval.Duration <= 0 || c.Probe.Timeout.Duration <= 0 {
+		return fmt.Errorf("probe interval and timeout must be greater than 0")
+	}
+	if c.Probe.SuccessThreshold < 1 || c.Probe.FailureThreshold < 1 {
+		return fmt.Errorf("probe thresholds must be greater than 0")
+	}
+	if c.NamespaceGC.Interval.Duration <= 0 || c.NamespaceGC.GracePeriod.Duration < 0 {
+		return fmt.Errorf("namespaceGC interval must be greater than 0, gracePeriod must not be negative")
+	}
+	if c.OrphanSweep.Interval.Duration <= 0 || c.OrphanSweep.MaxDeletions < 1 {
+		return fmt.Errorf("orphanSweep interval and maxDeletions must be greater than 0")
+	}
+	return nil
+}
+
+func validateServiceType(t v1.ServiceType) error {
+	if t != v1.ServiceTypeClusterIP && t != v1.ServiceTypeNodePort {
+		return fmt.Errorf("invalid service type %q (must be ClusterIP or NodePort)", t)
+	}
+	return nil
+}
+
+// DeepCopy returns a deep copy of the config
+func (c *Config) DeepCopy() *Config {
+	// Config only contains JSON serializable data, so this is the easiest way to copy it
+	data, err := json.Marshal(c)
+	if err != nil {
+		panic(err)
+	}
+	out := &Config{}
+	if err := json.Unmarshal(data, out); err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// ServiceTypeFor returns the type of services to create in the given (local) namespace
+func (c *Config) ServiceTypeFor(namespace string) v1.ServiceType {
+	if t, ok := c.NamespaceServiceTypes[namespace]; ok {
+		return t
+	}
+	return c.ServiceType
+}
+
+// WatchEndpoints returns true if the endpoints of remote clusters have to be watched (pod IPs or headless services)
+func (c *Config) WatchEndpoints() bool {
+	return c.PodIPs || c.MirrorHeadless
+}
+
+// StartupOnlyChanges returns the names of all fields that differ between c and other
+// but are not applied on reload
+func (c *Config) StartupOnlyChanges(other *Config) []string {
+	var changes []string
+	if c.ResyncPeriod != other.ResyncPeriod {
+		changes = append(changes, "resyncPeriod")
+	}
+	if c.Workers != other.Workers {
+		changes = append(changes, "workers")
+	}
+	if c.LabelAnnotationKey != other.LabelAnnotationKey {
+		changes = append(changes, "labelAnnotationKey")
+	}
+	if c.LocalClusterName != other.LocalClusterName {
+		changes = append(changes, "localClusterName")
+	}
+	if c.NamespaceSelector != other.NamespaceSelector {
+		changes = append(changes, "namespaceSelector")
+	}
+	if c.PodIPs != other.PodIPs {
+		changes = append(changes, "podIPs")
+	}
+	if c.MirrorHeadless != other.MirrorHeadless {
+		changes = append(changes, "mirrorHeadless")
+	}
+	if c.Probe != other.Probe {
+		changes = append(changes, "probe")
+	}
+	if c.NamespaceGC != other.NamespaceGC {
+		changes = append(changes, "namespaceGC")
+	}
+	if c.OrphanSweep != other.OrphanSweep {
+		changes = append(changes, "orphanSweep")
+	}
+	return changes
+}
+
+// Equal checks if two configs are equal
+func (c *Config) Equal(other *Config) bool {
+	return reflect.DeepEqual(c, other)
+}
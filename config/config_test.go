@@ -0,0 +1,439 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"barrelman/utils"
+
+	v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/diff"
+)
+
+func TestParse(t *testing.T) {
+	full := Default()
+	full.ResyncPeriod = metaV1.Duration{Duration: time.Hour}
+	full.Workers = Workers{NodeEndpointController: 8, ServiceController: 3}
+	full.LabelAnnotationKey = "example.com/barrelman"
+	full.RemoteClusters = []utils.RemoteClusterConfig{
+		{Name: "eu", ProviderConfig: utils.ProviderConfig{Provider: utils.ProviderKubeconfig, Kubeconfig: "/kube/eu"}},
+	}
+	full.IgnoredNamespaces = []string{"kube-system", "monitoring", "ci-*", "~preview-[0-9]+"}
+	full.IncludedNamespaces = []string{"shop-*"}
+	full.NamespaceSelector = "mirror!=false"
+	full.NamespaceMappings = []utils.NamespaceMapping{
+		{Remote: "shop-prod", Local: "shop"},
+		{Remote: "(.+)-prod", Local: "$1", Regex: true},
+	}
+	full.OptIn = true
+	full.ExportSelector = "export=true"
+	full.ServiceNameTemplate = "{{.Cluster}}-{{.Name}}"
+	full.LocalClusterName = "us"
+	full.PortSelections = map[string]string{"shop/api": "http=8080,-admin"}
+	full.PropagatedLabels = []string{"team", "app.kubernetes.io/*"}
+	full.PropagatedAnnotations = []string{"prometheus.io/*"}
+	full.ServiceType = v1.ServiceTypeNodePort
+	full.NamespaceServiceTypes = map[string]v1.ServiceType{"foo": v1.ServiceTypeClusterIP}
+	full.EndpointMode = EndpointModeBoth
+	full.EndpointSliceMaxSize = 50
+	full.NodeSelector = "pool=ingress"
+	full.NodeAddressTypes = []string{"ExternalIP", "annotation:example.com/public-ip"}
+	full.IPFamilies = []utils.IPFamily{utils.IPv4, utils.IPv6}
+	full.MirrorLoadBalancers = true
+	full.PodIPs = true
+	full.MirrorHeadless = true
+	full.ExternalName = true
+	full.ExternalNameTemplate = "{{.Name}}.{{.Namespace}}.remote.example.com"
+	full.Probe = ProbeConfig{
+		Enabled:          true,
+		Interval:         metaV1.Duration{Duration: 5 * time.Second},
+		Timeout:          metaV1.Duration{Duration: time.Second},
+		SuccessThreshold: 3,
+		FailureThreshold: 1,
+	}
+	full.NamespaceGC = NamespaceGCConfig{
+		Enabled:     true,
+		Interval:    metaV1.Duration{Duration: 5 * time.Minute},
+		GracePeriod: metaV1.Duration{Duration: 24 * time.Hour},
+	}
+	full.OrphanSweep = OrphanSweepConfig{
+		Enabled:      true,
+		Interval:     metaV1.Duration{Duration: time.Minute},
+		MaxDeletions: 5,
+	}
+
+	partial := Default()
+	partial.IgnoredNamespaces = []string{"bar"}
+
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+		want    *Config
+	}{
+		{
+			"Full",
+			`apiVersion: barrelman.tfw.io/v1alpha1
+kind: Config
+resyncPeriod: 1h
+workers:
+  nodeEndpointController: 8
+  serviceController: 3
+labelAnnotationKey: example.com/barrelman
+remoteClusters:
+- name: eu
+  provider: kubeconfig
+  kubeconfig: /kube/eu
+localClusterName: us
+ignoredNamespaces: [kube-system, monitoring, ci-*, "~preview-[0-9]+"]
+includedNamespaces: [shop-*]
+namespaceSelector: mirror!=false
+optIn: true
+exportSelector: export=true
+namespaceMappings:
+- remote: shop-prod
+  local: shop
+- remote: (.+)-prod
+  local: $1
+  regex: true
+serviceNameTemplate: "{{.Cluster}}-{{.Name}}"
+portSelections:
+  shop/api: http=8080,-admin
+propagatedLabels: [team, app.kubernetes.io/*]
+propagatedAnnotations: [prometheus.io/*]
+serviceType: NodePort
+namespaceServiceTypes:
+  foo: ClusterIP
+endpointMode: both
+endpointSliceMaxSize: 50
+nodeSelector: pool=ingress
+nodeAddressTypes: [ExternalIP, "annotation:example.com/public-ip"]
+ipFamilies: [IPv4, IPv6]
+mirrorLoadBalancers: true
+podIPs: true
+mirrorHeadless: true
+externalName: true
+externalNameTemplate: "{{.Name}}.{{.Namespace}}.remote.example.com"
+probe:
+  enabled: true
+  interval: 5s
+  timeout: 1s
+  successThreshold: 3
+  failureThreshold: 1
+namespaceGC:
+  enabled: true
+  interval: 5m
+  gracePeriod: 24h
+orphanSweep:
+  enabled: true
+  interval: 1m
+  maxDeletions: 5
+`,
+			false,
+			full,
+		},
+		{
+			"PartialKeepsBase",
+			`apiVersion: barrelman.tfw.io/v1alpha1
+kind: Config
+ignoredNamespaces: [bar]
+`,
+			false,
+			partial,
+		},
+		{
+			"NoVersion",
+			`ignoredNamespaces: [bar]`,
+			true,
+			nil,
+		},
+		{
+			"WrongVersion",
+			`apiVersion: barrelman.tfw.io/v2
+kind: Config
+`,
+			true,
+			nil,
+		},
+		{
+			"UnknownField",
+			`apiVersion: barrelman.tfw.io/v1alpha1
+kind: Config
+foo: bar
+`,
+			true,
+			nil,
+		},
+		{
+			"InvalidServiceType",
+			`apiVersion: barrelman.tfw.io/v1alpha1
+kind: Config
+namespaceServiceTypes:
+  foo: LoadBalancer
+`,
+			true,
+			nil,
+		},
+		{
+			"InvalidRemoteCluster",
+			`apiVersion: barrelman.tfw.io/v1alpha1
+kind: Config
+remoteClusters:
+- name: eu
+  provider: token
+`,
+			true,
+			nil,
+		},
+		{
+			"InvalidRemoteClusterName",
+			`apiVersion: barrelman.tfw.io/v1alpha1
+kind: Config
+remoteClusters:
+- {name: eu.west, provider: kubeconfig, kubeconfig: /kube/a}
+`,
+			true,
+			nil,
+		},
+		{
+			"DuplicateRemoteCluster",
+			`apiVersion: barrelman.tfw.io/v1alpha1
+kind: Config
+remoteClusters:
+- {name: eu, provider: kubeconfig, kubeconfig: /kube/a}
+- {name: eu, provider: kubeconfig, kubeconfig: /kube/b}
+`,
+			true,
+			nil,
+		},
+		{
+			"InvalidIgnoredNamespacePattern",
+			`apiVersion: barrelman.tfw.io/v1alpha1
+kind: Config
+ignoredNamespaces: ["~preview-("]
+`,
+			true,
+			nil,
+		},
+		{
+			"InvalidNamespaceSelector",
+			`apiVersion: barrelman.tfw.io/v1alpha1
+kind: Config
+namespaceSelector: "mirror in (true"
+`,
+			true,
+			nil,
+		},
+		{
+			"InvalidExportSelector",
+			`apiVersion: barrelman.tfw.io/v1alpha1
+kind: Config
+optIn: true
+exportSelector: "export in (true"
+`,
+			true,
+			nil,
+		},
+		{
+			"InvalidPortSelection",
+			`apiVersion: barrelman.tfw.io/v1alpha1
+kind: Config
+portSelections:
+  shop/api: http=70000
+`,
+			true,
+			nil,
+		},
+		{
+			"InvalidPropagatedLabel",
+			`apiVersion: barrelman.tfw.io/v1alpha1
+kind: Config
+propagatedLabels: ["*"]
+`,
+			true,
+			nil,
+		},
+		{
+			"InvalidNodeSelector",
+			`apiVersion: barrelman.tfw.io/v1alpha1
+kind: Config
+nodeSelector: "pool in (a"
+`,
+			true,
+			nil,
+		},
+		{
+			"InvalidNodeAddressType",
+			`apiVersion: barrelman.tfw.io/v1alpha1
+kind: Config
+nodeAddressTypes: [PublicIP]
+`,
+			true,
+			nil,
+		},
+		{
+			"InvalidNamespaceGCInterval",
+			`apiVersion: barrelman.tfw.io/v1alpha1
+kind: Config
+namespaceGC:
+  interval: 0s
+`,
+			true,
+			nil,
+		},
+		{
+			"InvalidOrphanSweepMaxDeletions",
+			`apiVersion: barrelman.tfw.io/v1alpha1
+kind: Config
+orphanSweep:
+  maxDeletions: 0
+`,
+			true,
+			nil,
+		},
+		{
+			"InvalidProbeThreshold",
+			`apiVersion: barrelman.tfw.io/v1alpha1
+kind: Config
+probe:
+  failureThreshold: 0
+`,
+			true,
+			nil,
+		},
+		{
+			"ExternalNameWithoutTemplate",
+			`apiVersion: barrelman.tfw.io/v1alpha1
+kind: Config
+externalName: true
+`,
+			true,
+			nil,
+		},
+		{
+			"InvalidExternalNameTemplate",
+			`apiVersion: barrelman.tfw.io/v1alpha1
+kind: Config
+externalNameTemplate: "{{.Name"
+`,
+			true,
+			nil,
+		},
+		{
+			"NamespaceMappingCollision",
+			`apiVersion: barrelman.tfw.io/v1alpha1
+kind: Config
+namespaceMappings:
+- remote: shop-prod
+  local: shop
+- remote: shop-staging
+  local: shop
+`,
+			true,
+			nil,
+		},
+		{
+			"InvalidServiceNameTemplate",
+			`apiVersion: barrelman.tfw.io/v1alpha1
+kind: Config
+serviceNameTemplate: "{{.Name}}.remote"
+`,
+			true,
+			nil,
+		},
+		{
+			"InvalidEndpointMode",
+			`apiVersion: barrelman.tfw.io/v1alpha1
+kind: Config
+endpointMode: foo
+`,
+			true,
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := Default()
+			got, err := Parse([]byte(tt.data), base)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(tt.want, got) {
+				t.Errorf("Expected different Config: (expected, got)\n%s",
+					diff.ObjectGoPrintSideBySide(tt.want, got))
+			}
+			if !reflect.DeepEqual(Default(), base) {
+				t.Errorf("Parse() modified base config")
+			}
+		})
+	}
+}
+
+func TestConfig_ServiceTypeFor(t *testing.T) {
+	cfg := Default()
+	cfg.NamespaceServiceTypes = map[string]v1.ServiceType{"foo": v1.ServiceTypeNodePort}
+
+	tests := []struct {
+		name      string
+		namespace string
+		want      v1.ServiceType
+	}{
+		{"Override", "foo", v1.ServiceTypeNodePort},
+		{"Default", "bar", v1.ServiceTypeClusterIP},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cfg.ServiceTypeFor(tt.namespace); got != tt.want {
+				t.Errorf("ServiceTypeFor() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWatcher_reload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "barrelman-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+
+	write := func(data string) {
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("apiVersion: barrelman.tfw.io/v1alpha1\nkind: Config\n")
+
+	var changes int
+	w, err := NewWatcher(path, Default(), func(old, cur *Config) { changes++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Unchanged content
+	w.reload()
+	if changes != 0 {
+		t.Errorf("Expected no change, got %d", changes)
+	}
+
+	// Valid change
+	write("apiVersion: barrelman.tfw.io/v1alpha1\nkind: Config\nserviceType: NodePort\n")
+	w.reload()
+	if changes != 1 || w.Config().ServiceType != v1.ServiceTypeNodePort {
+		t.Errorf("Expected config to be changed, got %d changes and service type %s", changes, w.Config().ServiceType)
+	}
+
+	// Invalid change keeps current config
+	write("apiVersion: barrelman.tfw.io/v1alpha1\nkind: Config\nserviceType: Foo\n")
+	w.reload()
+	if changes != 1 || w.Config().ServiceType != v1.ServiceTypeNodePort {
+		t.Errorf("Expected config to be kept, got %d changes and service type %s", changes, w.Config().ServiceType)
+	}
+}
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"barrelman/metrics"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+)
+
+// Watcher periodically re-reads a config file and calls OnChange if it's content changed.
+// Polling is used (instead of inotify) as it works reliably with ConfigMaps mounted as volumes,
+// where kubernetes atomically swaps a symlink to update the content.
+type Watcher struct {
+	path string
+	base *Config
+	// OnChange is called with the old and the new config whenever a valid, changed config has been loaded
+	OnChange func(old, cur *Config)
+
+	lock    sync.RWMutex
+	current *Config
+	data    []byte
+}
+
+// NewWatcher loads the config file at path (on top of base) and returns a Watcher for it
+func NewWatcher(path string, base *Config, onChange func(old, cur *Config)) (*Watcher, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := Parse(data, base)
+	if err != nil {
+		return nil, err
+	}
+	return &Watcher{
+		path:     path,
+		base:     base,
+		OnChange: onChange,
+		current:  cfg,
+		data:     data,
+	}, nil
+}
+
+// Config returns the currently active config
+func (w *Watcher) Config() *Config {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	return w.current
+}
+
+// Run checks the config file for changes every interval until stopCh is closed
+func (w *Watcher) Run(interval time.Duration, stopCh <-chan struct{}) {
+	klog.Infof("Watching config file %s for changes", w.path)
+	wait.Until(w.reload, interval, stopCh)
+}
+
+// reload reads the config file and applies it if it has changed.
+// Invalid configs are logged and the current config stays active.
+func (w *Watcher) reload() {
+	data, err := ioutil.ReadFile(w.path)
+	if err != nil {
+		klog.Errorf("Failed to read config file %s: %v", w.path, err)
+		metrics.ConfigReloads.WithLabelValues("error").Inc()
+		return
+	}
+
+	w.lock.RLock()
+	unchanged := bytes.Equal(data, w.data)
+	w.lock.RUnlock()
+	if unchanged {
+		return
+	}
+
+	cfg, err := Parse(data, w.base)
+	if err != nil {
+		klog.Errorf("Invalid config file %s, keeping current config: %v", w.path, err)
+		metrics.ConfigReloads.WithLabelValues("error").Inc()
+		// Remember the content to not log the same error over and over again
+		w.lock.Lock()
+		w.data = data
+		w.lock.Unlock()
+		return
+	}
+
+	w.lock.Lock()
+	old := w.current
+	w.current = cfg
+	w.data = data
+	w.lock.Unlock()
+
+	if old.Equal(cfg) {
+		return
+	}
+	klog.Infof("Config file %s changed, applying new config", w.path)
+	metrics.ConfigReloads.WithLabelValues("success").Inc()
+	if w.OnChange != nil {
+		w.OnChange(old, cfg)
+	}
+}
@@ -0,0 +1,32 @@
+package controller
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog"
+)
+
+// Reasons of the events barrelman records on local services
+const (
+	EventReasonCreated            = "Created"
+	EventReasonCreateFailed       = "CreateFailed"
+	EventReasonUpdated            = "Updated"
+	EventReasonUpdateFailed       = "UpdateFailed"
+	EventReasonDeleted            = "Deleted"
+	EventReasonDeleteFailed       = "DeleteFailed"
+	EventReasonNotOwned           = "NotOwned"
+	EventReasonNamespaceCollision = "NamespaceCollision"
+	EventReasonEndpointsUpdated   = "EndpointsUpdated"
+	EventReasonEndpointSyncFailed = "EndpointSyncFailed"
+)
+
+// newEventRecorder returns a EventRecorder recording events to the local cluster
+func newEventRecorder(localClient kubernetes.Interface, component string) record.EventRecorder {
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartLogging(klog.V(4).Infof)
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: localClient.CoreV1().Events("")})
+	return eventBroadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: component})
+}
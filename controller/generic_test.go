@@ -2,6 +2,7 @@ package controller
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -15,6 +16,7 @@ import (
 	k8sfake "k8s.io/client-go/kubernetes/fake"
 	core "k8s.io/client-go/testing"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 )
 
 /*
@@ -54,6 +56,12 @@ type baseFixture struct {
 
 	// List of actions to filter out for testing
 	informerFilter []filterAction
+
+	// Enables dry-run mode of the controller if not nil
+	plan *Plan
+
+	// Events recorded by the controller
+	recorder *record.FakeRecorder
 }
 
 func init() {
@@ -172,3 +180,22 @@ func getKey(foo *v1.Service, t *testing.T) string {
 	}
 	return key
 }
+
+// expectEvents checks if the given events (in order) have been recorded
+func (b *baseFixture) expectEvents(expected ...string) {
+	for _, e := range expected {
+		select {
+		case got := <-b.recorder.Events:
+			if !strings.HasPrefix(got, e) {
+				b.t.Errorf("Expected event starting with %q, got %q", e, got)
+			}
+		default:
+			b.t.Errorf("Expected event %q, got none", e)
+		}
+	}
+	select {
+	case got := <-b.recorder.Events:
+		b.t.Errorf("Unexpected event %q", got)
+	default:
+	}
+}
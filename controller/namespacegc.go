@@ -0,0 +1,248 @@
+package controller
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"barrelman/config"
+	"barrelman/metrics"
+	"barrelman/utils"
+
+	v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+)
+
+// NamespaceGC periodically deletes namespaces created by ServiceController (labeled with utils.ResourceLabel)
+// once they contain nothing but barrelman's own objects and no remote cluster has a namespace mapped to them
+// anymore. Namespaces are only deleted if they have been unused for the whole grace period.
+type NamespaceGC struct {
+	localClient   kubernetes.Interface
+	dynamicClient dynamic.Interface
+	sc            *ServiceController
+
+	interval    time.Duration
+	gracePeriod time.Duration
+
+	// now returns the current time (may be replaced in tests)
+	now func() time.Time
+
+	// plan collects the namespaces that would be deleted in dry-run mode
+	plan *Plan
+
+	lock sync.Mutex
+	// unusedSince holds the time every unused namespace has been seen unused first
+	unusedSince map[string]time.Time
+}
+
+// NewNamespaceGC returns a NamespaceGC for the namespaces created by sc, remote namespaces are looked up in
+// the remote clusters of sc
+func NewNamespaceGC(localClient kubernetes.Interface, dynamicClient dynamic.Interface, sc *ServiceController, cfg config.NamespaceGCConfig) *NamespaceGC {
+	return &NamespaceGC{
+		localClient:   localClient,
+		dynamicClient: dynamicClient,
+		sc:            sc,
+		interval:      cfg.Interval.Duration,
+		gracePeriod:   cfg.GracePeriod.Duration,
+		now:           time.Now,
+		unusedSince:   make(map[string]time.Time),
+	}
+}
+
+// SetDryRun enables dry-run mode, namespaces that would be deleted are logged and added to plan instead
+func (g *NamespaceGC) SetDryRun(plan *Plan) {
+	g.plan = plan
+}
+
+// Run collects namespaces every interval until stopCh is closed
+func (g *NamespaceGC) Run(stopCh <-chan struct{}) {
+	klog.Infof("Starting NamespaceGC (interval %s, grace period %s)", g.interval, g.gracePeriod)
+	wait.Until(g.collect, g.interval, stopCh)
+	klog.Info("Shutting down NamespaceGC")
+}
+
+// collect checks all managed namespaces once and deletes the ones unused for longer than the grace period
+func (g *NamespaceGC) collect() {
+	namespaces, err := g.localClient.CoreV1().Namespaces().List(metaV1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(utils.ResourceLabel).String(),
+	})
+	if err != nil {
+		runtime.HandleError(fmt.Errorf("error listing namespaces: %v", err))
+		return
+	}
+	remoteNamespaces, err := g.remoteNamespaces()
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	managed := sets.NewString()
+	var resources []schema.GroupVersionResource
+	for i := range namespaces.Items {
+		ns := &namespaces.Items[i]
+		managed.Insert(ns.Name)
+		if resources == nil && ns.DeletionTimestamp == nil && !remoteNamespaces.Has(ns.Name) {
+			// Resources are discovered once per run, as soon as a namespace may be unused
+			if resources, err = g.namespacedResources(); err != nil {
+				runtime.HandleError(err)
+				return
+			}
+		}
+		if err := g.collectNamespace(ns, remoteNamespaces, resources); err != nil {
+			runtime.HandleError(err)
+		}
+	}
+	// Forget namespaces that are gone (or no longer managed)
+	for name := range g.unusedSince {
+		if !managed.Has(name) {
+			delete(g.unusedSince, name)
+		}
+	}
+}
+
+// collectNamespace deletes ns if it has been unused for the grace period. resources are the namespaced resources
+// to check, see namespacedResources.
+func (g *NamespaceGC) collectNamespace(ns *v1.Namespace, remoteNamespaces sets.String, resources []schema.GroupVersionResource) error {
+	if ns.DeletionTimestamp != nil {
+		return nil
+	}
+	unused := !remoteNamespaces.Has(ns.Name)
+	if unused {
+		var err error
+		if unused, err = g.isEmpty(ns.Name, resources); err != nil {
+			return err
+		}
+	}
+	if !unused {
+		if _, exists := g.unusedSince[ns.Name]; exists {
+			klog.V(3).Infof("Namespace %s is in use again", ns.Name)
+			delete(g.unusedSince, ns.Name)
+		}
+		if g.plan != nil {
+			g.plan.Set("NamespaceGC", ns.Name, nil)
+		}
+		return nil
+	}
+
+	since, exists := g.unusedSince[ns.Name]
+	if !exists {
+		klog.V(3).Infof("Namespace %s is unused, deleting it after %s", ns.Name, g.gracePeriod)
+		g.unusedSince[ns.Name] = g.now()
+		return nil
+	}
+	if g.now().Sub(since) < g.gracePeriod {
+		return nil
+	}
+
+	if g.plan != nil {
+		g.plan.Set("NamespaceGC", ns.Name, []PlannedAction{newPlannedAction(ActionTypeDelete, "Namespace", ns.Name, ns, nil)})
+		return nil
+	}
+	klog.Infof("performing \"%s\" action for namespace %s (unused since %s)", ActionTypeDelete, ns.Name,
+		since.Format(time.RFC3339))
+	if err := g.localClient.CoreV1().Namespaces().Delete(ns.Name, &metaV1.DeleteOptions{}); err != nil {
+		return fmt.Errorf("error deleting namespace %s: %v", ns.Name, err)
+	}
+	delete(g.unusedSince, ns.Name)
+	return nil
+}
+
+// remoteNamespaces returns the local namespaces (see utils.NamespaceMappings) of all namespaces in
+// remote clusters
+func (g *NamespaceGC) remoteNamespaces() (sets.String, error) {
+	namespaces := sets.NewString()
+	for _, remote := range g.sc.getRemotes() {
+		list, err := remote.Client.CoreV1().Namespaces().List(metaV1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("error listing namespaces in remote cluster %s: %v", remote.Name, err)
+		}
+		for _, ns := range list.Items {
+			namespaces.Insert(utils.NamespaceMappings.Local(ns.Name))
+		}
+	}
+	return namespaces, nil
+}
+
+// namespacedResources returns all namespaced resources of local cluster that can be listed. Namespaces can only be
+// checked for being empty if all resources are known, so partial discovery results (e.g. of an unavailable
+// aggregated API) block the GC.
+func (g *NamespaceGC) namespacedResources() ([]schema.GroupVersionResource, error) {
+	resourceLists, err := discovery.ServerPreferredNamespacedResources(g.localClient.Discovery())
+	if err != nil {
+		metrics.NamespaceGCBlocked.Inc()
+		if discovery.IsGroupDiscoveryFailedError(err) {
+			return nil, fmt.Errorf("not collecting namespaces, discovery of some API groups failed "+
+				"(unavailable aggregated API?): %v", err)
+		}
+		return nil, fmt.Errorf("not collecting namespaces, error discovering namespaced resources: %v", err)
+	}
+	resources := make([]schema.GroupVersionResource, 0)
+	for _, list := range resourceLists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			return nil, err
+		}
+		for _, resource := range list.APIResources {
+			if sets.NewString(resource.Verbs...).Has("list") {
+				resources = append(resources, gv.WithResource(resource.Name))
+			}
+		}
+	}
+	return resources, nil
+}
+
+// isEmpty checks if namespace contains nothing (of resources) but barrelman's own objects and the ones kubernetes
+// creates in every namespace (see ignoredObject)
+func (g *NamespaceGC) isEmpty(namespace string, resources []schema.GroupVersionResource) (bool, error) {
+	for _, gvr := range resources {
+		objects, err := g.dynamicClient.Resource(gvr).Namespace(namespace).List(metaV1.ListOptions{})
+		if err != nil {
+			return false, fmt.Errorf("error listing %s in namespace %s: %v", gvr, namespace, err)
+		}
+		for i := range objects.Items {
+			if !ignoredObject(gvr, &objects.Items[i]) {
+				klog.V(4).Infof("Namespace %s is not empty (%s %s)", namespace, gvr.Resource, objects.Items[i].GetName())
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// ignoredObject returns true for objects that don't keep a namespace in use: dummy services, their endpoints
+// and EndpointSlices, events and the objects kubernetes creates in every namespace
+func ignoredObject(gvr schema.GroupVersionResource, obj *unstructured.Unstructured) bool {
+	objLabels := obj.GetLabels()
+	switch gvr.GroupResource().String() {
+	case "services":
+		return objLabels[utils.LabelAnnotationKey] == utils.LabelValueManagedResource
+	case "endpoints":
+		return objLabels[utils.LabelAnnotationKey] == utils.LabelValueTrue
+	case "endpointslices.discovery.k8s.io":
+		// Slices of barrelman and the ones kubernetes mirrors from barrelman's endpoints
+		return objLabels[utils.LabelManagedBy] == utils.LabelValueManagedBy ||
+			objLabels[utils.LabelAnnotationKey] == utils.LabelValueTrue
+	case "events", "events.events.k8s.io":
+		return true
+	case "serviceaccounts":
+		return obj.GetName() == "default"
+	case "configmaps":
+		return obj.GetName() == "kube-root-ca.crt"
+	case "secrets":
+		secretType, _, _ := unstructured.NestedString(obj.Object, "type")
+		return secretType == string(v1.SecretTypeServiceAccountToken)
+	}
+	return false
+}
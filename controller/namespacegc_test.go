@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"barrelman/config"
+	"barrelman/utils"
+
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+)
+
+// gcNewObject returns a unstructured object of kind in serviceNamespace
+func gcNewObject(apiVersion, kind, name string, objLabels map[string]string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetNamespace(serviceNamespace)
+	obj.SetName(name)
+	obj.SetLabels(objLabels)
+	return obj
+}
+
+func TestNamespaceGC(t *testing.T) {
+	dummyService := gcNewObject("v1", "Service", serviceName, utils.ResourceLabel)
+	defaultAccount := gcNewObject("v1", "ServiceAccount", "default", nil)
+
+	tests := []struct {
+		name string
+		// namespace is labeled as managed by barrelman
+		managed bool
+		// remoteExists creates the namespace in the remote cluster
+		remoteExists bool
+		objects      []runtime.Object
+		dryRun       bool
+		wantDelete   bool
+	}{
+		{"Unused", true, false, []runtime.Object{dummyService, defaultAccount}, false, true},
+		{"NotManaged", false, false, []runtime.Object{dummyService}, false, false},
+		{"RemoteExists", true, true, []runtime.Object{dummyService}, false, false},
+		{"NotEmpty", true, false, []runtime.Object{dummyService, gcNewObject("v1", "ConfigMap", "app", nil)}, false, false},
+		{"ManualService", true, false, []runtime.Object{gcNewObject("v1", "Service", "manual", utils.ServiceLabel)}, false, false},
+		{"DryRun", true, false, []runtime.Object{dummyService}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ns := scNewNamespace()
+			if !tt.managed {
+				ns.Labels = nil
+			}
+			localClient := k8sfake.NewSimpleClientset(ns)
+			localClient.Resources = []*metaV1.APIResourceList{{
+				GroupVersion: "v1",
+				APIResources: []metaV1.APIResource{
+					{Name: "services", Namespaced: true, Kind: "Service", Verbs: []string{"list"}},
+					{Name: "serviceaccounts", Namespaced: true, Kind: "ServiceAccount", Verbs: []string{"list"}},
+					{Name: "configmaps", Namespaced: true, Kind: "ConfigMap", Verbs: []string{"list"}},
+					{Name: "bindings", Namespaced: true, Kind: "Binding", Verbs: []string{"create"}},
+				},
+			}}
+			dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), tt.objects...)
+
+			remoteClient := k8sfake.NewSimpleClientset()
+			if tt.remoteExists {
+				remoteClient = k8sfake.NewSimpleClientset(scNewNamespace())
+			}
+			sc := &ServiceController{remotes: []*RemoteCluster{NewRemoteCluster("remote", remoteClient, 0)}}
+
+			now := time.Now()
+			g := NewNamespaceGC(localClient, dynamicClient, sc, config.Default().NamespaceGC)
+			g.now = func() time.Time { return now }
+			var plan *Plan
+			if tt.dryRun {
+				plan = NewPlan()
+				g.SetDryRun(plan)
+			}
+
+			// Namespaces are only deleted after the grace period
+			g.collect()
+			if deleted := gcDeletedNamespaces(localClient); len(deleted) != 0 {
+				t.Fatalf("Expected no deletion within grace period, got %v", deleted)
+			}
+			now = now.Add(g.gracePeriod)
+			g.collect()
+
+			deleted := gcDeletedNamespaces(localClient)
+			if tt.wantDelete && (len(deleted) != 1 || deleted[0] != serviceNamespace) {
+				t.Errorf("Expected namespace %s to be deleted, got %v", serviceNamespace, deleted)
+			} else if !tt.wantDelete && len(deleted) != 0 {
+				t.Errorf("Expected no deletion, got %v", deleted)
+			}
+			if tt.dryRun {
+				planned := plan.List()
+				if len(planned) != 1 || planned[0].Action != ActionTypeDelete || planned[0].Name != serviceNamespace {
+					t.Errorf("Expected planned delete of namespace %s, got %v", serviceNamespace, planned)
+				}
+			}
+		})
+	}
+}
+
+func TestNamespaceGCDiscoversOncePerRun(t *testing.T) {
+	other := scNewNamespace()
+	other.Name = "other"
+	localClient := k8sfake.NewSimpleClientset(scNewNamespace(), other)
+	localClient.Resources = []*metaV1.APIResourceList{{
+		GroupVersion: "v1",
+		APIResources: []metaV1.APIResource{
+			{Name: "services", Namespaced: true, Kind: "Service", Verbs: []string{"list"}},
+		},
+	}}
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	sc := &ServiceController{remotes: []*RemoteCluster{NewRemoteCluster("remote", k8sfake.NewSimpleClientset(), 0)}}
+	g := NewNamespaceGC(localClient, dynamicClient, sc, config.Default().NamespaceGC)
+
+	g.collect()
+	discoveries := 0
+	for _, action := range localClient.Actions() {
+		if action.Matches("get", "group") {
+			discoveries++
+		}
+	}
+	if discoveries != 1 {
+		t.Errorf("Expected a single discovery for 2 unused namespaces, got %d", discoveries)
+	}
+}
+
+// gcDeletedNamespaces returns the names of all namespaces deleted via client
+func gcDeletedNamespaces(client *k8sfake.Clientset) []string {
+	var deleted []string
+	for _, action := range client.Actions() {
+		if action.Matches("delete", "namespaces") {
+			deleted = append(deleted, action.(core.DeleteAction).GetName())
+		}
+	}
+	return deleted
+}
@@ -2,52 +2,90 @@ package controller
 
 import (
 	"fmt"
+	"reflect"
+	"strings"
+	"sync"
 	"time"
 
 	"k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/wait"
 	coreinformers "k8s.io/client-go/informers/core/v1"
 
+	"barrelman/config"
 	"barrelman/metrics"
 	"barrelman/utils"
 
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	corelisters "k8s.io/client-go/listers/core/v1"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog"
 )
 
+// statusRefreshInterval is the minimum age of the last sync status annotation before it's refreshed, if ready
+// nodes and last error did not change (to not write every service on every node event)
+const statusRefreshInterval = 10 * time.Minute
+
 type NodeEndpointController struct {
 	// localClient is the k8s Clientset for the local cluster (where we update service endpoints)
 	localClient kubernetes.Interface
 
-	// remoteClient is the k8s Clientset fot the remote cluster (which we watch for node changes)
-	remoteClient kubernetes.Interface
+	// localDynamicClient is used to maintain EndpointSlices in the local cluster
+	localDynamicClient dynamic.Interface
+
+	// cfg holds the current configuration (EndpointMode, EndpointSliceMaxSize), see SetConfig
+	cfg     *config.Config
+	cfgLock sync.RWMutex
+
+	// plan collects the actions to take instead of performing them, if not nil (dry-run mode)
+	plan *Plan
+
+	// recorder records events on local services
+	recorder record.EventRecorder
+
+	// now returns the current time, used for the last sync status annotation
+	now func() time.Time
+
+	// prober actively checks remote node ports if not nil, see SetProber
+	prober *Prober
+
+	// remotes are the remote clusters we watch for node changes.
+	// Endpoints contain the nodes of all remote clusters exposing a service.
+	remotes     []*RemoteCluster
+	remotesLock sync.RWMutex
 
 	// Informer and Indexer for services and nodes
-	serviceLister             corelisters.ServiceLister
-	nodeLister                corelisters.NodeLister
-	serviceSynced, nodeSynced cache.InformerSynced
+	serviceLister corelisters.ServiceLister
+	serviceSynced cache.InformerSynced
+	remoteSynced  []cache.InformerSynced
 
 	// queue will queue all services whose endpoints may need updates
 	queue workqueue.RateLimitingInterface
 }
 
 func NewNodeEndpointController(
-	localClient, remoteClient kubernetes.Interface,
+	localClient kubernetes.Interface, localDynamicClient dynamic.Interface, remotes []*RemoteCluster,
 	serviceInformer coreinformers.ServiceInformer,
-	nodeInformer coreinformers.NodeInformer) *NodeEndpointController {
+	cfg *config.Config) *NodeEndpointController {
 
 	c := &NodeEndpointController{
-		localClient:  localClient,
-		remoteClient: remoteClient,
-		queue:        workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "NodeEndpoints"),
+		localClient:        localClient,
+		localDynamicClient: localDynamicClient,
+		cfg:                cfg,
+		remotes:            remotes,
+		queue:              workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "NodeEndpoints"),
+		recorder:           newEventRecorder(localClient, "barrelman-node-endpoint-controller"),
+		now:                time.Now,
 	}
 
 	c.serviceLister = serviceInformer.Lister()
@@ -66,6 +104,10 @@ func NewNodeEndpointController(
 			if newService.ResourceVersion == oldService.ResourceVersion {
 				return
 			}
+			if utils.OnlyStatusChanged(oldService, newService) {
+				// Status annotations are written by barrelman itself, syncing again would loop
+				return
+			}
 			klog.V(3).Infof("UPDATE local service %s/%s", newService.GetNamespace(), newService.GetName())
 			c.enqueueService(cur)
 		},
@@ -76,16 +118,174 @@ func NewNodeEndpointController(
 		},
 	})
 
-	c.nodeLister = nodeInformer.Lister()
-	c.nodeSynced = nodeInformer.Informer().HasSynced
+	for _, remote := range remotes {
+		c.remoteSynced = append(c.remoteSynced,
+			remote.Nodes().Informer().HasSynced, remote.Services().Informer().HasSynced)
+		if remote.WatchEndpoints {
+			c.remoteSynced = append(c.remoteSynced, remote.Endpoints().Informer().HasSynced)
+		}
+		if remote.NamespaceSelector != nil {
+			c.remoteSynced = append(c.remoteSynced, remote.Namespaces().Informer().HasSynced)
+		}
+		c.addRemoteClusterHandlers(remote)
+	}
+
+	return c
+}
 
-	nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc:    c.addNode,
-		UpdateFunc: c.updateNode,
-		DeleteFunc: c.deleteNode,
+// addRemoteClusterHandlers registers the event handlers for nodes and services of a remote cluster
+func (c *NodeEndpointController) addRemoteClusterHandlers(remote *RemoteCluster) {
+	remote.Nodes().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { c.addNode(remote, obj) },
+		UpdateFunc: func(old, cur interface{}) { c.updateNode(remote, old, cur) },
+		DeleteFunc: func(obj interface{}) { c.deleteNode(remote, obj) },
 	})
 
-	return c
+	// NodePorts (or load balancer IPs) of remote services are the ports of the endpoints of dummy services
+	remote.Services().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) { c.enqueueRemoteService(remote, obj) },
+		UpdateFunc: func(old, cur interface{}) {
+			newService := cur.(*v1.Service)
+			oldService := old.(*v1.Service)
+			if newService.ResourceVersion == oldService.ResourceVersion {
+				return
+			}
+			if remote.Responsible(newService) == remote.Responsible(oldService) &&
+				utils.IsLoadBalancerMirrored(newService) == utils.IsLoadBalancerMirrored(oldService) &&
+				utils.ServicePortsEqual(newService.Spec.Ports, oldService.Spec.Ports) &&
+				newService.Annotations[utils.PortsAnnotation] == oldService.Annotations[utils.PortsAnnotation] &&
+				reflect.DeepEqual(newService.Status.LoadBalancer, oldService.Status.LoadBalancer) {
+				return
+			}
+			c.enqueueRemoteService(remote, cur)
+		},
+		DeleteFunc: func(obj interface{}) { c.enqueueRemoteService(remote, obj) },
+	})
+	// Services of namespaces that are (no longer) selected have to be exposed (or removed)
+	remote.addNamespaceHandler(func(service *v1.Service) { c.enqueueRemoteService(remote, service) })
+
+	if !remote.WatchEndpoints {
+		return
+	}
+	// Pod IPs of remote endpoints are the endpoints of dummy services in pod IP mode (same namespace and name)
+	remote.Endpoints().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) { c.enqueueRemoteService(remote, obj) },
+		UpdateFunc: func(old, cur interface{}) {
+			if reflect.DeepEqual(old.(*v1.Endpoints).Subsets, cur.(*v1.Endpoints).Subsets) {
+				return
+			}
+			c.enqueueRemoteService(remote, cur)
+		},
+		DeleteFunc: func(obj interface{}) { c.enqueueRemoteService(remote, obj) },
+	})
+}
+
+// SetDryRun enables dry-run mode: Instead of modifying the local cluster, actions are logged and
+// collected in plan. Has to be called before Run.
+func (c *NodeEndpointController) SetDryRun(plan *Plan) {
+	c.plan = plan
+}
+
+// SetProber enables active health checking of remote node ports: Addresses failing probes are moved
+// to NotReadyAddresses. The prober has to be run separately. Has to be called before Run.
+func (c *NodeEndpointController) SetProber(prober *Prober) {
+	c.prober = prober
+	prober.onChange = func(key string) {
+		klog.V(3).Infof("PROBE result changed for service %s", key)
+		c.queue.Add(key)
+		metrics.ObjectsQueued.WithLabelValues("NodeEndpointController", "false").Inc()
+	}
+}
+
+// AddRemoteCluster adds a remote cluster while the controller is running.
+// The informers of remote have to be started and synced already (see RemoteCluster.Start).
+func (c *NodeEndpointController) AddRemoteCluster(remote *RemoteCluster) {
+	c.remotesLock.Lock()
+	c.remotes = append(c.remotes, remote)
+	c.remotesLock.Unlock()
+
+	// Informers replay all existing objects to new handlers, so all services will be enqueued
+	c.addRemoteClusterHandlers(remote)
+}
+
+// RemoveRemoteCluster removes the remote cluster with the given name while the controller is running.
+// Endpoints of all services are updated to no longer contain the clusters nodes.
+func (c *NodeEndpointController) RemoveRemoteCluster(name string) {
+	c.remotesLock.Lock()
+	for i, remote := range c.remotes {
+		if remote.Name == name {
+			c.remotes = append(c.remotes[:i:i], c.remotes[i+1:]...)
+			break
+		}
+	}
+	c.remotesLock.Unlock()
+
+	metrics.NodeCount.DeleteLabelValues(name)
+	c.enqueueAllServices()
+}
+
+// getRemotes returns the current list of remote clusters
+func (c *NodeEndpointController) getRemotes() []*RemoteCluster {
+	c.remotesLock.RLock()
+	defer c.remotesLock.RUnlock()
+	return c.remotes
+}
+
+// getConfig returns the current configuration
+func (c *NodeEndpointController) getConfig() *config.Config {
+	c.cfgLock.RLock()
+	defer c.cfgLock.RUnlock()
+	return c.cfg
+}
+
+// SetConfig applies a new configuration. All services are re-enqueued if the endpoint mode or node selection
+// (selector or address types) changed.
+// Objects of a previous mode (e.g. Endpoints when switching to EndpointSlices) are not removed.
+func (c *NodeEndpointController) SetConfig(cfg *config.Config) {
+	c.cfgLock.Lock()
+	old := c.cfg
+	c.cfg = cfg
+	c.cfgLock.Unlock()
+
+	// Settings (named as in the config file) affecting the endpoints of all services
+	var changed []string
+	if old.EndpointMode != cfg.EndpointMode {
+		changed = append(changed, "endpointMode")
+	}
+	if old.EndpointSliceMaxSize != cfg.EndpointSliceMaxSize {
+		changed = append(changed, "endpointSliceMaxSize")
+	}
+	if old.NodeSelector != cfg.NodeSelector {
+		changed = append(changed, "nodeSelector")
+	}
+	if !reflect.DeepEqual(old.NodeAddressTypes, cfg.NodeAddressTypes) {
+		changed = append(changed, "nodeAddressTypes")
+	}
+	if !reflect.DeepEqual(old.IPFamilies, cfg.IPFamilies) {
+		changed = append(changed, "ipFamilies")
+	}
+	if old.MirrorLoadBalancers != cfg.MirrorLoadBalancers {
+		changed = append(changed, "mirrorLoadBalancers")
+	}
+	if old.OptIn != cfg.OptIn {
+		changed = append(changed, "optIn")
+	}
+	if old.ExportSelector != cfg.ExportSelector {
+		changed = append(changed, "exportSelector")
+	}
+	if !reflect.DeepEqual(old.NamespaceMappings, cfg.NamespaceMappings) {
+		changed = append(changed, "namespaceMappings")
+	}
+	if old.ServiceNameTemplate != cfg.ServiceNameTemplate {
+		changed = append(changed, "serviceNameTemplate")
+	}
+	if !reflect.DeepEqual(old.PortSelections, cfg.PortSelections) {
+		changed = append(changed, "portSelections")
+	}
+	if len(changed) > 0 {
+		klog.Infof("Endpoint settings changed (%s), updating all services", strings.Join(changed, ", "))
+		c.enqueueAllServices()
+	}
 }
 
 func (c *NodeEndpointController) Run(workers int, stopCh <-chan struct{}) error {
@@ -96,7 +296,7 @@ func (c *NodeEndpointController) Run(workers int, stopCh <-chan struct{}) error
 
 	// and wait for their caches to warm up
 	klog.Info("Waiting for informer caches to warm up")
-	if !cache.WaitForCacheSync(stopCh, c.serviceSynced, c.nodeSynced) {
+	if !cache.WaitForCacheSync(stopCh, append(c.remoteSynced, c.serviceSynced)...) {
 		return fmt.Errorf("Failed to wait for caches to sync")
 	}
 
@@ -172,7 +372,7 @@ func (c *NodeEndpointController) processNextItem() bool {
 // syncHandler fetches the object from indexer and does cache warmup
 // In case an error happened, it has to simply return the error.
 // The retry logic should not be part of the business logic.
-func (c *NodeEndpointController) syncHandler(key string) error {
+func (c *NodeEndpointController) syncHandler(key string) (err error) {
 	namespace, name, err := cache.SplitMetaNamespaceKey(key)
 	if err != nil {
 		runtime.HandleError(fmt.Errorf("invalid resource key: %s", key))
@@ -185,31 +385,139 @@ func (c *NodeEndpointController) syncHandler(key string) error {
 		// The resource may no longer exist, in which case we stop processing.
 		if errors.IsNotFound(err) {
 			runtime.HandleError(fmt.Errorf("service '%s' in work queue no longer exists", key))
+			if c.prober != nil {
+				c.prober.SetTargets(key, nil)
+			}
 			return nil
 		}
 
 		return err
 	}
 
-	nodes, err := c.nodeLister.List(labels.Everything())
+	// ExternalName services only point to a hostname, kubernetes ignores their endpoints
+	if service.Spec.Type == v1.ServiceTypeExternalName {
+		klog.V(4).Infof("Skipping ExternalName service %s", key)
+		if c.prober != nil {
+			c.prober.SetTargets(key, nil)
+		}
+		return nil
+	}
+
+	// Make sync failures (like no ready nodes) visible on the service
+	readyNodes := -1
+	defer func() {
+		if err != nil {
+			c.event(service, v1.EventTypeWarning, EventReasonEndpointSyncFailed, "Failed to sync endpoints: %v", err)
+		}
+		c.writeStatus(service, readyNodes, err)
+	}()
+
+	cfg := c.getConfig()
+	families, err := utils.ServiceIPFamilies(service, cfg.IPFamilies)
 	if err != nil {
-		runtime.HandleError(fmt.Errorf("error listing nodes in remote cluster: %#v", err))
+		return err
+	}
+
+	// Collect one subset (and a set of slices) per remote cluster exposing the service and IP family
+	var subsets []v1.EndpointSubset
+	var slices []*utils.EndpointSlice
+	var probeTargets []utils.ProbeTarget
+	for _, remote := range c.getRemotes() {
+		remoteSvc, nodes, exposed, err := c.clusterNodes(remote, service, cfg.NodeSelector)
+		if err != nil {
+			return err
+		}
+		if !exposed {
+			klog.V(4).Infof("Cluster %s does not expose %s", remote.Name, key)
+			continue
+		}
+
+		if utils.IsPodIPMirrored(remoteSvc) {
+			clusterSubsets, clusterSlices, err := c.podEndpoints(remote, service, remoteSvc, families, cfg)
+			if err != nil {
+				return err
+			}
+			subsets = append(subsets, clusterSubsets...)
+			slices = append(slices, clusterSlices...)
+			continue
+		}
+
+		addressTypes, err := utils.NodeAddressTypes(cfg.NodeAddressTypes, remoteSvc, service)
+		if err != nil {
+			return err
+		}
+
+		for _, family := range families {
+			var clusterSlices []*utils.EndpointSlice
+			if cfg.EndpointMode != config.EndpointModeEndpoints {
+				clusterSlices, err = utils.ClusterEndpointSlices(service, remoteSvc, nodes, addressTypes, family,
+					remote.Name, cfg.EndpointSliceMaxSize)
+				if err != nil {
+					klog.Warningf("Skipping cluster %s for %s: %v", remote.Name, key, err)
+					continue
+				}
+				slices = append(slices, clusterSlices...)
+			}
+
+			subset, err := utils.ClusterEndpointSubset(service, remoteSvc, nodes, addressTypes, family)
+			if err != nil {
+				klog.Warningf("Skipping cluster %s for %s: %v", remote.Name, key, err)
+				continue
+			}
+			probeTargets = append(probeTargets, c.applyProbes(service, remoteSvc, &subset, clusterSlices)...)
+			if len(subset.Addresses)+len(subset.NotReadyAddresses) < 1 {
+				klog.V(4).Infof("No valid (ready) %s node IPs found in cluster %s for %s", family, remote.Name, key)
+				continue
+			}
+			subsets = append(subsets, subset)
+		}
+	}
+	readyNodes = countAddresses(subsets)
+	if c.prober != nil {
+		c.prober.SetTargets(key, probeTargets)
+	}
+
+	// In dry-run mode, actions are collected instead of performed
+	var planned []PlannedAction
+	if c.plan != nil {
+		defer func() { c.plan.Set("NodeEndpointController", key, planned) }()
+	}
+
+	if cfg.EndpointMode != config.EndpointModeEndpoints {
+		// EndpointSlices carry the ready state of every node, so they are written even if no node is ready
+		slicesPlanned, err := c.syncEndpointSlices(service, slices)
+		planned = append(planned, slicesPlanned...)
+		if err != nil {
+			return err
+		}
+		if cfg.EndpointMode == config.EndpointModeEndpointSlices {
+			return nil
+		}
+	}
+
+	if readyNodes < 1 {
+		return fmt.Errorf("No valid (ready) node IPs found")
 	}
 
 	endpoint, err := c.localClient.CoreV1().Endpoints(namespace).Get(name, metaV1.GetOptions{})
 	if err != nil {
 		// Check if endpoint object (same name as service) exists
 		if errors.IsNotFound(err) {
-			klog.Infof("Creating new endpoint %s", key)
-			endpoint, err = utils.NewEndpoint(service, nodes)
-			if err != nil {
-				klog.Errorln(err)
-				return err
+			endpoint = utils.NewEndpointWithSubsets(service, subsets)
+			setSkipMirrorLabel(endpoint, cfg.EndpointMode)
+			if c.plan != nil {
+				planned = append(planned, newPlannedAction(ActionTypeAdd, "Endpoints", key, nil, endpoint))
+				return nil
 			}
 
 			// Create endpoint
-			_, err = c.localClient.CoreV1().Endpoints(namespace).Create(endpoint)
-			return err
+			klog.Infof("Creating new endpoint %s", key)
+			if _, err = c.localClient.CoreV1().Endpoints(namespace).Create(endpoint); err != nil {
+				return err
+			}
+			c.event(service, v1.EventTypeNormal, EventReasonEndpointsUpdated,
+				"Created endpoints with %d ready node addresses", countAddresses(subsets))
+			return nil
 		}
 
 		// Unknown error, just return that one
@@ -217,20 +525,279 @@ func (c *NodeEndpointController) syncHandler(key string) error {
 	}
 
 	// Endpoint exists, update it's addresses
-	klog.Infof("Updating endpoint for %s", key)
-	epSubset, err := utils.EndpointSubset(service, nodes)
-	if err != nil {
-		return err
+	currentEndpoint := endpoint.DeepCopy()
+	endpoint.Subsets = subsets
+	setSkipMirrorLabel(endpoint, cfg.EndpointMode)
+	if c.plan != nil {
+		if !reflect.DeepEqual(currentEndpoint, endpoint) {
+			planned = append(planned, newPlannedAction(ActionTypeUpdate, "Endpoints", key, currentEndpoint, endpoint))
+		}
+		return nil
 	}
-	endpoint.Subsets = epSubset
+	klog.Infof("Updating endpoint for %s", key)
 	_, err = c.localClient.CoreV1().Endpoints(namespace).Update(endpoint)
 	if err != nil {
 		return err
 	}
+	if !reflect.DeepEqual(currentEndpoint.Subsets, endpoint.Subsets) {
+		c.event(service, v1.EventTypeNormal, EventReasonEndpointsUpdated,
+			"Updated endpoints to %d ready node addresses", countAddresses(subsets))
+	}
 
 	return nil
 }
 
+// applyProbes moves the addresses of subset failing probes to NotReadyAddresses and marks them as not ready
+// in slices. The probe type is taken from the annotation of remoteSvc or service. Returns the targets to probe.
+// Health is tracked per target (address and port) but applied per address: an address of an Endpoints subset
+// serves all of its ports, so it's not ready for any port if one of its (TCP) ports fails.
+func (c *NodeEndpointController) applyProbes(service, remoteSvc *v1.Service, subset *v1.EndpointSubset, slices []*utils.EndpointSlice) []utils.ProbeTarget {
+	if c.prober == nil {
+		return nil
+	}
+	spec, err := utils.ServiceProbeSpec(remoteSvc, service)
+	if err != nil {
+		klog.Warningf("Not probing service %s/%s: %v", service.GetNamespace(), service.GetName(), err)
+		return nil
+	}
+	targets := utils.ProbeTargets(spec, *subset)
+
+	failing := make(map[string]bool)
+	for _, target := range targets {
+		if !c.prober.Healthy(target) {
+			failing[target.IP] = true
+		}
+	}
+	if len(failing) == 0 {
+		return targets
+	}
+
+	var ready []v1.EndpointAddress
+	for _, address := range subset.Addresses {
+		if failing[address.IP] {
+			subset.NotReadyAddresses = append(subset.NotReadyAddresses, address)
+		} else {
+			ready = append(ready, address)
+		}
+	}
+	subset.Addresses = ready
+	for _, slice := range slices {
+		for i := range slice.Endpoints {
+			if failing[slice.Endpoints[i].Addresses[0]] {
+				notReady := false
+				slice.Endpoints[i].Conditions.Ready = &notReady
+			}
+		}
+	}
+	return targets
+}
+
+// countAddresses returns the number of (ready) addresses in subsets
+func countAddresses(subsets []v1.EndpointSubset) int {
+	count := 0
+	for _, subset := range subsets {
+		count += len(subset.Addresses)
+	}
+	return count
+}
+
+// writeStatus patches the status annotations (ready nodes, last sync, last error) of service, if they changed
+// (see utils.EndpointStatusPatch). readyNodes is not written if it is negative. Nothing is written in dry-run mode
+// and on services not created by barrelman (they belong to users).
+// Failures are only logged, as the status is informational.
+func (c *NodeEndpointController) writeStatus(service *v1.Service, readyNodes int, syncErr error) {
+	if c.plan != nil || !utils.OwnerOfService(service) {
+		return
+	}
+	patch, err := utils.EndpointStatusPatch(service, readyNodes, c.now(), statusRefreshInterval, syncErr)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	if patch == nil {
+		return
+	}
+	_, err = c.localClient.CoreV1().Services(service.GetNamespace()).Patch(service.GetName(), types.MergePatchType, patch)
+	if err != nil && !errors.IsNotFound(err) {
+		runtime.HandleError(fmt.Errorf("failed to write status of service %s/%s: %v",
+			service.GetNamespace(), service.GetName(), err))
+	}
+}
+
+// event records a event on a local service. No events are recorded in dry-run mode.
+func (c *NodeEndpointController) event(service *v1.Service, eventType, reason, messageFmt string, args ...interface{}) {
+	if c.plan != nil {
+		return
+	}
+	c.recorder.Eventf(service, eventType, reason, messageFmt, args...)
+}
+
+// clusterNodes returns the nodes of the remote cluster for service (matching nodeSelector and the node selector
+// annotation of the remote or local service).
+// Services created by barrelman (dummy services) are only exposed by clusters that have the corresponding
+// remote service (which is returned as well), all other services are exposed by every remote cluster.
+// The remote service is looked up via the source annotations or the namespace mappings, see
+// RemoteCluster.MirroredServices.
+func (c *NodeEndpointController) clusterNodes(remote *RemoteCluster, service *v1.Service, nodeSelector string) (remoteSvc *v1.Service, nodes []*v1.Node, exposed bool, err error) {
+	if utils.OwnerOfService(service) {
+		services, err := remote.MirroredServices(service.GetNamespace(), service.GetName(), service)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		for _, s := range services {
+			if !remote.Responsible(s) {
+				continue
+			}
+			if remoteSvc != nil && remoteSvc.GetNamespace() != s.GetNamespace() {
+				return nil, nil, false, fmt.Errorf("remote namespaces %s and %s of cluster %s are both mapped to %s/%s",
+					remoteSvc.GetNamespace(), s.GetNamespace(), remote.Name, service.GetNamespace(), service.GetName())
+			}
+			if remoteSvc == nil {
+				remoteSvc = s
+			}
+		}
+		if remoteSvc == nil {
+			return nil, nil, false, nil
+		}
+	}
+
+	selector, err := utils.NodeSelector(nodeSelector, remoteSvc, service)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	nodes, err = remote.Nodes().Lister().List(selector)
+	if err != nil {
+		runtime.HandleError(fmt.Errorf("error listing nodes in remote cluster %s: %#v", remote.Name, err))
+	}
+
+	return remoteSvc, nodes, true, nil
+}
+
+// podEndpoints returns the subsets (and slices, depending on the endpoint mode) pointing to the pods of the
+// remote endpoints of remoteSvc in pod IP mode. Remote endpoints that don't exist (yet) result in no subsets.
+func (c *NodeEndpointController) podEndpoints(remote *RemoteCluster, service, remoteSvc *v1.Service, families []utils.IPFamily, cfg *config.Config) ([]v1.EndpointSubset, []*utils.EndpointSlice, error) {
+	if !remote.WatchEndpoints {
+		return nil, nil, fmt.Errorf("endpoints of cluster %s are not watched, pod IPs and headless services require a restart", remote.Name)
+	}
+	remoteEndpoints, err := remote.Endpoints().Lister().Endpoints(remoteSvc.GetNamespace()).Get(remoteSvc.GetName())
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	var subsets []v1.EndpointSubset
+	var slices []*utils.EndpointSlice
+	for _, family := range families {
+		subsets = append(subsets, utils.PodEndpointSubsets(service, remoteEndpoints, family)...)
+		if cfg.EndpointMode != config.EndpointModeEndpoints {
+			familySlices, err := utils.PodEndpointSlices(service, remoteEndpoints, family, remote.Name,
+				cfg.EndpointSliceMaxSize)
+			if err != nil {
+				return nil, nil, err
+			}
+			slices = append(slices, familySlices...)
+		}
+	}
+	return subsets, slices, nil
+}
+
+// setSkipMirrorLabel prevents kubernetes from mirroring endpoints to EndpointSlices if barrelman maintains
+// EndpointSlices itself
+func setSkipMirrorLabel(endpoint *v1.Endpoints, mode config.EndpointMode) {
+	if mode != config.EndpointModeBoth || endpoint.Labels[utils.LabelSkipMirror] == "true" {
+		return
+	}
+	// Labels may be shared (utils.ServiceLabel), so don't modify them in place
+	epLabels := make(map[string]string, len(endpoint.Labels)+1)
+	for k, v := range endpoint.Labels {
+		epLabels[k] = v
+	}
+	epLabels[utils.LabelSkipMirror] = "true"
+	endpoint.Labels = epLabels
+}
+
+// syncEndpointSlices creates, updates and deletes the EndpointSlices of service so that exactly slices exist.
+// In dry-run mode, nothing is changed but the actions that would be taken are returned.
+func (c *NodeEndpointController) syncEndpointSlices(service *v1.Service, slices []*utils.EndpointSlice) ([]PlannedAction, error) {
+	client := c.localDynamicClient.Resource(utils.EndpointSliceResource).Namespace(service.GetNamespace())
+	existingList, err := client.List(metaV1.ListOptions{
+		LabelSelector: utils.EndpointSliceSelector(service).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]*unstructured.Unstructured, len(existingList.Items))
+	for i := range existingList.Items {
+		existing[existingList.Items[i].GetName()] = &existingList.Items[i]
+	}
+
+	var planned []PlannedAction
+	for _, slice := range slices {
+		sliceKey := slice.GetNamespace() + "/" + slice.GetName()
+		current, found := existing[slice.GetName()]
+		delete(existing, slice.GetName())
+		var currentSlice *utils.EndpointSlice
+		if found {
+			currentSlice, err = utils.EndpointSliceFromUnstructured(current)
+			if err != nil {
+				return planned, err
+			}
+			if utils.EndpointSlicesEqual(currentSlice, slice) {
+				continue
+			}
+			slice.ResourceVersion = current.GetResourceVersion()
+		}
+
+		if c.plan != nil {
+			if found {
+				planned = append(planned, newPlannedAction(ActionTypeUpdate, "EndpointSlice", sliceKey, currentSlice, slice))
+			} else {
+				planned = append(planned, newPlannedAction(ActionTypeAdd, "EndpointSlice", sliceKey, nil, slice))
+			}
+			continue
+		}
+
+		obj, err := slice.ToUnstructured()
+		if err != nil {
+			return planned, err
+		}
+		verb := "Created"
+		if found {
+			klog.Infof("Updating EndpointSlice %s", sliceKey)
+			_, err = client.Update(obj, metaV1.UpdateOptions{})
+			verb = "Updated"
+		} else {
+			klog.Infof("Creating EndpointSlice %s", sliceKey)
+			_, err = client.Create(obj, metaV1.CreateOptions{})
+		}
+		if err != nil {
+			return planned, err
+		}
+		c.event(service, v1.EventTypeNormal, EventReasonEndpointsUpdated, "%s EndpointSlice %s", verb, slice.GetName())
+	}
+
+	// Remove slices no longer needed (e.g. number of nodes decreased or cluster does no longer expose the service)
+	for name, current := range existing {
+		sliceKey := service.GetNamespace() + "/" + name
+		if c.plan != nil {
+			currentSlice, err := utils.EndpointSliceFromUnstructured(current)
+			if err != nil {
+				return planned, err
+			}
+			planned = append(planned, newPlannedAction(ActionTypeDelete, "EndpointSlice", sliceKey, currentSlice, nil))
+			continue
+		}
+		klog.Infof("Deleting EndpointSlice %s", sliceKey)
+		if err := client.Delete(name, &metaV1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return planned, err
+		}
+		c.event(service, v1.EventTypeNormal, EventReasonEndpointsUpdated, "Deleted EndpointSlice %s", name)
+	}
+	return planned, nil
+}
+
 // enqueueService adds a service (key) to the queue
 func (c *NodeEndpointController) enqueueService(obj interface{}) {
 	key, err := cache.MetaNamespaceKeyFunc(obj)
@@ -242,6 +809,35 @@ func (c *NodeEndpointController) enqueueService(obj interface{}) {
 	metrics.ObjectsQueued.WithLabelValues("NodeEndpointController", "false").Inc()
 }
 
+// enqueueRemoteService adds the local dummy service (key) of a remote service to the queue
+func (c *NodeEndpointController) enqueueRemoteService(remote *RemoteCluster, obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+
+	localKey, err := utils.LocalServiceKey(namespace, name, remote.Name)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	localNamespace, localName, _ := cache.SplitMetaNamespaceKey(localKey)
+
+	// Only dummy services depend on remote services
+	service, err := c.serviceLister.Services(localNamespace).Get(localName)
+	if err != nil || !utils.OwnerOfService(service) {
+		return
+	}
+	klog.V(3).Infof("CHANGE of remote service %s (cluster %s)", key, remote.Name)
+	c.enqueueService(service)
+}
+
 // enqueueAllServices add all services to the queue
 func (c *NodeEndpointController) enqueueAllServices() {
 	// serviceLister is already filtered, so we could use an empty label filter here
@@ -257,27 +853,27 @@ func (c *NodeEndpointController) enqueueAllServices() {
 	}
 }
 
-func (c *NodeEndpointController) addNode(obj interface{}) {
+func (c *NodeEndpointController) addNode(remote *RemoteCluster, obj interface{}) {
 	node := obj.(*v1.Node)
-	klog.V(3).Infof("ADD for Node %s", node.GetName())
-	defer metrics.NodeCount.Inc()
+	klog.V(3).Infof("ADD for Node %s (cluster %s)", node.GetName(), remote.Name)
+	defer metrics.NodeCount.WithLabelValues(remote.Name).Inc()
 
 	// Check if node is ready
 	if !utils.IsNodeReady(node) {
-		klog.Warningf("Node %s is not ready", node.GetName())
+		klog.Warningf("Node %s (cluster %s) is not ready", node.GetName(), remote.Name)
 		return
 	}
 
-	internalIP, err := utils.GetNodeInternalIP(node)
+	address, err := utils.GetNodeAddress(node, c.getConfig().NodeAddressTypes)
 	if err != nil {
 		klog.Errorln(err)
 		return
 	}
-	klog.Infof("Node %s, IP: %s", node.GetName(), internalIP)
+	klog.Infof("Node %s (cluster %s), IP: %s", node.GetName(), remote.Name, address)
 	c.enqueueAllServices()
 }
 
-func (c *NodeEndpointController) updateNode(old, cur interface{}) {
+func (c *NodeEndpointController) updateNode(remote *RemoteCluster, old, cur interface{}) {
 	newNode := cur.(*v1.Node)
 	oldNode := old.(*v1.Node)
 
@@ -290,29 +886,84 @@ func (c *NodeEndpointController) updateNode(old, cur interface{}) {
 	var relevantChange bool
 	if utils.IsNodeReady(newNode) != utils.IsNodeReady(oldNode) {
 		relevantChange = true
+	} else if reflect.DeepEqual(newNode.Labels, oldNode.Labels) &&
+		reflect.DeepEqual(newNode.Annotations, oldNode.Annotations) &&
+		reflect.DeepEqual(newNode.Status.Addresses, oldNode.Status.Addresses) {
+		// Most updates are status heartbeats, skip them before looking at all services (see nodeFilters)
+		return
 	}
 
+	// Only changes of the addresses chosen by the address types in use (which may reference node annotations) and
+	// of node selector matches are relevant.
 	// We ignore ip not found errors here because we can't do anything about it
 	// If one of the states does not have an IP, we trigger a sync
 	// If none of the states has an IP, we don't trigger a sync
-	newNodeIP, _ := utils.GetNodeInternalIP(newNode)
-	oldNodeIP, _ := utils.GetNodeInternalIP(oldNode)
-	if newNodeIP != oldNodeIP {
-		relevantChange = true
+	addressTypes, selectors := c.nodeFilters(remote)
+	for _, types := range addressTypes {
+		for _, family := range []utils.IPFamily{utils.IPv4, utils.IPv6} {
+			newNodeIP, _ := utils.GetNodeFamilyAddress(newNode, types, family)
+			oldNodeIP, _ := utils.GetNodeFamilyAddress(oldNode, types, family)
+			if newNodeIP != oldNodeIP {
+				relevantChange = true
+			}
+		}
+	}
+	for _, selector := range selectors {
+		if selector.Matches(labels.Set(newNode.Labels)) != selector.Matches(labels.Set(oldNode.Labels)) {
+			relevantChange = true
+		}
 	}
 
 	if !relevantChange {
 		return
 	}
 
-	klog.V(3).Infof("UPDATE for Node %s", newNode.GetName())
+	klog.V(3).Infof("UPDATE for Node %s (cluster %s)", newNode.GetName(), remote.Name)
 	c.enqueueAllServices()
 }
 
-func (c *NodeEndpointController) deleteNode(obj interface{}) {
+// nodeFilters returns the node address types and node selectors in use for the nodes of remote: the configured
+// ones and the ones of the annotations of local services and the remote services of remote.
+// Invalid annotations are skipped, they fail the sync of their service anyway.
+func (c *NodeEndpointController) nodeFilters(remote *RemoteCluster) ([][]string, []labels.Selector) {
+	cfg := c.getConfig()
+	addressTypes := [][]string{cfg.NodeAddressTypes}
+	seenTypes := sets.NewString(strings.Join(cfg.NodeAddressTypes, ","))
+	var selectors []labels.Selector
+	if selector, err := labels.Parse(cfg.NodeSelector); err == nil {
+		selectors = append(selectors, selector)
+	}
+
+	services, err := c.serviceLister.List(labels.Everything())
+	if err != nil {
+		runtime.HandleError(err)
+	}
+	remoteServices, err := remote.Services().Lister().List(labels.Everything())
+	if err != nil {
+		runtime.HandleError(err)
+	}
+	seenSelectors := sets.NewString()
+	for _, service := range append(services, remoteServices...) {
+		if value, ok := service.Annotations[utils.NodeAddressTypesAnnotation]; ok && !seenTypes.Has(value) {
+			seenTypes.Insert(value)
+			if types, err := utils.ParseNodeAddressTypes(value); err == nil {
+				addressTypes = append(addressTypes, types)
+			}
+		}
+		if value, ok := service.Annotations[utils.NodeSelectorAnnotation]; ok && !seenSelectors.Has(value) {
+			seenSelectors.Insert(value)
+			if selector, err := utils.NodeSelector(cfg.NodeSelector, service); err == nil {
+				selectors = append(selectors, selector)
+			}
+		}
+	}
+	return addressTypes, selectors
+}
+
+func (c *NodeEndpointController) deleteNode(remote *RemoteCluster, obj interface{}) {
 	node := obj.(*v1.Node)
-	klog.V(3).Infof("DELETE for Node %s", node.GetName())
-	defer metrics.NodeCount.Dec()
+	klog.V(3).Infof("DELETE for Node %s (cluster %s)", node.GetName(), remote.Name)
+	defer metrics.NodeCount.WithLabelValues(remote.Name).Dec()
 
 	c.enqueueAllServices()
 }
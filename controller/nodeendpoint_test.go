@@ -1,8 +1,16 @@
 package controller
 
 import (
+	"barrelman/config"
 	"barrelman/utils"
+	"fmt"
+	"reflect"
+	"sort"
 	"testing"
+	"time"
+
+	"github.com/davecgh/go-spew/spew"
+	"k8s.io/apimachinery/pkg/util/diff"
 
 	"k8s.io/apimachinery/pkg/util/intstr"
 
@@ -12,9 +20,13 @@ import (
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
 	kubeinformers "k8s.io/client-go/informers"
 	k8sfake "k8s.io/client-go/kubernetes/fake"
 	core "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 )
 
 /*
@@ -26,8 +38,27 @@ type necFixture struct {
 	baseFixture
 
 	// Objects to put in the stores
-	serviceLister []*v1.Service
-	nodeLister    []*v1.Node
+	serviceLister       []*v1.Service
+	nodeLister          []*v1.Node
+	remoteServiceLister []*v1.Service
+	// Remote endpoints, the endpoints informer is only enabled if not empty (pod IP mode)
+	remoteEndpointsLister []*v1.Endpoints
+
+	// Objects to put in the stores of a second remote cluster (only created if not empty)
+	secondNodeLister          []*v1.Node
+	secondRemoteServiceLister []*v1.Service
+
+	// EndpointSlices are maintained via the dynamic client
+	localDynamicClient  *dynamicfake.FakeDynamicClient
+	localDynamicObjects []runtime.Object
+
+	cfg *config.Config
+
+	// Overrides the clock of the controller if not nil
+	now func() time.Time
+
+	// Enables probing if not nil
+	prober *Prober
 }
 
 func newNecFixture(t *testing.T) *necFixture {
@@ -42,8 +73,11 @@ func newNecFixture(t *testing.T) *necFixture {
 				{"watch", "nodes"},
 				{"list", "services"},
 				{"watch", "services"},
+				// Status annotations, see TestWritesStatus
+				{"patch", "services"},
 			},
 		},
+		cfg: config.Default(),
 	}
 	return f
 }
@@ -51,19 +85,38 @@ func newNecFixture(t *testing.T) *necFixture {
 func (f *necFixture) newController() (*NodeEndpointController, kubeinformers.SharedInformerFactory, kubeinformers.SharedInformerFactory) {
 	f.localClient = k8sfake.NewSimpleClientset(f.localObjects...)
 	f.remoteClient = k8sfake.NewSimpleClientset(f.remoteObjects...)
+	f.localDynamicClient = dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), f.localDynamicObjects...)
 
 	serviceInformer := kubeinformers.NewSharedInformerFactory(f.localClient, noResyncPeriodFunc())
-	nodeInformer := kubeinformers.NewSharedInformerFactory(f.remoteClient, noResyncPeriodFunc())
+	remote := NewRemoteCluster("remote", f.remoteClient, noResyncPeriodFunc())
+	remote.WatchEndpoints = len(f.remoteEndpointsLister) > 0
+	nodeInformer := remote.InformerFactory
+	remotes := []*RemoteCluster{remote}
+	if len(f.secondNodeLister) > 0 || len(f.secondRemoteServiceLister) > 0 {
+		remotes = append(remotes, NewRemoteCluster("second", k8sfake.NewSimpleClientset(), noResyncPeriodFunc()))
+	}
 
 	c := NewNodeEndpointController(
 		f.localClient,
-		f.remoteClient,
+		f.localDynamicClient,
+		remotes,
 		serviceInformer.Core().V1().Services(),
-		nodeInformer.Core().V1().Nodes(),
+		f.cfg,
 	)
 
 	c.serviceSynced = alwaysReady
-	c.nodeSynced = alwaysReady
+	c.remoteSynced = []cache.InformerSynced{alwaysReady}
+	f.recorder = record.NewFakeRecorder(100)
+	c.recorder = f.recorder
+	if f.plan != nil {
+		c.SetDryRun(f.plan)
+	}
+	if f.now != nil {
+		c.now = f.now
+	}
+	if f.prober != nil {
+		c.SetProber(f.prober)
+	}
 
 	// Preload test objects into informers
 	for _, s := range f.serviceLister {
@@ -73,16 +126,34 @@ func (f *necFixture) newController() (*NodeEndpointController, kubeinformers.Sha
 		}
 	}
 
-	for _, n := range f.nodeLister {
-		err := nodeInformer.Core().V1().Nodes().Informer().GetIndexer().Add(n)
-		if err != nil {
-			f.t.Errorf("Failed to add node: %v", err)
+	f.preloadRemote(remote, f.nodeLister, f.remoteServiceLister)
+	for _, e := range f.remoteEndpointsLister {
+		if err := remote.Endpoints().Informer().GetIndexer().Add(e); err != nil {
+			f.t.Errorf("Failed to add remote endpoints: %v", err)
 		}
 	}
+	if len(remotes) > 1 {
+		f.preloadRemote(remotes[1], f.secondNodeLister, f.secondRemoteServiceLister)
+	}
 
 	return c, serviceInformer, nodeInformer
 }
 
+func (f *necFixture) preloadRemote(remote *RemoteCluster, nodes []*v1.Node, services []*v1.Service) {
+	for _, n := range nodes {
+		err := remote.Nodes().Informer().GetIndexer().Add(n)
+		if err != nil {
+			f.t.Errorf("Failed to add node: %v", err)
+		}
+	}
+	for _, s := range services {
+		err := remote.Services().Informer().GetIndexer().Add(s)
+		if err != nil {
+			f.t.Errorf("Failed to add remote service: %v", err)
+		}
+	}
+}
+
 func (f *necFixture) run(serviceName string) {
 	f.runController(serviceName, false)
 }
@@ -242,6 +313,201 @@ func TestCreatesEndpoint(t *testing.T) {
 	f.expectCreateEndpointAction(expEndpoint)
 
 	f.run(getKey(service, t))
+	f.expectEvents("Normal EndpointsUpdated Created endpoints with 1 ready node addresses")
+}
+
+func TestNoReadyNodes(t *testing.T) {
+	f := newNecFixture(t)
+
+	brokenNode := necNewNode(randomdata.IpV4Address(), false)
+	f.nodeLister = append(f.nodeLister, brokenNode)
+	f.remoteObjects = append(f.remoteObjects, brokenNode)
+
+	service := necNewService()
+	f.serviceLister = append(f.serviceLister, service)
+	f.localObjects = append(f.localObjects, service)
+
+	f.runController(getKey(service, t), true)
+	f.expectEvents("Warning EndpointSyncFailed Failed to sync endpoints: No valid (ready) node IPs found")
+}
+
+func TestSkipsExternalNameService(t *testing.T) {
+	f := newNecFixture(t)
+	f.nodeLister = append(f.nodeLister, necNewNode(randomdata.IpV4Address(), true))
+
+	service := necNewService()
+	service.Labels = utils.ResourceLabel
+	service.Spec = v1.ServiceSpec{Type: v1.ServiceTypeExternalName, ExternalName: "foo.remote.example.com"}
+	f.serviceLister = append(f.serviceLister, service)
+	f.localObjects = append(f.localObjects, service)
+
+	// Expect no actions
+	f.run(getKey(service, t))
+}
+
+func TestWritesStatus(t *testing.T) {
+	now := time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		ready       bool
+		userService bool
+		annotations map[string]string
+		wantError   bool
+		patch       string
+	}{
+		{
+			"Synced",
+			true,
+			false,
+			nil,
+			false,
+			`{"metadata":{"annotations":{"tfw.io/barrelman.last-error":null,` +
+				`"tfw.io/barrelman.last-sync":"2019-05-01T12:00:00Z","tfw.io/barrelman.ready-nodes":"1"}}}`,
+		},
+		{
+			"NoReadyNodes",
+			false,
+			false,
+			nil,
+			true,
+			`{"metadata":{"annotations":{"tfw.io/barrelman.last-error":"No valid (ready) node IPs found",` +
+				`"tfw.io/barrelman.ready-nodes":"0"}}}`,
+		},
+		{
+			"Unchanged",
+			true,
+			false,
+			map[string]string{"tfw.io/barrelman.last-sync": "2019-05-01T11:59:00Z", "tfw.io/barrelman.ready-nodes": "1"},
+			false,
+			"",
+		},
+		{
+			"UserService",
+			true,
+			true,
+			nil,
+			false,
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newNecFixture(t)
+			f.now = func() time.Time { return now }
+			// Don't filter status patches
+			f.informerFilter = f.informerFilter[:len(f.informerFilter)-1]
+
+			nodeIP := randomdata.IpV4Address()
+			node := necNewNode(nodeIP, tt.ready)
+			f.nodeLister = append(f.nodeLister, node)
+			f.remoteObjects = append(f.remoteObjects, node)
+
+			// Status is only written on services created by barrelman
+			service := necNewService()
+			if !tt.userService {
+				service.Labels = utils.ResourceLabel
+				f.remoteServiceLister = append(f.remoteServiceLister, necNewRemoteService(portNodePort))
+			}
+			service.Annotations = tt.annotations
+			f.serviceLister = append(f.serviceLister, service)
+			f.localObjects = append(f.localObjects, service)
+
+			if tt.ready {
+				f.expectCreateEndpointAction(necNewEndpoint([]string{nodeIP}))
+			}
+			if tt.patch != "" {
+				f.localExpectedActions = append(f.localExpectedActions, core.NewPatchAction(
+					schema.GroupVersionResource{Resource: "services"}, serviceNamespace, serviceName,
+					types.MergePatchType, []byte(tt.patch)))
+			}
+
+			f.runController(getKey(service, t), tt.wantError)
+		})
+	}
+}
+
+func TestProbeFailingNode(t *testing.T) {
+	f := newNecFixture(t)
+
+	goodIP, badIP := "10.0.0.1", "10.0.0.2"
+	for _, ip := range []string{goodIP, badIP} {
+		node := necNewNode(ip, true)
+		f.nodeLister = append(f.nodeLister, node)
+		f.remoteObjects = append(f.remoteObjects, node)
+	}
+
+	service := necNewService()
+	service.Annotations = map[string]string{utils.ProbeAnnotation: "http:/healthz"}
+	f.serviceLister = append(f.serviceLister, service)
+	f.localObjects = append(f.localObjects, service)
+	key := getKey(service, t)
+
+	// Probe the node port of badIP until it is marked as failing
+	cfg := config.Default().Probe
+	cfg.FailureThreshold = 1
+	f.prober = NewProber(cfg)
+	spec := utils.ProbeSpec{Type: utils.ProbeTypeHTTP, Path: "/healthz"}
+	badTarget := utils.ProbeTarget{ProbeSpec: spec, IP: badIP, Port: portNodePort}
+	f.prober.SetTargets(key, []utils.ProbeTarget{badTarget})
+	f.prober.probe = func(target utils.ProbeTarget, timeout time.Duration) error {
+		if target == badTarget {
+			return fmt.Errorf("connection refused")
+		}
+		return nil
+	}
+	f.prober.probeAll()
+
+	expEndpoint := necNewEndpoint([]string{goodIP})
+	expEndpoint.Subsets[0].NotReadyAddresses = []v1.EndpointAddress{{IP: badIP}}
+	f.expectCreateEndpointAction(expEndpoint)
+
+	f.run(key)
+
+	// Both nodes are probed now
+	if got := f.prober.services[key]; len(got) != 2 {
+		t.Errorf("Expected 2 probe targets, got %v", got)
+	}
+}
+
+func TestProbeSkipsUDPPorts(t *testing.T) {
+	f := newNecFixture(t)
+
+	nodeIP := "10.0.0.1"
+	node := necNewNode(nodeIP, true)
+	f.nodeLister = append(f.nodeLister, node)
+	f.remoteObjects = append(f.remoteObjects, node)
+
+	service := necNewService()
+	service.Spec.Ports = []v1.ServicePort{
+		{Name: "dns-udp", Port: 53, TargetPort: intstr.FromInt(30053), Protocol: v1.ProtocolUDP},
+		{Name: "dns-tcp", Port: 53, TargetPort: intstr.FromInt(30054), Protocol: v1.ProtocolTCP},
+	}
+	f.serviceLister = append(f.serviceLister, service)
+	f.localObjects = append(f.localObjects, service)
+	key := getKey(service, t)
+
+	// UDP ports can't be probed via TCP, a dial would always fail
+	f.prober = NewProber(config.Default().Probe)
+	f.prober.probe = func(target utils.ProbeTarget, timeout time.Duration) error {
+		if target.Port == 30053 {
+			return fmt.Errorf("connection refused")
+		}
+		return nil
+	}
+
+	expEndpoint := necNewEndpoint([]string{nodeIP})
+	expEndpoint.Subsets[0].Ports = []v1.EndpointPort{
+		{Name: "dns-udp", Port: 30053, Protocol: v1.ProtocolUDP},
+		{Name: "dns-tcp", Port: 30054, Protocol: v1.ProtocolTCP},
+	}
+	f.expectCreateEndpointAction(expEndpoint)
+
+	f.run(key)
+
+	want := []utils.ProbeTarget{{ProbeSpec: utils.ProbeSpec{Type: utils.ProbeTypeTCP}, IP: nodeIP, Port: 30054}}
+	if got := f.prober.services[key]; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected probe targets %v, got %v", want, got)
+	}
 }
 
 func TestAddNewNode(t *testing.T) {
@@ -274,6 +540,188 @@ func TestAddNewNode(t *testing.T) {
 	f.run(getKey(service, t))
 }
 
+func TestNodeSelector(t *testing.T) {
+	tests := []struct {
+		name       string
+		global     string
+		annotation string
+		wantIPs    []string
+	}{
+		{"Global", "pool=ingress", "", []string{"10.0.0.1", "10.0.0.2"}},
+		{"GlobalAndAnnotation", "pool=ingress", "zone=a", []string{"10.0.0.1"}},
+		{"Annotation", "", "zone=b", []string{"10.0.0.2", "10.0.0.3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newNecFixture(t)
+			f.cfg.NodeSelector = tt.global
+
+			for i, nodeLabels := range []map[string]string{
+				{"pool": "ingress", "zone": "a"},
+				{"pool": "ingress", "zone": "b"},
+				{"pool": "default", "zone": "b"},
+			} {
+				node := necNewNode(fmt.Sprintf("10.0.0.%d", i+1), true)
+				node.Labels = nodeLabels
+				f.nodeLister = append(f.nodeLister, node)
+				f.remoteObjects = append(f.remoteObjects, node)
+			}
+
+			service := necNewService()
+			if tt.annotation != "" {
+				service.Annotations = map[string]string{utils.NodeSelectorAnnotation: tt.annotation}
+			}
+			f.serviceLister = append(f.serviceLister, service)
+			f.localObjects = append(f.localObjects, service)
+
+			c, sI, nI := f.newController()
+			stopCh := make(chan struct{})
+			defer close(stopCh)
+			sI.Start(stopCh)
+			nI.Start(stopCh)
+			if err := c.syncHandler(getKey(service, t)); err != nil {
+				t.Fatalf("error syncing service: %v", err)
+			}
+
+			endpoint, err := f.localClient.CoreV1().Endpoints(serviceNamespace).Get(serviceName, metaV1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ips []string
+			for _, address := range endpoint.Subsets[0].Addresses {
+				ips = append(ips, address.IP)
+			}
+			sort.Strings(ips)
+			if !reflect.DeepEqual(ips, tt.wantIPs) {
+				t.Errorf("Expected endpoint addresses %v, got %v", tt.wantIPs, ips)
+			}
+		})
+	}
+}
+
+func TestNodeAddressTypes(t *testing.T) {
+	f := newNecFixture(t)
+	f.cfg.NodeAddressTypes = []string{"ExternalIP", "InternalIP"}
+
+	// Only the first node has an external IP
+	external := necNewNode("10.0.0.1", true)
+	external.Status.Addresses = append(external.Status.Addresses, v1.NodeAddress{Type: v1.NodeExternalIP, Address: "1.2.3.4"})
+	internal := necNewNode("10.0.0.2", true)
+	f.nodeLister = append(f.nodeLister, external, internal)
+	f.remoteObjects = append(f.remoteObjects, external, internal)
+
+	// Service overrides the global address types
+	service := necNewService()
+	service.Annotations = map[string]string{utils.NodeAddressTypesAnnotation: "ExternalIP"}
+	f.serviceLister = append(f.serviceLister, service)
+	f.localObjects = append(f.localObjects, service)
+
+	f.expectCreateEndpointAction(necNewEndpoint([]string{"1.2.3.4"}))
+	f.run(getKey(service, t))
+}
+
+func TestUpdateNode(t *testing.T) {
+	tests := []struct {
+		name         string
+		nodeSelector string
+		annotations  map[string]string
+		modify       func(node *v1.Node)
+		want         bool
+	}{
+		{
+			"Unchanged",
+			"", nil,
+			func(node *v1.Node) {},
+			false,
+		},
+		{
+			"Heartbeat",
+			"pool=ingress", nil,
+			func(node *v1.Node) { node.Status.Conditions[0].LastHeartbeatTime = metaV1.Now() },
+			false,
+		},
+		{
+			"NotReady",
+			"", nil,
+			func(node *v1.Node) { node.Status.Conditions[0].Status = v1.ConditionFalse },
+			true,
+		},
+		{
+			"LabelsWithoutSelector",
+			"", nil,
+			func(node *v1.Node) { node.Labels = map[string]string{"pool": "ingress"} },
+			false,
+		},
+		{
+			"LabelsMatchingSelector",
+			"pool=ingress", nil,
+			func(node *v1.Node) { node.Labels = map[string]string{"pool": "ingress"} },
+			true,
+		},
+		{
+			"LabelsMatchingServiceSelector",
+			"", map[string]string{utils.NodeSelectorAnnotation: "pool=ingress"},
+			func(node *v1.Node) { node.Labels = map[string]string{"pool": "ingress"} },
+			true,
+		},
+		{
+			"UnusedAddress",
+			"", nil,
+			func(node *v1.Node) {
+				node.Status.Addresses = append(node.Status.Addresses, v1.NodeAddress{Type: v1.NodeExternalIP, Address: "1.2.3.4"})
+			},
+			false,
+		},
+		{
+			"Address",
+			"", nil,
+			func(node *v1.Node) { node.Status.Addresses[0].Address = "10.0.0.2" },
+			true,
+		},
+		{
+			"ServiceAddressTypes",
+			"", map[string]string{utils.NodeAddressTypesAnnotation: "ExternalIP"},
+			func(node *v1.Node) {
+				node.Status.Addresses = append(node.Status.Addresses, v1.NodeAddress{Type: v1.NodeExternalIP, Address: "1.2.3.4"})
+			},
+			true,
+		},
+		{
+			"UnusedAnnotation",
+			"", nil,
+			func(node *v1.Node) { node.Annotations = map[string]string{"example.com/public-ip": "1.2.3.4"} },
+			false,
+		},
+		{
+			"AnnotationAddress",
+			"", map[string]string{utils.NodeAddressTypesAnnotation: "annotation:example.com/public-ip,InternalIP"},
+			func(node *v1.Node) { node.Annotations = map[string]string{"example.com/public-ip": "1.2.3.4"} },
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newNecFixture(t)
+			f.cfg.NodeSelector = tt.nodeSelector
+			service := necNewService()
+			service.Annotations = tt.annotations
+			f.serviceLister = append(f.serviceLister, service)
+			c, _, _ := f.newController()
+
+			old := necNewNode("10.0.0.1", true)
+			old.ResourceVersion = "1"
+			cur := old.DeepCopy()
+			cur.ResourceVersion = "2"
+			tt.modify(cur)
+
+			c.updateNode(c.getRemotes()[0], old, cur)
+			if got := c.queue.Len() == 1; got != tt.want {
+				t.Errorf("updateNode() enqueued services = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestBunchOfServices(t *testing.T) {
 	f := newNecFixture(t)
 
@@ -298,3 +746,515 @@ func TestBunchOfServices(t *testing.T) {
 
 	f.runControllerTestQueue(2, 0)
 }
+
+func necNewRemoteService(nodePort int32) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      serviceName,
+			Namespace: serviceNamespace,
+		},
+		Spec: v1.ServiceSpec{
+			Type: v1.ServiceTypeNodePort,
+			Ports: []v1.ServicePort{
+				{
+					Name:       portName,
+					Port:       portNum,
+					TargetPort: intstr.FromInt(portNum),
+					NodePort:   nodePort,
+				},
+			},
+		},
+	}
+}
+
+func TestMultipleClusters(t *testing.T) {
+	f := newNecFixture(t)
+
+	nodeIP := randomdata.IpV4Address()
+	node := necNewNode(nodeIP, true)
+	f.nodeLister = append(f.nodeLister, node)
+	f.remoteServiceLister = append(f.remoteServiceLister, necNewRemoteService(portNodePort))
+
+	secondNodeIP := randomdata.IpV4Address()
+	secondNode := necNewNode(secondNodeIP, true)
+	f.secondNodeLister = append(f.secondNodeLister, secondNode)
+	f.secondRemoteServiceLister = append(f.secondRemoteServiceLister, necNewRemoteService(portNodePort+1))
+
+	// Dummy service created by barrelman
+	service := necNewService()
+	service.Labels = utils.ResourceLabel
+	f.serviceLister = append(f.serviceLister, service)
+	f.localObjects = append(f.localObjects, service)
+
+	// Expect one subset per cluster with the clusters NodePort
+	expEndpoint := necNewEndpoint([]string{nodeIP})
+	expEndpoint.Subsets = append(expEndpoint.Subsets, v1.EndpointSubset{
+		Addresses: []v1.EndpointAddress{{IP: secondNodeIP}},
+		Ports:     []v1.EndpointPort{{Port: portNodePort + 1, Name: portName}},
+	})
+	f.expectCreateEndpointAction(expEndpoint)
+
+	f.run(getKey(service, t))
+}
+
+func TestLoadBalancer(t *testing.T) {
+	f := newNecFixture(t)
+
+	// Nodes are not used for load balancer services
+	f.nodeLister = append(f.nodeLister, necNewNode(randomdata.IpV4Address(), true))
+	remoteService := necNewRemoteService(portNodePort)
+	remoteService.Annotations = map[string]string{utils.LoadBalancerAnnotation: "true"}
+	remoteService.Spec.Type = v1.ServiceTypeLoadBalancer
+	remoteService.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{
+		{IP: "203.0.113.10"},
+		{Hostname: "lb.example.com"},
+	}
+	f.remoteServiceLister = append(f.remoteServiceLister, remoteService)
+
+	service := necNewService()
+	service.Labels = utils.ResourceLabel
+	service.Spec.Ports[0].TargetPort = intstr.FromInt(portNum)
+	f.serviceLister = append(f.serviceLister, service)
+	f.localObjects = append(f.localObjects, service)
+
+	// Expect the load balancer IP with the service port
+	expEndpoint := necNewEndpoint([]string{"203.0.113.10"})
+	expEndpoint.Subsets[0].Ports[0].Port = portNum
+	f.expectCreateEndpointAction(expEndpoint)
+
+	f.run(getKey(service, t))
+}
+
+func TestPodIPs(t *testing.T) {
+	utils.SetMirrorPodIPs(true)
+	defer utils.SetMirrorPodIPs(false)
+
+	tests := []struct {
+		name string
+		mode config.EndpointMode
+	}{
+		{"Endpoints", config.EndpointModeEndpoints},
+		{"EndpointSlices", config.EndpointModeEndpointSlices},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newNecFixture(t)
+			f.cfg.EndpointMode = tt.mode
+
+			// Nodes are not used in pod IP mode
+			f.nodeLister = append(f.nodeLister, necNewNode(randomdata.IpV4Address(), true))
+			remoteService := necNewRemoteService(portNodePort)
+			remoteService.Spec.Type = v1.ServiceTypeClusterIP
+			f.remoteServiceLister = append(f.remoteServiceLister, remoteService)
+			f.remoteEndpointsLister = append(f.remoteEndpointsLister, &v1.Endpoints{
+				ObjectMeta: metaV1.ObjectMeta{Name: serviceName, Namespace: serviceNamespace},
+				Subsets: []v1.EndpointSubset{{
+					Addresses:         []v1.EndpointAddress{{IP: "10.8.0.1"}},
+					NotReadyAddresses: []v1.EndpointAddress{{IP: "10.8.0.2"}},
+					Ports:             []v1.EndpointPort{{Name: portName, Port: 8080, Protocol: v1.ProtocolTCP}},
+				}},
+			})
+
+			service := necNewService()
+			service.Labels = utils.ResourceLabel
+			f.serviceLister = append(f.serviceLister, service)
+			f.localObjects = append(f.localObjects, service)
+
+			if tt.mode == config.EndpointModeEndpoints {
+				// Readiness of the remote pods is kept
+				expEndpoint := necNewEndpoint(nil)
+				expEndpoint.Subsets = []v1.EndpointSubset{{
+					Addresses:         []v1.EndpointAddress{{IP: "10.8.0.1"}},
+					NotReadyAddresses: []v1.EndpointAddress{{IP: "10.8.0.2"}},
+					Ports:             []v1.EndpointPort{{Name: portName, Port: 8080, Protocol: v1.ProtocolTCP}},
+				}}
+				f.expectCreateEndpointAction(expEndpoint)
+			}
+
+			f.run(getKey(service, t))
+
+			if tt.mode == config.EndpointModeEndpointSlices {
+				slice := f.listEndpointSlices()[serviceName+"-remote-0"]
+				if slice == nil {
+					t.Fatalf("Expected slice %s-remote-0", serviceName)
+				}
+				want := []utils.EndpointSliceEndpoint{necSliceEndpoint("10.8.0.1", true), necSliceEndpoint("10.8.0.2", false)}
+				if !reflect.DeepEqual(slice.Endpoints, want) || *slice.Ports[0].Port != 8080 {
+					t.Errorf("Expected pod endpoints on port 8080, got %v (ports %v)", slice.Endpoints, slice.Ports)
+				}
+			}
+		})
+	}
+}
+
+func TestHeadless(t *testing.T) {
+	utils.SetMirrorHeadless(true)
+	defer utils.SetMirrorHeadless(false)
+	f := newNecFixture(t)
+
+	remoteService := necNewRemoteService(0)
+	remoteService.Spec.Type = v1.ServiceTypeClusterIP
+	remoteService.Spec.ClusterIP = v1.ClusterIPNone
+	remoteService.Spec.Ports = nil
+	f.remoteServiceLister = append(f.remoteServiceLister, remoteService)
+	f.remoteEndpointsLister = append(f.remoteEndpointsLister, &v1.Endpoints{
+		ObjectMeta: metaV1.ObjectMeta{Name: serviceName, Namespace: serviceNamespace},
+		Subsets: []v1.EndpointSubset{{
+			Addresses: []v1.EndpointAddress{{IP: "10.8.0.1", Hostname: "foo-0"}, {IP: "10.8.0.2", Hostname: "foo-1"}},
+		}},
+	})
+
+	service := necNewService()
+	service.Labels = utils.ResourceLabel
+	service.Spec.ClusterIP = v1.ClusterIPNone
+	service.Spec.Ports = nil
+	f.serviceLister = append(f.serviceLister, service)
+	f.localObjects = append(f.localObjects, service)
+
+	// Expect the hostnames of the remote pods (for DNS records of the local headless service)
+	expEndpoint := necNewEndpoint(nil)
+	expEndpoint.Subsets = []v1.EndpointSubset{{
+		Addresses: []v1.EndpointAddress{{IP: "10.8.0.1", Hostname: "foo-0"}, {IP: "10.8.0.2", Hostname: "foo-1"}},
+	}}
+	f.expectCreateEndpointAction(expEndpoint)
+
+	f.run(getKey(service, t))
+}
+
+func TestMappedNamespace(t *testing.T) {
+	utils.SetMirrorPodIPs(true)
+	defer utils.SetMirrorPodIPs(false)
+	if err := utils.NamespaceMappings.Replace([]utils.NamespaceMapping{{Remote: "(.+)-prod", Local: "$1", Regex: true}}); err != nil {
+		t.Fatal(err)
+	}
+	defer utils.NamespaceMappings.Replace(nil)
+	f := newNecFixture(t)
+
+	// Remote service and endpoints live in foo-namespace-prod
+	remoteService := necNewRemoteService(portNodePort)
+	remoteService.Namespace = serviceNamespace + "-prod"
+	remoteService.Spec.Type = v1.ServiceTypeClusterIP
+	f.remoteServiceLister = append(f.remoteServiceLister, remoteService)
+	f.remoteEndpointsLister = append(f.remoteEndpointsLister, &v1.Endpoints{
+		ObjectMeta: metaV1.ObjectMeta{Name: serviceName, Namespace: serviceNamespace + "-prod"},
+		Subsets: []v1.EndpointSubset{{
+			Addresses: []v1.EndpointAddress{{IP: "10.8.0.1"}},
+			Ports:     []v1.EndpointPort{{Name: portName, Port: 8080, Protocol: v1.ProtocolTCP}},
+		}},
+	})
+
+	service := necNewService()
+	service.Labels = utils.ResourceLabel
+	f.serviceLister = append(f.serviceLister, service)
+	f.localObjects = append(f.localObjects, service)
+
+	expEndpoint := necNewEndpoint(nil)
+	expEndpoint.Subsets = []v1.EndpointSubset{{
+		Addresses: []v1.EndpointAddress{{IP: "10.8.0.1"}},
+		Ports:     []v1.EndpointPort{{Name: portName, Port: 8080, Protocol: v1.ProtocolTCP}},
+	}}
+	f.expectCreateEndpointAction(expEndpoint)
+
+	f.run(getKey(service, t))
+}
+
+func TestMultipleClustersNotExposed(t *testing.T) {
+	f := newNecFixture(t)
+
+	node := necNewNode(randomdata.IpV4Address(), true)
+	f.nodeLister = append(f.nodeLister, node)
+
+	// Only the second cluster has the remote service
+	secondNodeIP := randomdata.IpV4Address()
+	secondNode := necNewNode(secondNodeIP, true)
+	f.secondNodeLister = append(f.secondNodeLister, secondNode)
+	f.secondRemoteServiceLister = append(f.secondRemoteServiceLister, necNewRemoteService(portNodePort))
+
+	service := necNewService()
+	service.Labels = utils.ResourceLabel
+	f.serviceLister = append(f.serviceLister, service)
+	f.localObjects = append(f.localObjects, service)
+
+	expEndpoint := necNewEndpoint([]string{secondNodeIP})
+	f.expectCreateEndpointAction(expEndpoint)
+
+	f.run(getKey(service, t))
+}
+
+func TestMultipleClustersManualService(t *testing.T) {
+	f := newNecFixture(t)
+
+	nodeIP := randomdata.IpV4Address()
+	node := necNewNode(nodeIP, true)
+	f.nodeLister = append(f.nodeLister, node)
+
+	secondNodeIP := randomdata.IpV4Address()
+	secondNode := necNewNode(secondNodeIP, true)
+	f.secondNodeLister = append(f.secondNodeLister, secondNode)
+
+	// Services not created by barrelman point to all clusters
+	service := necNewService()
+	f.serviceLister = append(f.serviceLister, service)
+	f.localObjects = append(f.localObjects, service)
+
+	expEndpoint := necNewEndpoint([]string{nodeIP})
+	expEndpoint.Subsets = append(expEndpoint.Subsets, necNewEndpoint([]string{secondNodeIP}).Subsets...)
+	f.expectCreateEndpointAction(expEndpoint)
+
+	f.run(getKey(service, t))
+}
+
+// necNewDualStackNode returns a ready node with a IPv4 and a IPv6 InternalIP
+func necNewDualStackNode(ipv4, ipv6 string) *v1.Node {
+	node := necNewNode(ipv4, true)
+	node.Status.Addresses = append(node.Status.Addresses, v1.NodeAddress{Type: v1.NodeInternalIP, Address: ipv6})
+	return node
+}
+
+func TestDualStack(t *testing.T) {
+	ipv4Subset := necNewEndpoint([]string{"10.0.0.1", "10.0.0.2"}).Subsets[0]
+	ipv6Subset := necNewEndpoint([]string{"fd00::1", "fd00::3"}).Subsets[0]
+
+	tests := []struct {
+		name        string
+		clusterIP   string
+		annotations map[string]string
+		want        []v1.EndpointSubset
+	}{
+		{"IPv4Service", "10.96.0.10", nil, []v1.EndpointSubset{ipv4Subset}},
+		{"IPv6Service", "fd00:10:96::a", nil, []v1.EndpointSubset{ipv6Subset}},
+		{"NoClusterIP", "", nil, []v1.EndpointSubset{ipv4Subset}},
+		{"DualStack", "10.96.0.10", map[string]string{utils.IPFamiliesAnnotation: "IPv4,IPv6"},
+			[]v1.EndpointSubset{ipv4Subset, ipv6Subset}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newNecFixture(t)
+
+			// IPv4 only, dual-stack and IPv6 only nodes
+			f.nodeLister = append(f.nodeLister,
+				necNewNode("10.0.0.1", true),
+				necNewDualStackNode("10.0.0.2", "fd00::1"),
+				necNewNode("fd00::3", true),
+			)
+
+			service := necNewService()
+			service.Spec.ClusterIP = tt.clusterIP
+			service.Annotations = tt.annotations
+			f.serviceLister = append(f.serviceLister, service)
+			f.localObjects = append(f.localObjects, service)
+
+			c, sI, nI := f.newController()
+			stopCh := make(chan struct{})
+			defer close(stopCh)
+			sI.Start(stopCh)
+			nI.Start(stopCh)
+			if err := c.syncHandler(getKey(service, t)); err != nil {
+				t.Fatalf("error syncing service: %v", err)
+			}
+
+			endpoint, err := f.localClient.CoreV1().Endpoints(serviceNamespace).Get(serviceName, metaV1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Order of addresses depends on the lister
+			for _, subset := range endpoint.Subsets {
+				sort.Slice(subset.Addresses, func(i, j int) bool { return subset.Addresses[i].IP < subset.Addresses[j].IP })
+			}
+			if !reflect.DeepEqual(endpoint.Subsets, tt.want) {
+				t.Errorf("Expected different subsets: (expected, got)\n%s",
+					diff.ObjectGoPrintSideBySide(tt.want, endpoint.Subsets))
+			}
+		})
+	}
+}
+
+func TestDualStackEndpointSlices(t *testing.T) {
+	f := newNecFixture(t)
+	f.cfg.EndpointMode = config.EndpointModeEndpointSlices
+	f.nodeLister = append(f.nodeLister, necNewDualStackNode("10.0.0.1", "fd00::1"))
+
+	service := necNewService()
+	service.Annotations = map[string]string{utils.IPFamiliesAnnotation: "IPv4,IPv6"}
+	f.serviceLister = append(f.serviceLister, service)
+	f.localObjects = append(f.localObjects, service)
+
+	f.run(getKey(service, t))
+
+	expected := map[string]string{
+		serviceName + "-remote-0":      "10.0.0.1",
+		serviceName + "-remote-ipv6-0": "fd00::1",
+	}
+	slices := f.listEndpointSlices()
+	if len(slices) != len(expected) {
+		t.Errorf("Expected %d EndpointSlices, got %d", len(expected), len(slices))
+	}
+	for name, ip := range expected {
+		slice, ok := slices[name]
+		if !ok {
+			t.Errorf("EndpointSlice %s is missing", name)
+			continue
+		}
+		family, _ := utils.IPFamilyOf(ip)
+		if slice.AddressType != string(family) || len(slice.Endpoints) != 1 || slice.Endpoints[0].Addresses[0] != ip {
+			t.Errorf("EndpointSlice %s has wrong address (type): %s %v", name, slice.AddressType, slice.Endpoints)
+		}
+	}
+}
+
+// listEndpointSlices returns all EndpointSlices of the fake dynamic client by name
+func (f *necFixture) listEndpointSlices() map[string]*utils.EndpointSlice {
+	list, err := f.localDynamicClient.Resource(utils.EndpointSliceResource).Namespace(serviceNamespace).List(metaV1.ListOptions{})
+	if err != nil {
+		f.t.Fatalf("Failed to list EndpointSlices: %v", err)
+	}
+	slices := make(map[string]*utils.EndpointSlice)
+	for i := range list.Items {
+		slice, err := utils.EndpointSliceFromUnstructured(&list.Items[i])
+		if err != nil {
+			f.t.Fatalf("Failed to convert EndpointSlice: %v", err)
+		}
+		slices[slice.GetName()] = slice
+	}
+	return slices
+}
+
+func necSliceEndpoint(ip string, ready bool) utils.EndpointSliceEndpoint {
+	return utils.EndpointSliceEndpoint{
+		Addresses:  []string{ip},
+		Conditions: utils.EndpointSliceConditions{Ready: &ready},
+	}
+}
+
+func TestCreatesEndpointSlices(t *testing.T) {
+	f := newNecFixture(t)
+	f.cfg.EndpointMode = config.EndpointModeEndpointSlices
+	f.cfg.EndpointSliceMaxSize = 2
+
+	for _, ip := range []string{"10.0.0.3", "10.0.0.1", "10.0.0.2"} {
+		f.nodeLister = append(f.nodeLister, necNewNode(ip, true))
+	}
+	f.nodeLister = append(f.nodeLister, necNewNode("10.0.0.4", false))
+
+	service := necNewService()
+	f.serviceLister = append(f.serviceLister, service)
+	f.localObjects = append(f.localObjects, service)
+
+	// A stale slice that has to be removed
+	stale, err := (&utils.EndpointSlice{
+		TypeMeta: metaV1.TypeMeta{APIVersion: "discovery.k8s.io/v1", Kind: "EndpointSlice"},
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      serviceName + "-remote-5",
+			Namespace: serviceNamespace,
+			Labels:    map[string]string{utils.LabelServiceName: serviceName, utils.LabelManagedBy: utils.LabelValueManagedBy},
+		},
+	}).ToUnstructured()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.localDynamicObjects = append(f.localDynamicObjects, stale)
+
+	// No Endpoints should be created
+	f.run(getKey(service, t))
+
+	slices := f.listEndpointSlices()
+	if len(slices) != 2 {
+		t.Fatalf("Expected 2 EndpointSlices, got %d: %v", len(slices), slices)
+	}
+	expected := map[string][]utils.EndpointSliceEndpoint{
+		serviceName + "-remote-0": {necSliceEndpoint("10.0.0.1", true), necSliceEndpoint("10.0.0.2", true)},
+		serviceName + "-remote-1": {necSliceEndpoint("10.0.0.3", true), necSliceEndpoint("10.0.0.4", false)},
+	}
+	for name, endpoints := range expected {
+		slice, ok := slices[name]
+		if !ok {
+			t.Errorf("Expected EndpointSlice %s", name)
+			continue
+		}
+		if !reflect.DeepEqual(slice.Endpoints, endpoints) {
+			t.Errorf("EndpointSlice %s has wrong endpoints\nDiff: (expected, got)\n %s",
+				name, diff.ObjectGoPrintSideBySide(endpoints, slice.Endpoints))
+		}
+		if slice.Labels[utils.LabelServiceName] != serviceName {
+			t.Errorf("EndpointSlice %s has wrong labels: %v", name, slice.Labels)
+		}
+		if len(slice.Ports) != 1 || *slice.Ports[0].Port != portNodePort || *slice.Ports[0].Name != portName {
+			t.Errorf("EndpointSlice %s has wrong ports: %s", name, spew.Sdump(slice.Ports))
+		}
+	}
+}
+
+func TestEndpointsAndEndpointSlices(t *testing.T) {
+	f := newNecFixture(t)
+	f.cfg.EndpointMode = config.EndpointModeBoth
+
+	nodeIP := randomdata.IpV4Address()
+	f.nodeLister = append(f.nodeLister, necNewNode(nodeIP, true))
+
+	service := necNewService()
+	f.serviceLister = append(f.serviceLister, service)
+	f.localObjects = append(f.localObjects, service)
+
+	// Endpoints must not be mirrored by kubernetes
+	expEndpoint := necNewEndpoint([]string{nodeIP})
+	expEndpoint.Labels = map[string]string{
+		utils.LabelAnnotationKey: utils.LabelValueTrue,
+		utils.LabelSkipMirror:    "true",
+	}
+	f.expectCreateEndpointAction(expEndpoint)
+
+	f.run(getKey(service, t))
+
+	if slices := f.listEndpointSlices(); len(slices) != 1 {
+		t.Errorf("Expected 1 EndpointSlice, got %d", len(slices))
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	f := newNecFixture(t)
+	f.plan = NewPlan()
+	f.cfg.EndpointMode = config.EndpointModeBoth
+
+	nodeIP := randomdata.IpV4Address()
+	f.nodeLister = append(f.nodeLister, necNewNode(nodeIP, true))
+
+	service := necNewService()
+	f.serviceLister = append(f.serviceLister, service)
+	f.localObjects = append(f.localObjects, service)
+
+	stale, err := (&utils.EndpointSlice{
+		TypeMeta: metaV1.TypeMeta{APIVersion: "discovery.k8s.io/v1", Kind: "EndpointSlice"},
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      serviceName + "-remote-5",
+			Namespace: serviceNamespace,
+			Labels:    map[string]string{utils.LabelServiceName: serviceName, utils.LabelManagedBy: utils.LabelValueManagedBy},
+		},
+	}).ToUnstructured()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.localDynamicObjects = append(f.localDynamicObjects, stale)
+
+	// No Endpoints should be created
+	f.run(getKey(service, t))
+
+	// EndpointSlices must not be touched
+	slices := f.listEndpointSlices()
+	if _, ok := slices[stale.GetName()]; len(slices) != 1 || !ok {
+		t.Errorf("Expected EndpointSlices to be unchanged, got: %v", slices)
+	}
+
+	expected := map[string]ActionType{
+		"Endpoints/" + getKey(service, t):                                     ActionTypeAdd,
+		"EndpointSlice/" + serviceNamespace + "/" + serviceName + "-remote-0": ActionTypeAdd,
+		"EndpointSlice/" + serviceNamespace + "/" + stale.GetName():           ActionTypeDelete,
+	}
+	planned := make(map[string]ActionType)
+	for _, a := range f.plan.List() {
+		planned[a.Kind+"/"+a.Name] = a.Action
+	}
+	if !reflect.DeepEqual(expected, planned) {
+		t.Errorf("Expected different planned actions: (expected, got)\n%s",
+			diff.ObjectGoPrintSideBySide(expected, planned))
+	}
+}
@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/diff"
+	"k8s.io/klog"
+)
+
+// PlannedAction is a action a controller would have taken if it was not running in dry-run mode
+type PlannedAction struct {
+	// Controller is the name of the controller planning the action
+	Controller string `json:"controller"`
+	// Service is the key (namespace/name) of the (local) service the action was planned for
+	Service string `json:"service"`
+	// Action is the type of action to take on the object
+	Action ActionType `json:"action"`
+	// Kind and Name (namespace/name) of the object to act on
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// Current is the object as it is (nil for Add), Desired as it should be (nil for Delete)
+	Current interface{} `json:"current,omitempty"`
+	Desired interface{} `json:"desired,omitempty"`
+	// Diff is a human readable diff between Current and Desired
+	Diff string `json:"diff,omitempty"`
+	// Planned is the time the action has been (re-)planned
+	Planned time.Time `json:"planned"`
+}
+
+// Plan collects the actions controllers would take in dry-run mode. For every service, only the actions of
+// the last sync are kept, so the Plan reflects what would happen if dry-run mode was disabled now.
+type Plan struct {
+	lock    sync.RWMutex
+	actions map[string][]PlannedAction
+}
+
+func NewPlan() *Plan {
+	return &Plan{
+		actions: make(map[string][]PlannedAction),
+	}
+}
+
+// newPlannedAction creates a PlannedAction, calculating the diff between current and desired.
+// If one of them is nil, the diff is the other object (prefixed with + or -).
+func newPlannedAction(action ActionType, kind, name string, current, desired interface{}) PlannedAction {
+	var d string
+	switch {
+	case current == nil:
+		d = "+" + toJSON(desired)
+	case desired == nil:
+		d = "-" + toJSON(current)
+	default:
+		d = diff.ObjectReflectDiff(current, desired)
+	}
+	return PlannedAction{
+		Action:  action,
+		Kind:    kind,
+		Name:    name,
+		Current: current,
+		Desired: desired,
+		Diff:    d,
+	}
+}
+
+func toJSON(obj interface{}) string {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err.Error()
+	}
+	return string(data)
+}
+
+// Set replaces all planned actions of controller for service (key) and logs them (if they changed).
+// If actions is empty, all actions for the service are removed.
+func (p *Plan) Set(controller, key string, actions []PlannedAction) {
+	mapKey := controller + "/" + key
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if len(actions) == 0 {
+		if _, exists := p.actions[mapKey]; exists {
+			klog.Infof("dry-run controller=%s service=%s: no actions pending", controller, key)
+			delete(p.actions, mapKey)
+		}
+		return
+	}
+
+	previous := p.actions[mapKey]
+	now := time.Now()
+	for i := range actions {
+		actions[i].Controller = controller
+		actions[i].Service = key
+		actions[i].Planned = now
+		if i < len(previous) && previous[i].Action == actions[i].Action &&
+			previous[i].Name == actions[i].Name && previous[i].Diff == actions[i].Diff {
+			// Same action as planned before, don't spam the log on every sync
+			continue
+		}
+		klog.Infof("dry-run controller=%s service=%s action=%s kind=%s name=%s diff:\n%s",
+			controller, key, actions[i].Action, actions[i].Kind, actions[i].Name, actions[i].Diff)
+	}
+	p.actions[mapKey] = actions
+}
+
+// List returns all pending actions ordered by controller and service
+func (p *Plan) List() []PlannedAction {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	keys := make([]string, 0, len(p.actions))
+	for k := range p.actions {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	list := make([]PlannedAction, 0, len(keys))
+	for _, k := range keys {
+		list = append(list, p.actions[k]...)
+	}
+	return list
+}
+
+// ServeHTTP returns all pending actions as JSON
+func (p *Plan) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(p.List()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,40 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPlan(t *testing.T) {
+	p := NewPlan()
+	svc := &v1.Service{ObjectMeta: metaV1.ObjectMeta{Name: serviceName, Namespace: serviceNamespace}}
+
+	p.Set("b", "ns/svc", []PlannedAction{newPlannedAction(ActionTypeAdd, "Service", "ns/svc", nil, svc)})
+	p.Set("a", "ns/svc", []PlannedAction{newPlannedAction(ActionTypeDelete, "Service", "ns/svc", svc, nil)})
+
+	list := p.List()
+	if len(list) != 2 || list[0].Controller != "a" || list[1].Controller != "b" || list[1].Service != "ns/svc" {
+		t.Fatalf("Expected two actions ordered by controller, got %v", list)
+	}
+
+	// Actions are served as JSON
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/plan", nil))
+	var served []PlannedAction
+	if err := json.Unmarshal(rec.Body.Bytes(), &served); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(served) != 2 || served[0].Action != ActionTypeDelete || served[1].Action != ActionTypeAdd {
+		t.Errorf("Expected two served actions, got %v", served)
+	}
+
+	// Setting no actions removes the service
+	p.Set("a", "ns/svc", nil)
+	if list := p.List(); len(list) != 1 || list[0].Controller != "b" {
+		t.Errorf("Expected one action of controller b, got %v", list)
+	}
+}
@@ -0,0 +1,212 @@
+package controller
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"barrelman/config"
+	"barrelman/metrics"
+	"barrelman/utils"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+)
+
+// maxConcurrentProbes limits the number of probes running at the same time
+const maxConcurrentProbes = 100
+
+// Prober periodically probes remote node ports. NodeEndpointController registers the targets of every
+// service (see SetTargets) and moves addresses that are not Healthy to NotReadyAddresses.
+type Prober struct {
+	interval         time.Duration
+	timeout          time.Duration
+	successThreshold int
+	failureThreshold int
+
+	// onChange is called with the keys of all services whose targets changed their health
+	onChange func(key string)
+
+	// probe probes a single target (probeTarget, may be replaced in tests)
+	probe func(target utils.ProbeTarget, timeout time.Duration) error
+
+	lock sync.Mutex
+	// targets holds the state of every target, services the targets of every service (key)
+	targets  map[utils.ProbeTarget]*probeState
+	services map[string][]utils.ProbeTarget
+}
+
+// probeState is the health of a single target
+type probeState struct {
+	healthy bool
+	// consecutive successes (while not healthy) or failures (while healthy)
+	count int
+	// services (keys) probing this target
+	services sets.String
+}
+
+func NewProber(cfg config.ProbeConfig) *Prober {
+	return &Prober{
+		interval:         cfg.Interval.Duration,
+		timeout:          cfg.Timeout.Duration,
+		successThreshold: cfg.SuccessThreshold,
+		failureThreshold: cfg.FailureThreshold,
+		onChange:         func(string) {},
+		probe:            probeTarget,
+		targets:          make(map[utils.ProbeTarget]*probeState),
+		services:         make(map[string][]utils.ProbeTarget),
+	}
+}
+
+// SetTargets replaces the targets probed for service (key). Targets no service uses anymore are dropped,
+// new targets are considered healthy until they failed.
+func (p *Prober) SetTargets(key string, targets []utils.ProbeTarget) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, target := range p.services[key] {
+		state := p.targets[target]
+		state.services.Delete(key)
+		if state.services.Len() == 0 {
+			delete(p.targets, target)
+		}
+	}
+	if len(targets) == 0 {
+		delete(p.services, key)
+		return
+	}
+
+	p.services[key] = targets
+	for _, target := range targets {
+		state, ok := p.targets[target]
+		if !ok {
+			state = &probeState{healthy: true, services: sets.NewString()}
+			p.targets[target] = state
+		}
+		state.services.Insert(key)
+	}
+}
+
+// Healthy returns false if target failed its last probes. Unknown targets are healthy.
+func (p *Prober) Healthy(target utils.ProbeTarget) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	state, ok := p.targets[target]
+	return !ok || state.healthy
+}
+
+// Run probes all targets every interval until stopCh is closed
+func (p *Prober) Run(stopCh <-chan struct{}) {
+	klog.Infof("Starting Prober (interval %s, timeout %s)", p.interval, p.timeout)
+	wait.Until(p.probeAll, p.interval, stopCh)
+}
+
+// probeAll probes all targets (concurrently) and calls onChange for every service with targets
+// that changed their health
+func (p *Prober) probeAll() {
+	p.lock.Lock()
+	targets := make([]utils.ProbeTarget, 0, len(p.targets))
+	for target := range p.targets {
+		targets = append(targets, target)
+	}
+	p.lock.Unlock()
+
+	results := make([]error, len(targets))
+	sem := make(chan struct{}, maxConcurrentProbes)
+	var wg sync.WaitGroup
+	for i := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() { <-sem; wg.Done() }()
+			start := time.Now()
+			results[i] = p.probe(targets[i], p.timeout)
+			metrics.ProbeDuration.WithLabelValues(targets[i].Type).Observe(time.Since(start).Seconds())
+			result := "success"
+			if results[i] != nil {
+				result = "failure"
+			}
+			metrics.Probes.WithLabelValues(targets[i].Type, result).Inc()
+		}(i)
+	}
+	wg.Wait()
+
+	changed := sets.NewString()
+	p.lock.Lock()
+	for i, target := range targets {
+		// Target may have been removed while probing
+		state, ok := p.targets[target]
+		if !ok || !p.record(state, results[i]) {
+			continue
+		}
+		if state.healthy {
+			klog.Infof("Probe %s succeeded %d times, marking ready", target, p.successThreshold)
+		} else {
+			klog.Warningf("Probe %s failed %d times, marking not ready: %v", target, p.failureThreshold, results[i])
+		}
+		changed = changed.Union(state.services)
+	}
+	unhealthy := 0
+	for _, state := range p.targets {
+		if !state.healthy {
+			unhealthy++
+		}
+	}
+	metrics.ProbeTargets.WithLabelValues("healthy").Set(float64(len(p.targets) - unhealthy))
+	metrics.ProbeTargets.WithLabelValues("unhealthy").Set(float64(unhealthy))
+	p.lock.Unlock()
+
+	for _, key := range changed.List() {
+		p.onChange(key)
+	}
+}
+
+// record updates state with the result of a probe and returns true if the health changed
+func (p *Prober) record(state *probeState, err error) bool {
+	if state.healthy == (err == nil) {
+		state.count = 0
+		return false
+	}
+	state.count++
+	threshold := p.failureThreshold
+	if !state.healthy {
+		threshold = p.successThreshold
+	}
+	if state.count < threshold {
+		return false
+	}
+	state.healthy = !state.healthy
+	state.count = 0
+	return true
+}
+
+// probeTarget probes a single target by opening a TCP connection or sending a HTTP GET request
+func probeTarget(target utils.ProbeTarget, timeout time.Duration) error {
+	address := net.JoinHostPort(target.IP, strconv.Itoa(int(target.Port)))
+	if target.Type != utils.ProbeTypeHTTP {
+		conn, err := net.DialTimeout("tcp", address, timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	client := &http.Client{
+		Timeout: timeout,
+		// Redirects are a success (like with kubelet probes), don't follow them to somewhere else
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := client.Get(target.String())
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("HTTP probe failed with status %d", resp.StatusCode)
+	}
+	return nil
+}
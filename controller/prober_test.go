@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"barrelman/config"
+	"barrelman/utils"
+)
+
+func TestProber_probeAll(t *testing.T) {
+	cfg := config.Default().Probe
+	cfg.FailureThreshold = 2
+	cfg.SuccessThreshold = 3
+	p := NewProber(cfg)
+
+	target := utils.ProbeTarget{ProbeSpec: utils.ProbeSpec{Type: utils.ProbeTypeTCP}, IP: "10.0.0.1", Port: 30000}
+	other := utils.ProbeTarget{ProbeSpec: utils.ProbeSpec{Type: utils.ProbeTypeTCP}, IP: "10.0.0.2", Port: 30000}
+	p.SetTargets("ns/a", []utils.ProbeTarget{target, other})
+	p.SetTargets("ns/b", []utils.ProbeTarget{target})
+
+	var failing bool
+	p.probe = func(tgt utils.ProbeTarget, timeout time.Duration) error {
+		if failing && tgt == target {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	var changed []string
+	p.onChange = func(key string) { changed = append(changed, key) }
+
+	steps := []struct {
+		name        string
+		failing     bool
+		wantHealthy bool
+		wantChanged []string
+	}{
+		{"Healthy", false, true, nil},
+		{"FirstFailure", true, true, nil},
+		{"SecondFailure", true, false, []string{"ns/a", "ns/b"}},
+		{"FirstSuccess", false, false, nil},
+		{"SecondSuccess", false, false, nil},
+		{"FailureResetsSuccesses", true, false, nil},
+		{"Success1", false, false, nil},
+		{"Success2", false, false, nil},
+		{"Success3", false, true, []string{"ns/a", "ns/b"}},
+	}
+	for _, step := range steps {
+		failing = step.failing
+		changed = nil
+		p.probeAll()
+		if got := p.Healthy(target); got != step.wantHealthy {
+			t.Errorf("%s: Healthy() = %v, want %v", step.name, got, step.wantHealthy)
+		}
+		if !reflect.DeepEqual(changed, step.wantChanged) {
+			t.Errorf("%s: changed services = %v, want %v", step.name, changed, step.wantChanged)
+		}
+		if !p.Healthy(other) {
+			t.Errorf("%s: other target should be healthy", step.name)
+		}
+	}
+
+	// Targets are dropped with the last service using them
+	p.SetTargets("ns/a", nil)
+	p.SetTargets("ns/b", nil)
+	if len(p.targets) != 0 || len(p.services) != 0 {
+		t.Errorf("Expected no targets, got %v and %v", p.targets, p.services)
+	}
+}
+
+func TestProbeTarget(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	host, portString, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := strconv.Atoi(portString)
+
+	// A port nothing listens on
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	tests := []struct {
+		name    string
+		spec    utils.ProbeSpec
+		port    int
+		wantErr bool
+	}{
+		{"TCP", utils.ProbeSpec{Type: utils.ProbeTypeTCP}, port, false},
+		{"TCPClosed", utils.ProbeSpec{Type: utils.ProbeTypeTCP}, closedPort, true},
+		{"HTTP", utils.ProbeSpec{Type: utils.ProbeTypeHTTP, Path: "/"}, port, false},
+		{"HTTPStatus", utils.ProbeSpec{Type: utils.ProbeTypeHTTP, Path: "/fail"}, port, true},
+		{"HTTPClosed", utils.ProbeSpec{Type: utils.ProbeTypeHTTP, Path: "/"}, closedPort, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := utils.ProbeTarget{ProbeSpec: tt.spec, IP: host, Port: int32(tt.port)}
+			if err := probeTarget(target, time.Second); (err != nil) != tt.wantErr {
+				t.Errorf("probeTarget() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"barrelman/utils"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	kubeinformers "k8s.io/client-go/informers"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+// RemoteCluster bundles the k8s Clientset and informers of one remote cluster
+type RemoteCluster struct {
+	// Name identifies the remote cluster in logs and metrics
+	Name string
+
+	// Client is the k8s Clientset for the remote cluster
+	Client kubernetes.Interface
+
+	// InformerFactory holds all informers for the remote cluster. It has to be started
+	// after all controllers have been created.
+	InformerFactory kubeinformers.SharedInformerFactory
+
+	// WatchEndpoints enables the endpoints informer (used for services mirrored in pod IP mode,
+	// see utils.IsPodIPMirrored). It has to be set before the controllers are created.
+	WatchEndpoints bool
+
+	// NamespaceSelector restricts the mirrored services to namespaces whose labels match (nil selects all). The
+	// namespace informer is only used if it's set, it has to be set before the controllers are created.
+	NamespaceSelector labels.Selector
+
+	// stopCh stops the informers of this cluster only (e.g. when it's removed from config)
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+func NewRemoteCluster(name string, client kubernetes.Interface, resyncPeriod time.Duration) *RemoteCluster {
+	return &RemoteCluster{
+		Name:            name,
+		Client:          client,
+		InformerFactory: kubeinformers.NewSharedInformerFactory(client, resyncPeriod),
+		stopCh:          make(chan struct{}),
+	}
+}
+
+// Start starts all informers of the remote cluster. They run until Stop is called or stopCh is closed.
+func (r *RemoteCluster) Start(stopCh <-chan struct{}) {
+	go func() {
+		select {
+		case <-stopCh:
+			r.Stop()
+		case <-r.stopCh:
+		}
+	}()
+	r.InformerFactory.Start(r.stopCh)
+}
+
+// StartAndSync starts the service and node (and endpoints and namespaces, see WatchEndpoints and NamespaceSelector) informers of a cluster added while the controllers are
+// already running and waits (up to timeout) for their caches to sync. Informers are stopped on error.
+func (r *RemoteCluster) StartAndSync(stopCh <-chan struct{}, timeout time.Duration) error {
+	// Informers have to be requested before the factory is started
+	synced := []cache.InformerSynced{r.Services().Informer().HasSynced, r.Nodes().Informer().HasSynced}
+	if r.WatchEndpoints {
+		synced = append(synced, r.Endpoints().Informer().HasSynced)
+	}
+	if r.NamespaceSelector != nil {
+		synced = append(synced, r.Namespaces().Informer().HasSynced)
+	}
+	r.Start(stopCh)
+
+	timeoutCh := make(chan struct{})
+	timer := time.AfterFunc(timeout, func() { close(timeoutCh) })
+	defer timer.Stop()
+	if !cache.WaitForCacheSync(timeoutCh, synced...) {
+		r.Stop()
+		return fmt.Errorf("failed to sync informers of remote cluster %s within %s", r.Name, timeout)
+	}
+	return nil
+}
+
+// Stop stops all informers of the remote cluster
+func (r *RemoteCluster) Stop() {
+	r.stopOnce.Do(func() { close(r.stopCh) })
+}
+
+// Services returns the service informer of the remote cluster
+func (r *RemoteCluster) Services() coreinformers.ServiceInformer {
+	return r.InformerFactory.Core().V1().Services()
+}
+
+// Nodes returns the node informer of the remote cluster
+func (r *RemoteCluster) Nodes() coreinformers.NodeInformer {
+	return r.InformerFactory.Core().V1().Nodes()
+}
+
+// Endpoints returns the endpoints informer of the remote cluster (only started if WatchEndpoints is set)
+func (r *RemoteCluster) Endpoints() coreinformers.EndpointsInformer {
+	return r.InformerFactory.Core().V1().Endpoints()
+}
+
+// Namespaces returns the namespace informer of the remote cluster (only started if NamespaceSelector is set)
+func (r *RemoteCluster) Namespaces() coreinformers.NamespaceInformer {
+	return r.InformerFactory.Core().V1().Namespaces()
+}
+
+// NamespaceSelected checks if the labels of namespace match NamespaceSelector. Namespaces that don't exist
+// (or are not in the informer cache yet) are not selected.
+func (r *RemoteCluster) NamespaceSelected(namespace string) bool {
+	if r.NamespaceSelector == nil {
+		return true
+	}
+	ns, err := r.Namespaces().Lister().Get(namespace)
+	if err != nil {
+		return false
+	}
+	return r.NamespaceSelector.Matches(labels.Set(ns.Labels))
+}
+
+// Responsible checks if barrelman is responsible for a service of this cluster, see
+// utils.ResponsibleForRemoteService and NamespaceSelected
+func (r *RemoteCluster) Responsible(service *v1.Service) bool {
+	return utils.ResponsibleForRemoteService(service) && r.NamespaceSelected(service.GetNamespace())
+}
+
+// addNamespaceHandler calls enqueue for every service of a namespace whose labels changed from or to
+// matching NamespaceSelector. Nothing is done if NamespaceSelector is not set.
+func (r *RemoteCluster) addNamespaceHandler(enqueue func(service *v1.Service)) {
+	if r.NamespaceSelector == nil {
+		return
+	}
+	r.Namespaces().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, cur interface{}) {
+			oldNs, curNs := old.(*v1.Namespace), cur.(*v1.Namespace)
+			if r.NamespaceSelector.Matches(labels.Set(oldNs.Labels)) == r.NamespaceSelector.Matches(labels.Set(curNs.Labels)) {
+				return
+			}
+			klog.V(3).Infof("UPDATE labels of remote namespace %s (cluster %s)", curNs.Name, r.Name)
+			services, err := r.Services().Lister().Services(curNs.Name).List(labels.Everything())
+			if err != nil {
+				runtime.HandleError(err)
+				return
+			}
+			for _, service := range services {
+				enqueue(service)
+			}
+		},
+	})
+}
+
+// MirroredServices returns the services of the remote cluster mirrored to the local service namespace/name,
+// sorted by namespace. The remote key is mapped via utils.LocalServiceKey, so more than one service
+// is returned if multiple remote namespaces are mapped to namespace.
+// local is the local service (nil if it doesn't exist). If it records the remote service it mirrors (see
+// utils.SourceAnnotations), only that one is looked up instead of mapping all remote services. Services of
+// other remote namespaces mapped to it as well are not returned then.
+func (r *RemoteCluster) MirroredServices(namespace, name string, local *v1.Service) ([]*v1.Service, error) {
+	key := namespace + "/" + name
+	sourceNamespace, sourceName := namespace, name
+	if local != nil && local.Annotations[utils.AnnotationSourceNamespace] != "" &&
+		local.Annotations[utils.AnnotationSourceName] != "" {
+		sourceNamespace = local.Annotations[utils.AnnotationSourceNamespace]
+		sourceName = local.Annotations[utils.AnnotationSourceName]
+	} else if utils.NamespaceMappings.Enabled() || utils.ServiceNames.Enabled() {
+		return r.mappedServices(key)
+	}
+
+	service, err := r.Services().Lister().Services(sourceNamespace).Get(sourceName)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	// The mappings may have changed since the source has been recorded
+	if localKey, err := utils.LocalServiceKey(sourceNamespace, sourceName, r.Name); err != nil || localKey != key {
+		return nil, nil
+	}
+	return []*v1.Service{service}, nil
+}
+
+// mappedServices returns the services of the remote cluster mapped to the local service key, see MirroredServices
+func (r *RemoteCluster) mappedServices(key string) ([]*v1.Service, error) {
+	// Mappings (like regular expressions or name templates) can't be reversed, so check all services
+	services, err := r.Services().Lister().List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	var mirrored []*v1.Service
+	for _, service := range services {
+		// Services without a valid local name are never mirrored (errors are logged when they are enqueued)
+		if localKey, err := utils.LocalServiceKey(service.GetNamespace(), service.GetName(), r.Name); err == nil && localKey == key {
+			mirrored = append(mirrored, service)
+		}
+	}
+	sort.Slice(mirrored, func(i, j int) bool { return mirrored[i].GetNamespace() < mirrored[j].GetNamespace() })
+	return mirrored, nil
+}
+
+// remoteServicesSynced returns the InformerSynced funcs for the service (and namespace, see NamespaceSelector)
+// informers of all given clusters
+func remoteServicesSynced(remotes []*RemoteCluster) []cache.InformerSynced {
+	synced := make([]cache.InformerSynced, 0, len(remotes))
+	for _, r := range remotes {
+		synced = append(synced, r.Services().Informer().HasSynced)
+		if r.NamespaceSelector != nil {
+			synced = append(synced, r.Namespaces().Informer().HasSynced)
+		}
+	}
+	return synced
+}
@@ -1,9 +1,13 @@
 package controller
 
 import (
+	"barrelman/config"
 	"barrelman/metrics"
 	"barrelman/utils"
 	"fmt"
+	"reflect"
+	"strings"
+	"sync"
 	"time"
 
 	"k8s.io/apimachinery/pkg/util/intstr"
@@ -14,11 +18,14 @@ import (
 	"k8s.io/apimachinery/pkg/util/wait"
 
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
 	coreinformers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
 	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog"
 )
@@ -34,53 +41,88 @@ const (
 
 type ServiceController struct {
 	// localClient is the k8s Clientset for the local cluster (where we create/delete services)
-	// remoteClient is the k8s Clientset fot the remote cluster (which we watch for service changes)
-	localClient, remoteClient kubernetes.Interface
+	localClient kubernetes.Interface
+
+	// remotes are the remote clusters we watch for service changes.
+	// If a service exists in more than one of them, the first one (in order) wins.
+	remotes     []*RemoteCluster
+	remotesLock sync.RWMutex
 
 	// Informer and Indexer for services and their sync state
-	remoteServiceLister corelisters.ServiceLister
-	remoteSynced        cache.InformerSynced
-	localServiceLister  corelisters.ServiceLister
-	localSynced         cache.InformerSynced
+	remoteSynced       []cache.InformerSynced
+	localServiceLister corelisters.ServiceLister
+	localSynced        cache.InformerSynced
 
 	// queue will queue all services that need to be need to create dummy's for (in local)
 	queue workqueue.RateLimitingInterface
 
-	// Type of the local services to create, defaults to ClusterIP
-	localServiceType v1.ServiceType
+	// cfg holds the current configuration (type of the local services to create), see SetConfig
+	cfg     *config.Config
+	cfgLock sync.RWMutex
+
+	// plan collects the actions to take instead of performing them, if not nil (dry-run mode)
+	plan *Plan
+
+	// recorder records events on local services
+	recorder record.EventRecorder
 }
 
 func NewServiceController(
-	localClient, remoteClient kubernetes.Interface,
-	remoteInformer coreinformers.ServiceInformer, localInformer coreinformers.ServiceInformer,
-	createNodePortSvc bool) *ServiceController {
+	localClient kubernetes.Interface, remotes []*RemoteCluster,
+	localInformer coreinformers.ServiceInformer,
+	cfg *config.Config) *ServiceController {
 
 	c := &ServiceController{
-		localClient:  localClient,
-		remoteClient: remoteClient,
-		queue:        workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Services"),
+		localClient: localClient,
+		remotes:     remotes,
+		queue:       workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Services"),
+		cfg:         cfg,
+		recorder:    newEventRecorder(localClient, "barrelman-service-controller"),
 	}
 
-	c.remoteServiceLister = remoteInformer.Lister()
-	c.remoteSynced = remoteInformer.Informer().HasSynced
-
-	// localServiceType defaults to ClusterIP
-	c.localServiceType = v1.ServiceTypeClusterIP
-	if createNodePortSvc {
-		c.localServiceType = v1.ServiceTypeNodePort
-	}
+	c.remoteSynced = remoteServicesSynced(remotes)
 
 	// Enqueue services
 	// Check for labels, annotations and service type via utils.ResponsibleFor to reduce noise in queue
-	remoteInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+	for _, remote := range remotes {
+		c.addRemoteClusterHandlers(remote)
+	}
+
+	c.localServiceLister = localInformer.Lister()
+	c.localSynced = localInformer.Informer().HasSynced
+
+	// Enqueue services that have been deleted in local
+	// This is the case when we've already deployed to VPC cluster and deleting the helm release in legacy cluster
+	// afterwards. In that case, we want barrelman to create a dummy service in local-cluster immediately.
+	localInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(obj interface{}) {
+			service := obj.(*v1.Service)
+			klog.V(3).Infof("DELETE local Service %s/%s", service.GetNamespace(), service.GetName())
+			c.enqueueService(obj)
+		},
+	})
+
+	return c
+}
+
+// addRemoteClusterHandlers registers the event handlers for services (and namespaces) of a remote cluster
+func (c *ServiceController) addRemoteClusterHandlers(remote *RemoteCluster) {
+	remote.Services().Informer().AddEventHandler(c.remoteServiceHandler(remote))
+	// Services of namespaces that are (no longer) selected have to be created (or removed)
+	remote.addNamespaceHandler(func(service *v1.Service) { c.enqueueRemoteService(remote, service) })
+}
+
+// remoteServiceHandler returns the event handler for service events in the given remote cluster
+func (c *ServiceController) remoteServiceHandler(remote *RemoteCluster) cache.ResourceEventHandlerFuncs {
+	return cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
 			service := obj.(*v1.Service)
-			if !utils.ResponsibleForRemoteService(service) {
+			if !remote.Responsible(service) {
 				return
 			}
 
-			klog.V(3).Infof("ADD remote service %s/%s", service.GetNamespace(), service.GetName())
-			c.enqueueService(obj)
+			klog.V(3).Infof("ADD remote service %s/%s (cluster %s)", service.GetNamespace(), service.GetName(), remote.Name)
+			c.enqueueRemoteService(remote, service)
 		},
 		UpdateFunc: func(old, cur interface{}) {
 			newService := cur.(*v1.Service)
@@ -89,37 +131,163 @@ func NewServiceController(
 				// This is the same object, e.g. resync
 				return
 			}
-			if !utils.ResponsibleForRemoteService(newService) && !utils.ResponsibleForRemoteService(oldService) {
+			if !remote.Responsible(newService) && !remote.Responsible(oldService) {
 				return
 			}
-			klog.V(3).Infof("UPDATE remote service %s/%s", newService.GetNamespace(), newService.GetName())
-			c.enqueueService(cur)
+			klog.V(3).Infof("UPDATE remote service %s/%s (cluster %s)", newService.GetNamespace(), newService.GetName(), remote.Name)
+			c.enqueueRemoteService(remote, newService)
 		},
 		DeleteFunc: func(obj interface{}) {
 			service := obj.(*v1.Service)
-			if !utils.ResponsibleForRemoteService(service) {
+			if !remote.Responsible(service) {
 				return
 			}
-			klog.V(3).Infof("DELETE remote Service %s/%s", service.GetNamespace(), service.GetName())
-			c.enqueueService(obj)
+			klog.V(3).Infof("DELETE remote Service %s/%s (cluster %s)", service.GetNamespace(), service.GetName(), remote.Name)
+			c.enqueueRemoteService(remote, service)
 		},
-	})
+	}
+}
 
-	c.localServiceLister = localInformer.Lister()
-	c.localSynced = localInformer.Informer().HasSynced
+// SetDryRun enables dry-run mode: Instead of modifying the local cluster, actions are logged and
+// collected in plan. Has to be called before Run.
+func (c *ServiceController) SetDryRun(plan *Plan) {
+	c.plan = plan
+}
 
-	// Enqueue services that have been deleted in local
-	// This is the case when we've already deployed to VPC cluster and deleting the helm release in legacy cluster
-	// afterwards. In that case, we want barrelman to create a dummy service in local-cluster immediately.
-	localInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-		DeleteFunc: func(obj interface{}) {
-			service := obj.(*v1.Service)
-			klog.V(3).Infof("DELETE local Service %s/%s", service.GetNamespace(), service.GetName())
-			c.enqueueService(obj)
-		},
-	})
+// AddRemoteCluster adds a remote cluster while the controller is running.
+// The informers of remote have to be started and synced already (see RemoteCluster.Start).
+func (c *ServiceController) AddRemoteCluster(remote *RemoteCluster) {
+	c.remotesLock.Lock()
+	c.remotes = append(c.remotes, remote)
+	c.remotesLock.Unlock()
 
-	return c
+	// Informers replay all existing objects to new handlers, so all services will be enqueued
+	c.addRemoteClusterHandlers(remote)
+}
+
+// RemoveRemoteCluster removes the remote cluster with the given name while the controller is running.
+// All services of the cluster are enqueued so their local services are removed (or taken from
+// another cluster). This has to be called before the informers of the cluster are stopped.
+func (c *ServiceController) RemoveRemoteCluster(name string) {
+	var removed *RemoteCluster
+	c.remotesLock.Lock()
+	for i, remote := range c.remotes {
+		if remote.Name == name {
+			removed = remote
+			c.remotes = append(c.remotes[:i:i], c.remotes[i+1:]...)
+			break
+		}
+	}
+	c.remotesLock.Unlock()
+	if removed == nil {
+		return
+	}
+
+	services, err := removed.Services().Lister().List(labels.Everything())
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	for _, service := range services {
+		c.enqueueRemoteService(removed, service)
+	}
+}
+
+// getRemotes returns the current list of remote clusters
+func (c *ServiceController) getRemotes() []*RemoteCluster {
+	c.remotesLock.RLock()
+	defer c.remotesLock.RUnlock()
+	return c.remotes
+}
+
+// getConfig returns the current configuration
+func (c *ServiceController) getConfig() *config.Config {
+	c.cfgLock.RLock()
+	defer c.cfgLock.RUnlock()
+	return c.cfg
+}
+
+// SetConfig applies a new configuration.
+// utils.IgnoredNamespaces, utils.Exports, utils.NamespaceMappings, utils.ServiceNames, utils.PortSelections and
+// utils.Propagation have to be updated before, as all services in namespaces whose ignored state or service type
+// changed are re-enqueued.
+func (c *ServiceController) SetConfig(cfg *config.Config) {
+	c.cfgLock.Lock()
+	old := c.cfg
+	c.cfg = cfg
+	c.cfgLock.Unlock()
+
+	changed := changedNamespaces(old, cfg)
+	if len(changed) == 0 {
+		return
+	}
+
+	if !reflect.DeepEqual(old.NamespaceMappings, cfg.NamespaceMappings) || old.ServiceNameTemplate != cfg.ServiceNameTemplate {
+		// Local services of the old mappings (or names) are removed if no remote service is mapped to them anymore
+		services, err := c.localServiceLister.List(labels.Everything())
+		if err != nil {
+			runtime.HandleError(err)
+		}
+		for _, service := range services {
+			if utils.OwnerOfService(service) {
+				c.enqueueService(service)
+			}
+		}
+	}
+
+	for _, remote := range c.getRemotes() {
+		services, err := remote.Services().Lister().List(labels.Everything())
+		if err != nil {
+			runtime.HandleError(err)
+			continue
+		}
+		for _, service := range services {
+			// Ignored namespaces are remote namespaces, service types are configured for local namespaces
+			local := utils.NamespaceMappings.Local(service.GetNamespace())
+			if changed.Has(service.GetNamespace()) || changed.Has(local) || changed.Has("") {
+				c.enqueueRemoteService(remote, service)
+			}
+		}
+	}
+}
+
+// changedNamespaces returns the namespaces that are ignored or have a different service type in
+// cfg compared to old. The empty string is included if ignored namespace patterns, included namespaces, the
+// default service type, namespace mappings, service name template, opt-in mode, port selections, load balancer
+// mirroring or ExternalName mode changed.
+func changedNamespaces(old, cfg *config.Config) sets.String {
+	oldIgnored, newIgnored := sets.NewString(old.IgnoredNamespaces...), sets.NewString(cfg.IgnoredNamespaces...)
+	changed := oldIgnored.Difference(newIgnored).Union(newIgnored.Difference(oldIgnored))
+	for _, ns := range changed.List() {
+		// Patterns may match any namespace
+		if utils.IsNamespacePattern(ns) {
+			changed.Insert("")
+			break
+		}
+	}
+	if !sets.NewString(old.IncludedNamespaces...).Equal(sets.NewString(cfg.IncludedNamespaces...)) {
+		changed.Insert("")
+	}
+	if old.ServiceType != cfg.ServiceType || old.MirrorLoadBalancers != cfg.MirrorLoadBalancers ||
+		old.OptIn != cfg.OptIn || old.ExportSelector != cfg.ExportSelector ||
+		!reflect.DeepEqual(old.PortSelections, cfg.PortSelections) ||
+		!reflect.DeepEqual(old.PropagatedLabels, cfg.PropagatedLabels) ||
+		!reflect.DeepEqual(old.PropagatedAnnotations, cfg.PropagatedAnnotations) ||
+		old.ExternalName != cfg.ExternalName || old.ExternalNameTemplate != cfg.ExternalNameTemplate ||
+		!reflect.DeepEqual(old.NamespaceMappings, cfg.NamespaceMappings) || old.ServiceNameTemplate != cfg.ServiceNameTemplate {
+		changed.Insert("")
+	}
+	for ns := range old.NamespaceServiceTypes {
+		if old.ServiceTypeFor(ns) != cfg.ServiceTypeFor(ns) {
+			changed.Insert(ns)
+		}
+	}
+	for ns := range cfg.NamespaceServiceTypes {
+		if old.ServiceTypeFor(ns) != cfg.ServiceTypeFor(ns) {
+			changed.Insert(ns)
+		}
+	}
+	return changed
 }
 
 func (c *ServiceController) Run(workers int, stopCh <-chan struct{}) error {
@@ -130,7 +298,7 @@ func (c *ServiceController) Run(workers int, stopCh <-chan struct{}) error {
 
 	// and wait for their caches to warm up
 	klog.Info("Waiting for informer caches to warm up")
-	if !cache.WaitForCacheSync(stopCh, c.remoteSynced) {
+	if !cache.WaitForCacheSync(stopCh, c.remoteSynced...) {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 
@@ -240,16 +408,28 @@ func (c *ServiceController) syncHandler(key string) (ActionType, error) {
 		* Update local remoteService with new NodePort
 	*/
 
+	// In dry-run mode, actions are collected instead of performed. The plan is set on every return, so actions
+	// planned by earlier syncs are dropped if there is nothing to do anymore (e.g. namespace collisions).
+	var planned []PlannedAction
+	if c.plan != nil {
+		defer func() { c.plan.Set("ServiceController", key, planned) }()
+	}
+
 	// Get remote and local service objects
-	getFunc := func() (*v1.Service, error) {
-		return c.remoteServiceLister.Services(namespace).Get(name)
+	remoteSvc, cluster, remoteExists, err := c.getRemoteService(namespace, name)
+	if collision, ok := err.(*namespaceCollisionError); ok {
+		// Retrying won't help, the mappings (or services) have to be changed
+		runtime.HandleError(collision)
+		if localSvc, err := c.localServiceLister.Services(namespace).Get(name); err == nil {
+			c.event(localSvc, v1.EventTypeWarning, EventReasonNamespaceCollision, "%v", collision)
+		}
+		return ActionTypeNone, nil
 	}
-	remoteSvc, remoteExists, err := utils.GetService(getFunc)
 	if err != nil {
 		return ActionTypeNone, err
 	}
 
-	getFunc = func() (*v1.Service, error) {
+	getFunc := func() (*v1.Service, error) {
 		return c.localClient.CoreV1().Services(namespace).Get(name, metaV1.GetOptions{})
 	}
 	localSvc, localExists, err := utils.GetService(getFunc)
@@ -258,10 +438,43 @@ func (c *ServiceController) syncHandler(key string) (ActionType, error) {
 	}
 
 	// Check what action we need to take on local cluster
-	action := getLocalAction(remoteExists, remoteSvc, localExists, localSvc)
+	action, skipMessage := getLocalAction(remoteExists, remoteSvc, localExists, localSvc)
+	cfg := c.getConfig()
+	serviceType := cfg.ServiceTypeFor(namespace)
+	headless := utils.IsHeadlessMirrored(remoteSvc)
+	var externalName string
+	switch {
+	case (action == ActionTypeAdd || action == ActionTypeUpdate) && utils.IsExternalNameMirrored(remoteSvc):
+		externalName, err = utils.ExternalNameHostname(cfg.ExternalNameTemplate, remoteSvc, cluster)
+		if err != nil {
+			return action, err
+		}
+		serviceType = v1.ServiceTypeExternalName
+		headless = false
+	case headless:
+		// Headless services can't have node ports
+		serviceType = v1.ServiceTypeClusterIP
+	}
+	deleteReason := "Deleted service as there is no remote service barrelman is responsible for"
+	if action == ActionTypeUpdate && utils.IsHeadless(localSvc) != headless {
+		// The cluster IP can't be changed, delete the service. Deleted local services are enqueued again
+		// (see NewServiceController), so it will be recreated.
+		action = ActionTypeDelete
+		deleteReason = "Deleted service to recreate it, as the remote service changed from or to headless"
+	}
+	if skipMessage != "" {
+		c.event(localSvc, v1.EventTypeNormal, EventReasonNotOwned, skipMessage)
+	}
 
 	switch action {
 	case ActionTypeAdd:
+		// Build dummy service ports (ExternalName services only point to a hostname)
+		var dummyPorts []v1.ServicePort
+		if externalName == "" {
+			if dummyPorts, err = getDummyServicePorts(remoteSvc, serviceType); err != nil {
+				return action, err
+			}
+		}
 		// Check if namespace exist
 		_, err := c.localClient.CoreV1().Namespaces().Get(namespace, metaV1.GetOptions{})
 		if err != nil {
@@ -269,51 +482,121 @@ func (c *ServiceController) syncHandler(key string) (ActionType, error) {
 				return action, err
 			}
 
-			// If namespace does not exist (in local), create it
-			klog.Infof("performing \"%s\" action for namespace %s", action, namespace)
-			_, nsErr := c.localClient.CoreV1().Namespaces().Create(&v1.Namespace{
+			// If namespace does not exist (in local), create it (labeled, so NamespaceGC may remove it)
+			ns := &v1.Namespace{
 				ObjectMeta: metaV1.ObjectMeta{
-					Name: namespace,
+					Name:   namespace,
+					Labels: utils.ResourceLabel,
 				},
-			})
-			if nsErr != nil {
-				klog.Errorf("Failed creating namespace '%s' in local cluster", namespace)
-				return action, nsErr
+			}
+			if c.plan != nil {
+				planned = append(planned, newPlannedAction(action, "Namespace", namespace, nil, ns))
+			} else {
+				klog.Infof("performing \"%s\" action for namespace %s", action, namespace)
+				_, nsErr := c.localClient.CoreV1().Namespaces().Create(ns)
+				if nsErr != nil {
+					klog.Errorf("Failed creating namespace '%s' in local cluster", namespace)
+					return action, nsErr
+				}
 			}
 		}
-		// Build dummy service ports
-		dummyPorts := c.getDummyServicePorts(remoteSvc)
-		// Create dummy service
-		klog.Infof("performing \"%s\" action for service %s/%s", action, namespace, name)
-		_, err = c.localClient.CoreV1().Services(namespace).Create(&v1.Service{
+		dummySvc := &v1.Service{
 			ObjectMeta: metaV1.ObjectMeta{
-				Name:      name,
-				Namespace: namespace,
-				Labels:    utils.ResourceLabel,
+				Name:        name,
+				Namespace:   namespace,
+				Labels:      utils.ResourceLabel,
+				Annotations: utils.SourceAnnotations(cluster, remoteSvc),
 			},
 			Spec: v1.ServiceSpec{
 				Ports: dummyPorts,
-				Type:  c.localServiceType,
+				Type:  serviceType,
 			},
-		})
-		return action, err
+		}
+		utils.PropagateMetadata(dummySvc, remoteSvc)
+		dummySvc.Spec.ExternalName = externalName
+		if headless {
+			// Endpoints carry the hostnames of the remote pods, so DNS records are created for them
+			dummySvc.Spec.ClusterIP = v1.ClusterIPNone
+			dummySvc.Spec.PublishNotReadyAddresses = remoteSvc.Spec.PublishNotReadyAddresses
+		}
+		if c.plan != nil {
+			planned = append(planned, newPlannedAction(action, "Service", key, nil, dummySvc))
+			return action, nil
+		}
+		// Create dummy service
+		klog.Infof("performing \"%s\" action for service %s/%s", action, namespace, name)
+		created, err := c.localClient.CoreV1().Services(namespace).Create(dummySvc)
+		if err != nil {
+			c.event(dummySvc, v1.EventTypeWarning, EventReasonCreateFailed,
+				"Failed to create service for remote service in cluster %s: %v", cluster, err)
+			return action, err
+		}
+		c.event(created, v1.EventTypeNormal, EventReasonCreated, "Created service for remote service in cluster %s", cluster)
+		return action, nil
 	case ActionTypeUpdate:
-		dummyPorts := c.getDummyServicePorts(remoteSvc)
-		if utils.ServicePortsEqual(localSvc.Spec.Ports, dummyPorts) {
+		var dummyPorts []v1.ServicePort
+		if externalName == "" {
+			if dummyPorts, err = getDummyServicePorts(remoteSvc, serviceType); err != nil {
+				return action, err
+			}
+		}
+		sourceAnnotations := utils.SourceAnnotations(cluster, remoteSvc)
+		specChanged := !utils.ServicePortsEqual(localSvc.Spec.Ports, dummyPorts) || localSvc.Spec.Type != serviceType ||
+			localSvc.Spec.ExternalName != externalName
+		currentSvc := localSvc.DeepCopy()
+		// Labels and annotations selected for propagation follow the remote service
+		metadataChanged := utils.PropagateMetadata(localSvc, remoteSvc)
+		// The remote resourceVersion changes on every remote write (e.g. status only), it is only refreshed along
+		// with other changes
+		wantAnnotations := make(map[string]string, len(sourceAnnotations))
+		for k, v := range sourceAnnotations {
+			if k != utils.AnnotationRemoteResourceVersion {
+				wantAnnotations[k] = v
+			}
+		}
+		if !specChanged && !metadataChanged && utils.HasAnnotations(localSvc, wantAnnotations) {
 			return ActionTypeNone, nil
 		}
 		// Update localSvc with new port(s)
 		localSvc.Spec.Ports = dummyPorts
-		// When barrelman is restarted with -nodeportsvc flag, localSvc may need to change type
-		localSvc.Spec.Type = c.localServiceType
+		// When the configured service type changes, localSvc may need to change type
+		localSvc.Spec.Type = serviceType
+		localSvc.Spec.ExternalName = externalName
+		if externalName != "" {
+			// ExternalName services must not have a cluster IP
+			localSvc.Spec.ClusterIP = ""
+		}
+		// Keep status annotations up to date (e.g. remote resourceVersion)
+		utils.SetAnnotations(localSvc, sourceAnnotations)
+		if c.plan != nil {
+			planned = append(planned, newPlannedAction(action, "Service", key, currentSvc, localSvc))
+			return action, nil
+		}
 		// NodeEndpointController will pick this up and update endpoints
 		klog.Infof("performing \"%s\" action for service %s/%s", action, namespace, name)
-		_, err := c.localClient.CoreV1().Services(namespace).Update(localSvc)
-		return action, err
+		if _, err := c.localClient.CoreV1().Services(namespace).Update(localSvc); err != nil {
+			c.event(localSvc, v1.EventTypeWarning, EventReasonUpdateFailed,
+				"Failed to update service for remote service in cluster %s: %v", cluster, err)
+			return action, err
+		}
+		if specChanged {
+			c.event(localSvc, v1.EventTypeNormal, EventReasonUpdated,
+				"Updated ports and type (%s) for remote service in cluster %s", serviceType, cluster)
+		}
+		return action, nil
 	case ActionTypeDelete:
+		if c.plan != nil {
+			planned = append(planned, newPlannedAction(action, "Service", key, localSvc, nil))
+			return action, nil
+		}
 		// Delete localSvc
 		klog.Infof("performing \"%s\" action for service %s/%s", action, namespace, name)
-		return action, c.localClient.CoreV1().Services(namespace).Delete(name, &metaV1.DeleteOptions{})
+		if err := c.localClient.CoreV1().Services(namespace).Delete(name, &metaV1.DeleteOptions{}); err != nil {
+			c.event(localSvc, v1.EventTypeWarning, EventReasonDeleteFailed, "Failed to delete service: %v", err)
+			return action, err
+		}
+		c.event(localSvc, v1.EventTypeNormal, EventReasonDeleted, deleteReason)
+		return action, nil
 	case ActionTypeNone:
 		return action, nil
 	}
@@ -321,44 +604,129 @@ func (c *ServiceController) syncHandler(key string) (ActionType, error) {
 	return ActionTypeNone, fmt.Errorf("something wired happened in service syncHandler")
 }
 
+// namespaceCollisionError is returned by getRemoteService if services of different remote namespaces are
+// mapped to the same local service
+type namespaceCollisionError struct {
+	key        string
+	namespaces []string
+}
+
+func (e *namespaceCollisionError) Error() string {
+	return fmt.Sprintf("remote namespaces %s are all mapped to service %s, not syncing it",
+		strings.Join(e.namespaces, ", "), e.key)
+}
+
+// getRemoteService looks up the remote service mirrored to the local service namespace/name in all remote
+// clusters (see RemoteCluster.MirroredServices).
+// The first service barrelman is responsible for is returned. If there is none, the first existing one is returned
+// (so getLocalAction can decide what to do with it).
+// The name of the cluster the service has been found in is returned as well.
+// If services barrelman is responsible for are found in different remote namespaces, a namespaceCollisionError
+// is returned.
+func (c *ServiceController) getRemoteService(namespace, name string) (*v1.Service, string, bool, error) {
+	localSvc, err := c.localServiceLister.Services(namespace).Get(name)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return nil, "", false, err
+		}
+		localSvc = nil
+	}
+	var found, responsible *v1.Service
+	var foundCluster, responsibleCluster string
+	namespaces := sets.NewString()
+	for _, remote := range c.getRemotes() {
+		services, err := remote.MirroredServices(namespace, name, localSvc)
+		if err != nil {
+			return nil, "", false, err
+		}
+		for _, remoteSvc := range services {
+			if !remote.NamespaceSelected(remoteSvc.GetNamespace()) {
+				// Services of namespaces that are not selected are ignored completely
+				continue
+			}
+			if !utils.ResponsibleForRemoteService(remoteSvc) {
+				if found == nil {
+					found, foundCluster = remoteSvc, remote.Name
+				}
+				continue
+			}
+			namespaces.Insert(remoteSvc.GetNamespace())
+			if responsible == nil {
+				klog.V(4).Infof("remote: %s/%s using service from cluster %s", namespace, name, remote.Name)
+				responsible, responsibleCluster = remoteSvc, remote.Name
+			}
+		}
+	}
+	if namespaces.Len() > 1 {
+		return nil, "", false, &namespaceCollisionError{key: namespace + "/" + name, namespaces: namespaces.List()}
+	}
+	if responsible != nil {
+		return responsible, responsibleCluster, true, nil
+	}
+	return found, foundCluster, found != nil, nil
+}
+
 // getDummyServicePorts created a new slice of ServicePort to be used for the local dummy service
 // For each port, the remote service NodePort must be the dummy service target port (so endpoints will
-// point to remote NodePort)
-func (c *ServiceController) getDummyServicePorts(remoteSvc *v1.Service) []v1.ServicePort {
-	dummyPorts := make([]v1.ServicePort, len(remoteSvc.Spec.Ports))
-	for idx, port := range remoteSvc.Spec.Ports {
+// point to remote NodePort). Mirrored LoadBalancer services use the service port of the load balancer,
+// services mirrored in pod IP mode keep the original target port.
+// Only the mirrored ports (see utils.MirroredPorts) are included, with their local port number.
+func getDummyServicePorts(remoteSvc *v1.Service, serviceType v1.ServiceType) ([]v1.ServicePort, error) {
+	if len(remoteSvc.Spec.Ports) == 0 {
+		// Headless services may not have ports
+		return nil, nil
+	}
+	mirroredPorts, err := utils.MirroredPorts(remoteSvc)
+	if err != nil {
+		return nil, err
+	}
+	podIPs := utils.IsPodIPMirrored(remoteSvc)
+	loadBalancer := utils.IsLoadBalancerMirrored(remoteSvc)
+	dummyPorts := make([]v1.ServicePort, len(mirroredPorts))
+	for idx, mirrored := range mirroredPorts {
+		port := mirrored.Remote
 		// Ensure we don't modify the input
 		dummyPorts[idx] = *port.DeepCopy()
-		dummyPorts[idx].TargetPort = intstr.FromInt(int(port.NodePort))
-		if c.localServiceType != v1.ServiceTypeNodePort {
+		dummyPorts[idx].Port = mirrored.Port
+		switch {
+		case podIPs:
+			// Keep the target port, endpoints point to the remote pods
+		case loadBalancer:
+			dummyPorts[idx].TargetPort = intstr.FromInt(int(port.Port))
+		default:
+			dummyPorts[idx].TargetPort = intstr.FromInt(int(port.NodePort))
+		}
+		if serviceType != v1.ServiceTypeNodePort {
 			// Unset NodePort
 			dummyPorts[idx].NodePort = 0
 		}
 	}
-	return dummyPorts
+	return dummyPorts, nil
 }
 
-// getLocalAction returns the type of action (ActionType) to take on local service
-func getLocalAction(remoteExists bool, remoteSvc *v1.Service, localExists bool, localSvc *v1.Service) ActionType {
+// getLocalAction returns the type of action (ActionType) to take on local service.
+// If an action is skipped because the local service is not owned by barrelman, a message explaining
+// the decision is returned as well.
+func getLocalAction(remoteExists bool, remoteSvc *v1.Service, localExists bool, localSvc *v1.Service) (ActionType, string) {
 	if remoteExists && utils.ResponsibleForRemoteService(remoteSvc) {
 		klog.V(4).Infof("remote: %s/%s I'm responsible", remoteSvc.GetNamespace(), remoteSvc.GetName())
 
 		if localExists {
 			if !utils.OwnerOfService(localSvc) {
 				klog.V(4).Infof("local: %s/%s I don't own this service, SKIP", localSvc.GetNamespace(), localSvc.GetName())
-				return ActionTypeNone
+				return ActionTypeNone, "Remote service exists but local service is not managed by barrelman, not updating it"
 			}
 
 			if !utils.ResponsibleForService(localSvc) {
 				klog.V(4).Infof("local: %s/%s not responsible for service, SKIP", localSvc.GetNamespace(), localSvc.GetName())
-				return ActionTypeNone
+				return ActionTypeNone, ""
 			}
 
 			klog.V(4).Infof("remote,local: %s/%s both exist, UPDATE", localSvc.GetNamespace(), localSvc.GetName())
-			return ActionTypeUpdate
+			return ActionTypeUpdate, ""
 		} else {
 			klog.V(4).Infof("local: %s/%s does not exist, ADD", remoteSvc.GetNamespace(), remoteSvc.GetName())
-			return ActionTypeAdd
+			return ActionTypeAdd, ""
 		}
 	}
 
@@ -369,19 +737,38 @@ func getLocalAction(remoteExists bool, remoteSvc *v1.Service, localExists bool,
 		if localExists {
 			if !utils.OwnerOfService(localSvc) {
 				klog.V(4).Infof("local: %s/%s I don't own this service, SKIP", localSvc.GetNamespace(), localSvc.GetName())
-				return ActionTypeNone
+				return ActionTypeNone, "No (responsible) remote service but local service is not managed by barrelman, not deleting it"
 			}
 
 			if !utils.ResponsibleForService(localSvc) {
 				klog.V(4).Infof("local: %s/%s exists but not responsible, SKIP", localSvc.GetNamespace(), localSvc.GetName())
-				return ActionTypeNone
+				return ActionTypeNone, ""
 			}
 			klog.V(4).Infof("local: %s/%s does exist, DELETE", localSvc.GetNamespace(), localSvc.GetName())
-			return ActionTypeDelete
+			return ActionTypeDelete, ""
 		}
 	}
 
-	return ActionTypeNone
+	return ActionTypeNone, ""
+}
+
+// event records a event on a local service. No events are recorded in dry-run mode.
+func (c *ServiceController) event(service *v1.Service, eventType, reason, messageFmt string, args ...interface{}) {
+	if c.plan != nil {
+		return
+	}
+	c.recorder.Eventf(service, eventType, reason, messageFmt, args...)
+}
+
+// enqueueRemoteService adds the key of the local service mirroring a remote service to the queue
+func (c *ServiceController) enqueueRemoteService(remote *RemoteCluster, service *v1.Service) {
+	key, err := utils.LocalServiceKey(service.GetNamespace(), service.GetName(), remote.Name)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	c.queue.Add(key)
+	metrics.ObjectsQueued.WithLabelValues("ServiceController", "false").Inc()
 }
 
 // enqueueService adds a service (key) to the queue
@@ -1,19 +1,27 @@
 package controller
 
 import (
+	"barrelman/config"
 	"barrelman/utils"
+	"reflect"
 	"testing"
+	"time"
 
+	"github.com/davecgh/go-spew/spew"
 	"k8s.io/apimachinery/pkg/util/intstr"
 
 	v1 "k8s.io/api/core/v1"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
 	kubeinformers "k8s.io/client-go/informers"
 	k8sfake "k8s.io/client-go/kubernetes/fake"
 	core "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 )
 
 var ()
@@ -22,8 +30,14 @@ type scFixture struct {
 	baseFixture
 
 	// Objects to put in the stores
-	remoteServiceLister []*v1.Service
-	localServiceLister  []*v1.Service
+	remoteServiceLister   []*v1.Service
+	localServiceLister    []*v1.Service
+	remoteNamespaceLister []*v1.Namespace
+
+	// namespaceSelector is set as RemoteCluster.NamespaceSelector if not nil
+	namespaceSelector labels.Selector
+
+	cfg *config.Config
 }
 
 func newScFixture(t *testing.T) *scFixture {
@@ -39,6 +53,7 @@ func newScFixture(t *testing.T) *scFixture {
 				{"get", "namespaces"},
 			},
 		},
+		cfg: config.Default(),
 	}
 	return f
 }
@@ -47,16 +62,26 @@ func (f *scFixture) newController(createNodePortSvc bool) (*ServiceController, k
 	f.localClient = k8sfake.NewSimpleClientset(f.localObjects...)
 	f.remoteClient = k8sfake.NewSimpleClientset(f.remoteObjects...)
 
-	remoteServiceInformer := kubeinformers.NewSharedInformerFactory(f.remoteClient, noResyncPeriodFunc())
+	remote := NewRemoteCluster("remote", f.remoteClient, noResyncPeriodFunc())
+	remote.NamespaceSelector = f.namespaceSelector
+	remoteServiceInformer := remote.InformerFactory
 	localServiceInformer := kubeinformers.NewSharedInformerFactory(f.localClient, noResyncPeriodFunc())
 
+	if createNodePortSvc {
+		f.cfg.ServiceType = v1.ServiceTypeNodePort
+	}
 	c := NewServiceController(
-		f.localClient, f.remoteClient,
-		remoteServiceInformer.Core().V1().Services(), localServiceInformer.Core().V1().Services(),
-		createNodePortSvc,
+		f.localClient, []*RemoteCluster{remote},
+		localServiceInformer.Core().V1().Services(),
+		f.cfg,
 	)
 
-	c.remoteSynced = alwaysReady
+	c.remoteSynced = []cache.InformerSynced{alwaysReady}
+	f.recorder = record.NewFakeRecorder(100)
+	c.recorder = f.recorder
+	if f.plan != nil {
+		c.SetDryRun(f.plan)
+	}
 
 	// Preload test objects into informers
 	for _, s := range f.remoteServiceLister {
@@ -65,6 +90,12 @@ func (f *scFixture) newController(createNodePortSvc bool) (*ServiceController, k
 			f.t.Errorf("Failed to add remote service: %v", err)
 		}
 	}
+	for _, ns := range f.remoteNamespaceLister {
+		err := remote.Namespaces().Informer().GetIndexer().Add(ns)
+		if err != nil {
+			f.t.Errorf("Failed to add remote namespace: %v", err)
+		}
+	}
 	for _, s := range f.localServiceLister {
 		err := localServiceInformer.Core().V1().Services().Informer().GetIndexer().Add(s)
 		if err != nil {
@@ -132,7 +163,8 @@ func (f *scFixture) expectCreateNamespaceAction(n *v1.Namespace) {
 func scNewNamespace() *v1.Namespace {
 	return &v1.Namespace{
 		ObjectMeta: metaV1.ObjectMeta{
-			Name: serviceNamespace,
+			Name:   serviceNamespace,
+			Labels: utils.ResourceLabel,
 		},
 	}
 }
@@ -169,6 +201,7 @@ func TestCreatesService(t *testing.T) {
 	// Expect a service with ResourceLabel in local cluster
 	localService := scNewService()
 	localService.Labels = utils.ResourceLabel
+	localService.Annotations = utils.SourceAnnotations("remote", remoteService)
 	localService.Spec.Type = v1.ServiceTypeClusterIP
 	localService.Spec.Ports = []v1.ServicePort{
 		{
@@ -180,6 +213,7 @@ func TestCreatesService(t *testing.T) {
 	f.expectCreateServiceAction(localService)
 
 	f.runClusterIP(getKey(remoteService, t))
+	f.expectEvents("Normal Created Created service for remote service in cluster remote")
 }
 
 func TestCreatesNodePortService(t *testing.T) {
@@ -194,6 +228,7 @@ func TestCreatesNodePortService(t *testing.T) {
 	// Expect a service with ResourceLabel in local cluster
 	localService := scNewService()
 	localService.Labels = utils.ResourceLabel
+	localService.Annotations = utils.SourceAnnotations("remote", remoteService)
 	localService.Spec.Type = v1.ServiceTypeNodePort
 	localService.Spec.Ports = []v1.ServicePort{
 		{
@@ -208,6 +243,172 @@ func TestCreatesNodePortService(t *testing.T) {
 	f.runNodePort(getKey(remoteService, t))
 }
 
+func TestCreatesLoadBalancerService(t *testing.T) {
+	f := newScFixture(t)
+
+	remoteService := scNewService()
+	remoteService.Annotations = map[string]string{utils.LoadBalancerAnnotation: "true"}
+	remoteService.Spec.Type = v1.ServiceTypeLoadBalancer
+	f.remoteServiceLister = append(f.remoteServiceLister, remoteService)
+	f.remoteObjects = append(f.remoteObjects, remoteService)
+
+	f.expectCreateNamespaceAction(scNewNamespace())
+
+	// Expect the service port (of the load balancer) as target port
+	localService := scNewService()
+	localService.Labels = utils.ResourceLabel
+	localService.Annotations = utils.SourceAnnotations("remote", remoteService)
+	localService.Spec.Type = v1.ServiceTypeClusterIP
+	localService.Spec.Ports = []v1.ServicePort{
+		{
+			Name:       portName,
+			Port:       portNum,
+			TargetPort: intstr.FromInt(portNum),
+		},
+	}
+	f.expectCreateServiceAction(localService)
+
+	f.runClusterIP(getKey(remoteService, t))
+}
+
+func TestCreatesPodIPService(t *testing.T) {
+	utils.SetMirrorPodIPs(true)
+	defer utils.SetMirrorPodIPs(false)
+	f := newScFixture(t)
+
+	// ClusterIP services are mirrored in pod IP mode
+	remoteService := scNewService()
+	remoteService.Spec.Type = v1.ServiceTypeClusterIP
+	remoteService.Spec.Ports[0].NodePort = 0
+	remoteService.Spec.Ports[0].TargetPort = intstr.FromString("http")
+	f.remoteServiceLister = append(f.remoteServiceLister, remoteService)
+	f.remoteObjects = append(f.remoteObjects, remoteService)
+
+	f.expectCreateNamespaceAction(scNewNamespace())
+
+	// Expect the original target port
+	localService := scNewService()
+	localService.Labels = utils.ResourceLabel
+	localService.Annotations = utils.SourceAnnotations("remote", remoteService)
+	localService.Spec.Type = v1.ServiceTypeClusterIP
+	localService.Spec.Ports = []v1.ServicePort{
+		{
+			Name:       portName,
+			Port:       portNum,
+			TargetPort: intstr.FromString("http"),
+		},
+	}
+	f.expectCreateServiceAction(localService)
+
+	f.runClusterIP(getKey(remoteService, t))
+}
+
+func TestCreatesExternalNameService(t *testing.T) {
+	f := newScFixture(t)
+	f.cfg.ExternalNameTemplate = "{{.Name}}.{{.Namespace}}.remote.example.com"
+
+	// Opt in via annotation
+	remoteService := scNewService()
+	remoteService.Annotations = map[string]string{utils.ExternalNameAnnotation: "true"}
+	f.remoteServiceLister = append(f.remoteServiceLister, remoteService)
+	f.remoteObjects = append(f.remoteObjects, remoteService)
+
+	f.expectCreateNamespaceAction(scNewNamespace())
+
+	localService := scNewService()
+	localService.Labels = utils.ResourceLabel
+	localService.Annotations = utils.SourceAnnotations("remote", remoteService)
+	localService.Spec = v1.ServiceSpec{
+		Type:         v1.ServiceTypeExternalName,
+		ExternalName: serviceName + "." + serviceNamespace + ".remote.example.com",
+	}
+	f.expectCreateServiceAction(localService)
+
+	f.runNodePort(getKey(remoteService, t))
+}
+
+func TestUpdateServiceToExternalName(t *testing.T) {
+	utils.SetMirrorExternalName(true)
+	defer utils.SetMirrorExternalName(false)
+	f := newScFixture(t)
+	f.cfg.ExternalName = true
+	f.cfg.ExternalNameTemplate = "{{.Name}}.{{.Namespace}}.{{.Cluster}}.example.com"
+
+	remoteService := scNewService()
+	f.remoteServiceLister = append(f.remoteServiceLister, remoteService)
+	f.remoteObjects = append(f.remoteObjects, remoteService)
+
+	localService := scNewService()
+	localService.Labels = utils.ResourceLabel
+	localService.Annotations = utils.SourceAnnotations("remote", remoteService)
+	localService.Spec.Type = v1.ServiceTypeClusterIP
+	localService.Spec.ClusterIP = "10.96.0.10"
+	localService.Spec.Ports[0].NodePort = 0
+	localService.Spec.Ports[0].TargetPort = intstr.FromInt(portNodePort)
+	f.localObjects = append(f.localObjects, localService)
+
+	// Cluster IP and ports are removed
+	expService := localService.DeepCopy()
+	expService.Spec = v1.ServiceSpec{
+		Type:         v1.ServiceTypeExternalName,
+		ExternalName: serviceName + "." + serviceNamespace + ".remote.example.com",
+	}
+	f.expectUpdateServiceAction(expService)
+
+	f.runClusterIP(getKey(remoteService, t))
+}
+
+func TestCreatesHeadlessService(t *testing.T) {
+	utils.SetMirrorHeadless(true)
+	defer utils.SetMirrorHeadless(false)
+	f := newScFixture(t)
+
+	remoteService := scNewService()
+	remoteService.Spec.Type = v1.ServiceTypeClusterIP
+	remoteService.Spec.ClusterIP = v1.ClusterIPNone
+	remoteService.Spec.PublishNotReadyAddresses = true
+	remoteService.Spec.Ports = nil
+	f.remoteServiceLister = append(f.remoteServiceLister, remoteService)
+	f.remoteObjects = append(f.remoteObjects, remoteService)
+
+	f.expectCreateNamespaceAction(scNewNamespace())
+
+	// Expect a headless service, even if NodePort services are configured
+	localService := scNewService()
+	localService.Labels = utils.ResourceLabel
+	localService.Annotations = utils.SourceAnnotations("remote", remoteService)
+	localService.Spec.Type = v1.ServiceTypeClusterIP
+	localService.Spec.ClusterIP = v1.ClusterIPNone
+	localService.Spec.PublishNotReadyAddresses = true
+	localService.Spec.Ports = nil
+	f.expectCreateServiceAction(localService)
+
+	f.runNodePort(getKey(remoteService, t))
+}
+
+func TestRecreatesServiceChangedToHeadless(t *testing.T) {
+	utils.SetMirrorHeadless(true)
+	defer utils.SetMirrorHeadless(false)
+	f := newScFixture(t)
+
+	remoteService := scNewService()
+	remoteService.Spec.ClusterIP = v1.ClusterIPNone
+	f.remoteServiceLister = append(f.remoteServiceLister, remoteService)
+	f.remoteObjects = append(f.remoteObjects, remoteService)
+
+	// Local service still has a cluster IP, which can't be changed
+	localService := scNewService()
+	localService.Labels = utils.ResourceLabel
+	localService.Spec.Type = v1.ServiceTypeClusterIP
+	localService.Spec.ClusterIP = "10.96.0.10"
+	f.localObjects = append(f.localObjects, localService)
+
+	f.expectDeleteServiceAction(localService)
+
+	f.runClusterIP(getKey(remoteService, t))
+	f.expectEvents("Normal Deleted Deleted service to recreate it, as the remote service changed from or to headless")
+}
+
 func TestDoNothing(t *testing.T) {
 	f := newScFixture(t)
 
@@ -217,6 +418,7 @@ func TestDoNothing(t *testing.T) {
 
 	localService := scNewService()
 	localService.Labels = utils.ResourceLabel
+	localService.Annotations = utils.SourceAnnotations("remote", remoteService)
 	localService.Spec.Type = v1.ServiceTypeClusterIP
 	localService.Spec.Ports = []v1.ServicePort{
 		{
@@ -239,6 +441,7 @@ func TestUpdateService(t *testing.T) {
 
 	localService := scNewService()
 	localService.Labels = utils.ResourceLabel
+	localService.Annotations = utils.SourceAnnotations("remote", remoteService)
 	// Simulate an already created local service to update
 	localService.Spec.Type = v1.ServiceTypeClusterIP
 
@@ -265,6 +468,41 @@ func TestUpdateService(t *testing.T) {
 	f.runClusterIP(getKey(remoteService, t))
 }
 
+func TestUpdateServiceReorderedPorts(t *testing.T) {
+	f := newScFixture(t)
+
+	remoteService := scNewService()
+	remoteService.Spec.Ports = append(remoteService.Spec.Ports, v1.ServicePort{
+		Name:       "https",
+		Port:       443,
+		TargetPort: intstr.FromInt(443),
+		NodePort:   portNodePort + 1,
+	})
+	f.remoteServiceLister = append(f.remoteServiceLister, remoteService)
+	f.remoteObjects = append(f.remoteObjects, remoteService)
+
+	// The local service has the same ports in a different order, no update needed
+	localService := scNewService()
+	localService.Labels = utils.ResourceLabel
+	localService.Annotations = utils.SourceAnnotations("remote", remoteService)
+	localService.Spec.Type = v1.ServiceTypeClusterIP
+	localService.Spec.Ports = []v1.ServicePort{
+		{
+			Name:       "https",
+			Port:       443,
+			TargetPort: intstr.FromInt(portNodePort + 1),
+		},
+		{
+			Name:       portName,
+			Port:       portNum,
+			TargetPort: intstr.FromInt(portNodePort),
+		},
+	}
+	f.localObjects = append(f.localObjects, localService)
+
+	f.runClusterIP(getKey(remoteService, t))
+}
+
 func TestUpdateServiceNodePort(t *testing.T) {
 	f := newScFixture(t)
 
@@ -274,6 +512,7 @@ func TestUpdateServiceNodePort(t *testing.T) {
 
 	localService := scNewService()
 	localService.Labels = utils.ResourceLabel
+	localService.Annotations = utils.SourceAnnotations("remote", remoteService)
 	// Simulate an already created local service to update
 	localService.Spec.Type = v1.ServiceTypeClusterIP
 
@@ -304,76 +543,714 @@ func TestUpdateServiceNodePort(t *testing.T) {
 	f.runNodePort(getKey(remoteService, t))
 }
 
-func TestDeleteService(t *testing.T) {
+func TestUpdateServiceNamespaceServiceType(t *testing.T) {
 	f := newScFixture(t)
+	f.cfg.NamespaceServiceTypes = map[string]v1.ServiceType{serviceNamespace: v1.ServiceTypeNodePort}
 
 	remoteService := scNewService()
-	remoteService.Annotations = utils.IgnoreAnnotation
 	f.remoteServiceLister = append(f.remoteServiceLister, remoteService)
 	f.remoteObjects = append(f.remoteObjects, remoteService)
 
+	// Local service is up to date for the default service type (ClusterIP)
 	localService := scNewService()
 	localService.Labels = utils.ResourceLabel
+	localService.Annotations = utils.SourceAnnotations("remote", remoteService)
+	localService.Spec.Type = v1.ServiceTypeClusterIP
+	localService.Spec.Ports = []v1.ServicePort{
+		{
+			Name:       portName,
+			Port:       portNum,
+			TargetPort: intstr.FromInt(portNodePort),
+		},
+	}
 	f.localObjects = append(f.localObjects, localService)
 
-	f.expectDeleteServiceAction(localService)
+	// Namespace override requires a NodePort service
+	expectService := localService.DeepCopy()
+	expectService.Spec.Type = v1.ServiceTypeNodePort
+	expectService.Spec.Ports[0].NodePort = portNodePort
+
+	f.expectUpdateServiceAction(expectService)
 	f.runClusterIP(getKey(remoteService, t))
 }
 
-func TestGetLocalAction(t *testing.T) {
-	type testPair struct {
-		remoteExists, localExists bool
-		remote, local             *v1.Service
-		output                    ActionType
+func TestUpdateServiceStatus(t *testing.T) {
+	f := newScFixture(t)
+
+	remoteService := scNewService()
+	remoteService.ResourceVersion = "42"
+	f.remoteServiceLister = append(f.remoteServiceLister, remoteService)
+	f.remoteObjects = append(f.remoteObjects, remoteService)
+
+	// Local service is up to date, but was created from an older version of the remote service
+	localService := scNewService()
+	localService.Labels = utils.ResourceLabel
+	localService.Annotations = map[string]string{
+		"foo":                                 "bar",
+		utils.AnnotationSourceCluster:         "remote",
+		utils.AnnotationRemoteResourceVersion: "41",
 	}
-	var tests = []testPair{
+	localService.Spec.Type = v1.ServiceTypeClusterIP
+	localService.Spec.Ports = []v1.ServicePort{
 		{
-			remoteExists: false,
-			remote:       &v1.Service{},
-			localExists:  false,
-			local:        &v1.Service{},
-			output:       ActionTypeNone,
+			Name:       portName,
+			Port:       portNum,
+			TargetPort: intstr.FromInt(portNodePort),
 		},
+	}
+	f.localObjects = append(f.localObjects, localService)
+
+	expectService := localService.DeepCopy()
+	expectService.Annotations = map[string]string{
+		"foo":                                 "bar",
+		utils.AnnotationSourceCluster:         "remote",
+		utils.AnnotationSourceNamespace:       remoteService.GetNamespace(),
+		utils.AnnotationSourceName:            remoteService.GetName(),
+		utils.AnnotationRemoteResourceVersion: "42",
+		utils.AnnotationRemoteNodePorts:       "foo-port:54321",
+	}
+
+	f.expectUpdateServiceAction(expectService)
+	f.runClusterIP(getKey(remoteService, t))
+	// Only ports or type changes are worth an event
+	f.expectEvents()
+}
+
+func TestUpdateServiceRemoteResourceVersion(t *testing.T) {
+	f := newScFixture(t)
+
+	remoteService := scNewService()
+	remoteService.ResourceVersion = "41"
+	f.remoteServiceLister = append(f.remoteServiceLister, remoteService)
+	f.remoteObjects = append(f.remoteObjects, remoteService)
+
+	localService := scNewService()
+	localService.Labels = utils.ResourceLabel
+	localService.Annotations = utils.SourceAnnotations("remote", remoteService)
+	localService.Spec.Type = v1.ServiceTypeClusterIP
+	localService.Spec.Ports = []v1.ServicePort{
 		{
-			remoteExists: true,
-			remote: &v1.Service{
-				ObjectMeta: metaV1.ObjectMeta{},
-				Spec: v1.ServiceSpec{
-					Type: v1.ServiceTypeNodePort,
-				},
-			},
-			localExists: false,
-			local:       &v1.Service{},
-			output:      ActionTypeAdd,
+			Name:       portName,
+			Port:       portNum,
+			TargetPort: intstr.FromInt(portNodePort),
 		},
+	}
+	f.localObjects = append(f.localObjects, localService)
+
+	// Remote changes not affecting the local service (e.g. of its status) don't cause updates
+	remoteService.ResourceVersion = "42"
+	f.runClusterIP(getKey(remoteService, t))
+}
+
+func TestCreatesServiceWithSelectedPorts(t *testing.T) {
+	f := newScFixture(t)
+
+	remoteService := scNewService()
+	remoteService.Annotations = map[string]string{utils.PortsAnnotation: portName + "=8080"}
+	remoteService.Spec.Ports = append(remoteService.Spec.Ports, v1.ServicePort{
+		Name:     "admin",
+		Port:     9090,
+		NodePort: 31090,
+	})
+	f.remoteServiceLister = append(f.remoteServiceLister, remoteService)
+	f.remoteObjects = append(f.remoteObjects, remoteService)
+
+	f.expectCreateNamespaceAction(scNewNamespace())
+
+	// Expect only the selected port, with its local port number
+	localService := scNewService()
+	localService.Labels = utils.ResourceLabel
+	localService.Annotations = utils.SourceAnnotations("remote", remoteService)
+	localService.Spec.Type = v1.ServiceTypeClusterIP
+	localService.Spec.Ports = []v1.ServicePort{
 		{
-			remoteExists: true,
-			remote: &v1.Service{
-				ObjectMeta: metaV1.ObjectMeta{},
-				Spec: v1.ServiceSpec{
-					Type: v1.ServiceTypeNodePort,
-				},
-			},
-			localExists: true,
-			local: &v1.Service{
-				ObjectMeta: metaV1.ObjectMeta{},
-				Spec: v1.ServiceSpec{
-					Type: v1.ServiceTypeNodePort,
-				},
-			},
-			output: ActionTypeNone,
+			Name:       portName,
+			Port:       8080,
+			TargetPort: intstr.FromInt(portNodePort),
 		},
-		{
-			remoteExists: true,
-			remote: &v1.Service{
-				ObjectMeta: metaV1.ObjectMeta{},
-				Spec: v1.ServiceSpec{
-					Type: v1.ServiceTypeNodePort,
-				},
-			},
-			localExists: true,
-			local: &v1.Service{
-				ObjectMeta: metaV1.ObjectMeta{
+	}
+	f.expectCreateServiceAction(localService)
+
+	f.runClusterIP(getKey(remoteService, t))
+}
+
+func TestUpdateServiceConfiguredPortSelection(t *testing.T) {
+	f := newScFixture(t)
+	if err := utils.PortSelections.Replace(map[string]string{serviceNamespace + "/" + serviceName: "-admin"}); err != nil {
+		t.Fatal(err)
+	}
+	defer utils.PortSelections.Replace(nil)
+
+	remoteService := scNewService()
+	remoteService.Spec.Ports = append(remoteService.Spec.Ports, v1.ServicePort{
+		Name:     "admin",
+		Port:     9090,
+		NodePort: 31090,
+	})
+	f.remoteServiceLister = append(f.remoteServiceLister, remoteService)
+	f.remoteObjects = append(f.remoteObjects, remoteService)
+
+	// Local service still mirroring the excluded port
+	localService := scNewService()
+	localService.Labels = utils.ResourceLabel
+	localService.Annotations = utils.SourceAnnotations("remote", remoteService)
+	localService.Spec.Type = v1.ServiceTypeClusterIP
+	localService.Spec.Ports = []v1.ServicePort{
+		{Name: portName, Port: portNum, TargetPort: intstr.FromInt(portNodePort)},
+		{Name: "admin", Port: 9090, TargetPort: intstr.FromInt(31090)},
+	}
+	f.localServiceLister = append(f.localServiceLister, localService)
+	f.localObjects = append(f.localObjects, localService)
+
+	expService := localService.DeepCopy()
+	expService.Spec.Ports = expService.Spec.Ports[:1]
+	f.expectUpdateServiceAction(expService)
+
+	f.runClusterIP(getKey(remoteService, t))
+}
+
+func TestCreatesServiceWithPropagatedMetadata(t *testing.T) {
+	f := newScFixture(t)
+	if err := utils.Propagation.Replace([]string{"team", "app.kubernetes.io/*"}, []string{"prometheus.io/*"}); err != nil {
+		t.Fatal(err)
+	}
+	defer utils.Propagation.Replace(nil, nil)
+
+	remoteService := scNewService()
+	remoteService.Labels = map[string]string{"team": "shop", "app.kubernetes.io/name": "api", "other": "foo"}
+	remoteService.Annotations = map[string]string{"prometheus.io/scrape": "true"}
+	f.remoteServiceLister = append(f.remoteServiceLister, remoteService)
+	f.remoteObjects = append(f.remoteObjects, remoteService)
+
+	f.expectCreateNamespaceAction(scNewNamespace())
+
+	localService := scNewService()
+	localService.Labels = map[string]string{
+		utils.LabelAnnotationKey: utils.LabelValueManagedResource,
+		"team":                   "shop",
+		"app.kubernetes.io/name": "api",
+	}
+	localService.Annotations = utils.SourceAnnotations("remote", remoteService)
+	localService.Annotations["prometheus.io/scrape"] = "true"
+	localService.Spec.Type = v1.ServiceTypeClusterIP
+	localService.Spec.Ports = []v1.ServicePort{
+		{
+			Name:       portName,
+			Port:       portNum,
+			TargetPort: intstr.FromInt(portNodePort),
+		},
+	}
+	f.expectCreateServiceAction(localService)
+
+	f.runClusterIP(getKey(remoteService, t))
+}
+
+func TestUpdateServicePropagatedMetadata(t *testing.T) {
+	f := newScFixture(t)
+	if err := utils.Propagation.Replace([]string{"team"}, []string{"prometheus.io/*"}); err != nil {
+		t.Fatal(err)
+	}
+	defer utils.Propagation.Replace(nil, nil)
+
+	remoteService := scNewService()
+	remoteService.Labels = map[string]string{"team": "checkout"}
+	f.remoteServiceLister = append(f.remoteServiceLister, remoteService)
+	f.remoteObjects = append(f.remoteObjects, remoteService)
+
+	// Ports and status annotations are up to date, only propagated metadata changed
+	localService := scNewService()
+	localService.Labels = map[string]string{utils.LabelAnnotationKey: utils.LabelValueManagedResource, "team": "shop"}
+	localService.Annotations = utils.SourceAnnotations("remote", remoteService)
+	localService.Annotations["prometheus.io/scrape"] = "true"
+	localService.Spec.Type = v1.ServiceTypeClusterIP
+	localService.Spec.Ports = []v1.ServicePort{
+		{Name: portName, Port: portNum, TargetPort: intstr.FromInt(portNodePort)},
+	}
+	f.localServiceLister = append(f.localServiceLister, localService)
+	f.localObjects = append(f.localObjects, localService)
+
+	expService := localService.DeepCopy()
+	expService.Labels["team"] = "checkout"
+	delete(expService.Annotations, "prometheus.io/scrape")
+	f.expectUpdateServiceAction(expService)
+
+	f.runClusterIP(getKey(remoteService, t))
+}
+
+func TestDeleteService(t *testing.T) {
+	f := newScFixture(t)
+
+	remoteService := scNewService()
+	remoteService.Annotations = utils.IgnoreAnnotation
+	f.remoteServiceLister = append(f.remoteServiceLister, remoteService)
+	f.remoteObjects = append(f.remoteObjects, remoteService)
+
+	localService := scNewService()
+	localService.Labels = utils.ResourceLabel
+	f.localObjects = append(f.localObjects, localService)
+
+	f.expectDeleteServiceAction(localService)
+	f.runClusterIP(getKey(remoteService, t))
+	f.expectEvents("Normal Deleted")
+}
+
+func TestCreatesServiceInMappedNamespace(t *testing.T) {
+	mappings := []utils.NamespaceMapping{{Remote: "*-prod", Local: "*"}}
+	if err := utils.NamespaceMappings.Replace(mappings); err != nil {
+		t.Fatal(err)
+	}
+	defer utils.NamespaceMappings.Replace(nil)
+	f := newScFixture(t)
+	f.cfg.NamespaceMappings = mappings
+
+	remoteService := scNewService()
+	remoteService.Namespace = serviceNamespace + "-prod"
+	f.remoteServiceLister = append(f.remoteServiceLister, remoteService)
+	f.remoteObjects = append(f.remoteObjects, remoteService)
+
+	f.expectCreateNamespaceAction(scNewNamespace())
+
+	// The remote service is found by the key of the (deleted) local service
+	localService := scNewService()
+	localService.Labels = utils.ResourceLabel
+	localService.Annotations = utils.SourceAnnotations("remote", remoteService)
+	localService.Spec.Type = v1.ServiceTypeClusterIP
+	localService.Spec.Ports = []v1.ServicePort{
+		{
+			Name:       portName,
+			Port:       portNum,
+			TargetPort: intstr.FromInt(portNodePort),
+		},
+	}
+	f.expectCreateServiceAction(localService)
+
+	f.runClusterIP(getKey(localService, t))
+	f.expectEvents("Normal Created")
+}
+
+func TestNamespaceCollision(t *testing.T) {
+	mappings := []utils.NamespaceMapping{{Remote: "*-prod", Local: "*"}}
+	if err := utils.NamespaceMappings.Replace(mappings); err != nil {
+		t.Fatal(err)
+	}
+	defer utils.NamespaceMappings.Replace(nil)
+	f := newScFixture(t)
+	f.cfg.NamespaceMappings = mappings
+
+	// Both remote services are mirrored to foo-namespace/foo-name
+	for _, ns := range []string{serviceNamespace, serviceNamespace + "-prod"} {
+		remoteService := scNewService()
+		remoteService.Namespace = ns
+		f.remoteServiceLister = append(f.remoteServiceLister, remoteService)
+		f.remoteObjects = append(f.remoteObjects, remoteService)
+	}
+
+	localService := scNewService()
+	localService.Labels = utils.ResourceLabel
+	f.localServiceLister = append(f.localServiceLister, localService)
+	f.localObjects = append(f.localObjects, localService)
+
+	// The local service is left alone
+	f.runClusterIP(getKey(localService, t))
+	f.expectEvents("Warning NamespaceCollision")
+}
+
+func TestNamespaceMappingSourceAnnotations(t *testing.T) {
+	mappings := []utils.NamespaceMapping{{Remote: "*-prod", Local: "*"}}
+	if err := utils.NamespaceMappings.Replace(mappings); err != nil {
+		t.Fatal(err)
+	}
+	defer utils.NamespaceMappings.Replace(nil)
+	f := newScFixture(t)
+	f.cfg.NamespaceMappings = mappings
+
+	var remoteService *v1.Service
+	for _, ns := range []string{serviceNamespace, serviceNamespace + "-prod"} {
+		remoteService = scNewService()
+		remoteService.Namespace = ns
+		f.remoteServiceLister = append(f.remoteServiceLister, remoteService)
+		f.remoteObjects = append(f.remoteObjects, remoteService)
+	}
+
+	// The local service records its remote service, it is looked up directly instead of mapping all services
+	localService := scNewService()
+	localService.Labels = utils.ResourceLabel
+	localService.Annotations = utils.SourceAnnotations("remote", remoteService)
+	localService.Spec.Type = v1.ServiceTypeClusterIP
+	localService.Spec.Ports = []v1.ServicePort{
+		{
+			Name:       portName,
+			Port:       portNum,
+			TargetPort: intstr.FromInt(portNodePort),
+		},
+	}
+	f.localServiceLister = append(f.localServiceLister, localService)
+	f.localObjects = append(f.localObjects, localService)
+
+	// Up to date, no actions (and no collision) expected
+	f.runClusterIP(getKey(localService, t))
+	f.expectEvents()
+}
+
+func TestCreatesServiceWithNameTemplate(t *testing.T) {
+	if err := utils.ServiceNames.Replace("{{.Name}}-{{.Cluster}}"); err != nil {
+		t.Fatal(err)
+	}
+	defer utils.ServiceNames.Replace("")
+	f := newScFixture(t)
+	f.cfg.ServiceNameTemplate = "{{.Name}}-{{.Cluster}}"
+
+	remoteService := scNewService()
+	f.remoteServiceLister = append(f.remoteServiceLister, remoteService)
+	f.remoteObjects = append(f.remoteObjects, remoteService)
+
+	// Manually created service of the same name is left alone
+	f.localObjects = append(f.localObjects, scNewService())
+
+	f.expectCreateNamespaceAction(scNewNamespace())
+
+	localService := scNewService()
+	localService.Name = serviceName + "-remote"
+	localService.Labels = utils.ResourceLabel
+	localService.Annotations = utils.SourceAnnotations("remote", remoteService)
+	localService.Spec.Type = v1.ServiceTypeClusterIP
+	localService.Spec.Ports = []v1.ServicePort{
+		{
+			Name:       portName,
+			Port:       portNum,
+			TargetPort: intstr.FromInt(portNodePort),
+		},
+	}
+	f.expectCreateServiceAction(localService)
+
+	f.runClusterIP(getKey(localService, t))
+	f.expectEvents("Normal Created")
+}
+
+func TestDeletesServiceOfPreviousName(t *testing.T) {
+	if err := utils.ServiceNames.Replace("{{.Name}}-remote"); err != nil {
+		t.Fatal(err)
+	}
+	defer utils.ServiceNames.Replace("")
+	f := newScFixture(t)
+	f.cfg.ServiceNameTemplate = "{{.Name}}-remote"
+
+	remoteService := scNewService()
+	f.remoteServiceLister = append(f.remoteServiceLister, remoteService)
+	f.remoteObjects = append(f.remoteObjects, remoteService)
+
+	// Created before the template was configured, no remote service is mirrored to it anymore
+	localService := scNewService()
+	localService.Labels = utils.ResourceLabel
+	f.localObjects = append(f.localObjects, localService)
+
+	f.expectDeleteServiceAction(localService)
+	f.runClusterIP(getKey(localService, t))
+	f.expectEvents("Normal Deleted")
+}
+
+func TestOptIn(t *testing.T) {
+	if err := utils.Exports.Replace(true, "export=true"); err != nil {
+		t.Fatal(err)
+	}
+	defer utils.Exports.Replace(false, "")
+
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		annotations map[string]string
+		wantCreate  bool
+	}{
+		{"NotExported", nil, nil, false},
+		{"ExportAnnotation", nil, utils.ExportAnnotation, true},
+		{"ExportSelector", map[string]string{"export": "true"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newScFixture(t)
+			remoteService := scNewService()
+			remoteService.Labels = tt.labels
+			remoteService.Annotations = tt.annotations
+			f.remoteServiceLister = append(f.remoteServiceLister, remoteService)
+			f.remoteObjects = append(f.remoteObjects, remoteService)
+
+			if tt.wantCreate {
+				f.expectCreateNamespaceAction(scNewNamespace())
+				localService := scNewService()
+				localService.Labels = utils.ResourceLabel
+				localService.Annotations = utils.SourceAnnotations("remote", remoteService)
+				localService.Spec.Type = v1.ServiceTypeClusterIP
+				localService.Spec.Ports[0].TargetPort = intstr.FromInt(portNodePort)
+				localService.Spec.Ports[0].NodePort = 0
+				f.expectCreateServiceAction(localService)
+			}
+			f.runClusterIP(getKey(remoteService, t))
+		})
+	}
+}
+
+func TestOptInDeletesUnexportedService(t *testing.T) {
+	if err := utils.Exports.Replace(true, ""); err != nil {
+		t.Fatal(err)
+	}
+	defer utils.Exports.Replace(false, "")
+
+	f := newScFixture(t)
+	remoteService := scNewService()
+	f.remoteServiceLister = append(f.remoteServiceLister, remoteService)
+	f.remoteObjects = append(f.remoteObjects, remoteService)
+
+	// Service has been mirrored before opt-in mode was enabled
+	localService := scNewService()
+	localService.Labels = utils.ResourceLabel
+	f.localObjects = append(f.localObjects, localService)
+
+	f.expectDeleteServiceAction(localService)
+	f.runClusterIP(getKey(remoteService, t))
+	f.expectEvents("Normal Deleted")
+}
+
+func TestIncludedNamespaces(t *testing.T) {
+	if err := utils.IncludedNamespaces.Replace([]string{"shop-*"}); err != nil {
+		t.Fatal(err)
+	}
+	defer utils.IncludedNamespaces.Replace(nil)
+
+	// serviceNamespace is not included, the dummy of the service is removed
+	f := newScFixture(t)
+	remoteService := scNewService()
+	f.remoteServiceLister = append(f.remoteServiceLister, remoteService)
+	f.remoteObjects = append(f.remoteObjects, remoteService)
+	localService := scNewService()
+	localService.Labels = utils.ResourceLabel
+	f.localObjects = append(f.localObjects, localService)
+
+	f.expectDeleteServiceAction(localService)
+	f.runClusterIP(getKey(remoteService, t))
+	f.expectEvents("Normal Deleted")
+}
+
+func TestNamespaceSelector(t *testing.T) {
+	tests := []struct {
+		name string
+		// namespaceLabels of the remote namespace, it doesn't exist if nil
+		namespaceLabels map[string]string
+		localExists     bool
+		wantAction      ActionType
+	}{
+		{"Selected", map[string]string{"mirror": "true"}, false, ActionTypeAdd},
+		{"NotSelected", map[string]string{"mirror": "false"}, false, ActionTypeNone},
+		{"NotSelectedAnymore", map[string]string{"mirror": "false"}, true, ActionTypeDelete},
+		{"NamespaceNotFound", nil, true, ActionTypeDelete},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newScFixture(t)
+			f.namespaceSelector = labels.SelectorFromSet(labels.Set{"mirror": "true"})
+			if tt.namespaceLabels != nil {
+				ns := &v1.Namespace{ObjectMeta: metaV1.ObjectMeta{Name: serviceNamespace, Labels: tt.namespaceLabels}}
+				f.remoteNamespaceLister = append(f.remoteNamespaceLister, ns)
+			}
+			remoteService := scNewService()
+			f.remoteServiceLister = append(f.remoteServiceLister, remoteService)
+			localService := scNewService()
+			localService.Labels = utils.ResourceLabel
+			if tt.localExists {
+				f.localObjects = append(f.localObjects, localService)
+			}
+
+			c, _, _ := f.newController(false)
+			action, err := c.syncHandler(getKey(remoteService, t))
+			if err != nil {
+				t.Fatalf("Error syncing service: %v", err)
+			}
+			if action != tt.wantAction {
+				t.Errorf("Expected action %q, got %q", tt.wantAction, action)
+			}
+		})
+	}
+}
+
+func TestNamespaceLabelChangeEnqueuesServices(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	ns := &v1.Namespace{ObjectMeta: metaV1.ObjectMeta{Name: serviceNamespace}}
+	remoteService := scNewService()
+	remoteClient := k8sfake.NewSimpleClientset(ns, remoteService)
+	localClient := k8sfake.NewSimpleClientset()
+
+	remote := NewRemoteCluster("remote", remoteClient, noResyncPeriodFunc())
+	remote.NamespaceSelector = labels.SelectorFromSet(labels.Set{"mirror": "true"})
+	localServiceInformer := kubeinformers.NewSharedInformerFactory(localClient, noResyncPeriodFunc())
+	c := NewServiceController(localClient, []*RemoteCluster{remote}, localServiceInformer.Core().V1().Services(), config.Default())
+	remote.InformerFactory.Start(stopCh)
+	localServiceInformer.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, append(c.remoteSynced, c.localSynced)...) {
+		t.Fatal("Failed to wait for caches to sync")
+	}
+	if c.queue.Len() != 0 {
+		t.Fatalf("Expected service of not selected namespace not to be enqueued, got %d keys", c.queue.Len())
+	}
+
+	ns.Labels = map[string]string{"mirror": "true"}
+	if _, err := remoteClient.CoreV1().Namespaces().Update(ns); err != nil {
+		t.Fatal(err)
+	}
+	err := wait.PollImmediate(10*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		return c.queue.Len() > 0, nil
+	})
+	if err != nil {
+		t.Fatal("Expected service to be enqueued after namespace has been selected")
+	}
+	if key, _ := c.queue.Get(); key != getKey(remoteService, t) {
+		t.Errorf("Expected %s to be enqueued, got %v", getKey(remoteService, t), key)
+	}
+}
+
+func TestSkipNotOwnedService(t *testing.T) {
+	f := newScFixture(t)
+
+	remoteService := scNewService()
+	f.remoteServiceLister = append(f.remoteServiceLister, remoteService)
+	f.remoteObjects = append(f.remoteObjects, remoteService)
+
+	// Manually created service (not managed by barrelman)
+	localService := scNewService()
+	f.localObjects = append(f.localObjects, localService)
+
+	f.runClusterIP(getKey(remoteService, t))
+	f.expectEvents("Normal NotOwned")
+}
+
+func TestDryRunCreatesService(t *testing.T) {
+	f := newScFixture(t)
+	f.plan = NewPlan()
+
+	remoteService := scNewService()
+	f.remoteServiceLister = append(f.remoteServiceLister, remoteService)
+	f.remoteObjects = append(f.remoteObjects, remoteService)
+
+	// No actions expected in local cluster
+	key := getKey(remoteService, t)
+	f.runClusterIP(key)
+
+	planned := f.plan.List()
+	if len(planned) != 2 {
+		t.Fatalf("Expected 2 planned actions, got %d: %s", len(planned), spew.Sdump(planned))
+	}
+	for i, want := range []struct{ kind, name string }{{"Namespace", serviceNamespace}, {"Service", key}} {
+		if planned[i].Action != ActionTypeAdd || planned[i].Kind != want.kind || planned[i].Name != want.name {
+			t.Errorf("Expected Add of %s %s, got %s of %s %s",
+				want.kind, want.name, planned[i].Action, planned[i].Kind, planned[i].Name)
+		}
+	}
+}
+
+func TestDryRunDeleteService(t *testing.T) {
+	f := newScFixture(t)
+	f.plan = NewPlan()
+
+	remoteService := scNewService()
+	remoteService.Annotations = utils.IgnoreAnnotation
+	f.remoteServiceLister = append(f.remoteServiceLister, remoteService)
+	f.remoteObjects = append(f.remoteObjects, remoteService)
+
+	localService := scNewService()
+	localService.Labels = utils.ResourceLabel
+	f.localObjects = append(f.localObjects, localService)
+
+	// No actions expected in local cluster
+	f.runClusterIP(getKey(remoteService, t))
+
+	planned := f.plan.List()
+	if len(planned) != 1 || planned[0].Action != ActionTypeDelete || planned[0].Desired != nil {
+		t.Errorf("Expected planned delete action, got: %s", spew.Sdump(planned))
+	}
+}
+
+func TestDryRunNamespaceCollision(t *testing.T) {
+	mappings := []utils.NamespaceMapping{{Remote: "*-prod", Local: "*"}}
+	if err := utils.NamespaceMappings.Replace(mappings); err != nil {
+		t.Fatal(err)
+	}
+	defer utils.NamespaceMappings.Replace(nil)
+	f := newScFixture(t)
+	f.cfg.NamespaceMappings = mappings
+	f.plan = NewPlan()
+
+	for _, ns := range []string{serviceNamespace, serviceNamespace + "-prod"} {
+		remoteService := scNewService()
+		remoteService.Namespace = ns
+		f.remoteServiceLister = append(f.remoteServiceLister, remoteService)
+		f.remoteObjects = append(f.remoteObjects, remoteService)
+	}
+
+	// Planned before the second remote service showed up
+	key := getKey(scNewService(), t)
+	f.plan.Set("ServiceController", key, []PlannedAction{{Action: ActionTypeAdd, Kind: "Service", Name: key}})
+
+	f.runClusterIP(key)
+
+	if planned := f.plan.List(); len(planned) != 0 {
+		t.Errorf("Expected no planned actions, got: %s", spew.Sdump(planned))
+	}
+}
+
+func TestGetLocalAction(t *testing.T) {
+	type testPair struct {
+		remoteExists, localExists bool
+		remote, local             *v1.Service
+		output                    ActionType
+	}
+	var tests = []testPair{
+		{
+			remoteExists: false,
+			remote:       &v1.Service{},
+			localExists:  false,
+			local:        &v1.Service{},
+			output:       ActionTypeNone,
+		},
+		{
+			remoteExists: true,
+			remote: &v1.Service{
+				ObjectMeta: metaV1.ObjectMeta{},
+				Spec: v1.ServiceSpec{
+					Type: v1.ServiceTypeNodePort,
+				},
+			},
+			localExists: false,
+			local:       &v1.Service{},
+			output:      ActionTypeAdd,
+		},
+		{
+			remoteExists: true,
+			remote: &v1.Service{
+				ObjectMeta: metaV1.ObjectMeta{},
+				Spec: v1.ServiceSpec{
+					Type: v1.ServiceTypeNodePort,
+				},
+			},
+			localExists: true,
+			local: &v1.Service{
+				ObjectMeta: metaV1.ObjectMeta{},
+				Spec: v1.ServiceSpec{
+					Type: v1.ServiceTypeNodePort,
+				},
+			},
+			output: ActionTypeNone,
+		},
+		{
+			remoteExists: true,
+			remote: &v1.Service{
+				ObjectMeta: metaV1.ObjectMeta{},
+				Spec: v1.ServiceSpec{
+					Type: v1.ServiceTypeNodePort,
+				},
+			},
+			localExists: true,
+			local: &v1.Service{
+				ObjectMeta: metaV1.ObjectMeta{
 					Labels: utils.ResourceLabel,
 				},
 				Spec: v1.ServiceSpec{
@@ -453,9 +1330,164 @@ func TestGetLocalAction(t *testing.T) {
 	}
 
 	for n, test := range tests {
-		output := getLocalAction(test.remoteExists, test.remote, test.localExists, test.local)
+		output, _ := getLocalAction(test.remoteExists, test.remote, test.localExists, test.local)
 		if output != test.output {
 			t.Errorf("Expected '%s' got '%s' for testset #%d", test.output, output, n)
 		}
 	}
 }
+
+func Test_changedNamespaces(t *testing.T) {
+	base := config.Default()
+	base.IgnoredNamespaces = []string{"kube-system", "foo"}
+	base.NamespaceServiceTypes = map[string]v1.ServiceType{"bar": v1.ServiceTypeNodePort}
+
+	tests := []struct {
+		name   string
+		modify func(cfg *config.Config)
+		want   []string
+	}{
+		{"Unchanged", func(cfg *config.Config) {}, []string{}},
+		{
+			"IgnoredNamespaces",
+			func(cfg *config.Config) { cfg.IgnoredNamespaces = []string{"kube-system", "baz"} },
+			[]string{"baz", "foo"},
+		},
+		{
+			"NamespaceServiceTypeRemoved",
+			func(cfg *config.Config) { cfg.NamespaceServiceTypes = nil },
+			[]string{"bar"},
+		},
+		{
+			"NamespaceServiceTypeAdded",
+			func(cfg *config.Config) { cfg.NamespaceServiceTypes["baz"] = v1.ServiceTypeNodePort },
+			[]string{"baz"},
+		},
+		{
+			"NamespaceServiceTypeSameAsDefault",
+			func(cfg *config.Config) { cfg.NamespaceServiceTypes["baz"] = v1.ServiceTypeClusterIP },
+			[]string{},
+		},
+		{
+			"DefaultServiceType",
+			func(cfg *config.Config) { cfg.ServiceType = v1.ServiceTypeNodePort },
+			[]string{""},
+		},
+		{
+			"MirrorLoadBalancers",
+			func(cfg *config.Config) { cfg.MirrorLoadBalancers = true },
+			[]string{""},
+		},
+		{
+			"ServiceNameTemplate",
+			func(cfg *config.Config) { cfg.ServiceNameTemplate = "{{.Name}}-remote" },
+			[]string{""},
+		},
+		{
+			"IgnoredNamespacePattern",
+			func(cfg *config.Config) { cfg.IgnoredNamespaces = append(cfg.IgnoredNamespaces, "ci-*") },
+			[]string{"", "ci-*"},
+		},
+		{
+			"IncludedNamespaces",
+			func(cfg *config.Config) { cfg.IncludedNamespaces = []string{"shop"} },
+			[]string{""},
+		},
+		{
+			"ExportSelector",
+			func(cfg *config.Config) { cfg.ExportSelector = "export=true" },
+			[]string{""},
+		},
+		{
+			"PortSelections",
+			func(cfg *config.Config) { cfg.PortSelections = map[string]string{"shop/api": "-admin"} },
+			[]string{""},
+		},
+		{
+			"PropagatedLabels",
+			func(cfg *config.Config) { cfg.PropagatedLabels = []string{"team"} },
+			[]string{""},
+		},
+		{
+			"NamespaceMappings",
+			func(cfg *config.Config) {
+				cfg.NamespaceMappings = []utils.NamespaceMapping{{Remote: "foo-prod", Local: "foo"}}
+			},
+			[]string{""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := base.DeepCopy()
+			tt.modify(cfg)
+			if got := changedNamespaces(base, cfg).List(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("changedNamespaces() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// symmetricController returns a started ServiceController mirroring services of remoteClient (named remoteName)
+// into localClient, as one of two barrelman instances in a symmetric setup
+func symmetricController(t *testing.T, localClient, remoteClient *k8sfake.Clientset, remoteName string,
+	stopCh <-chan struct{}) *ServiceController {
+	cfg := config.Default()
+	cfg.ServiceType = v1.ServiceTypeNodePort
+	remote := NewRemoteCluster(remoteName, remoteClient, noResyncPeriodFunc())
+	localServiceInformer := kubeinformers.NewSharedInformerFactory(localClient, noResyncPeriodFunc())
+	c := NewServiceController(localClient, []*RemoteCluster{remote}, localServiceInformer.Core().V1().Services(), cfg)
+	c.recorder = record.NewFakeRecorder(100)
+
+	remote.InformerFactory.Start(stopCh)
+	localServiceInformer.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, append(c.remoteSynced, c.localSynced)...) {
+		t.Fatal("Failed to wait for caches to sync")
+	}
+	return c
+}
+
+// TestSymmetricClusters runs barrelman in both directions between cluster X and Y. NodePort dummy services
+// must not be mirrored back into the cluster of their remote service.
+func TestSymmetricClusters(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	remoteService := scNewService()
+	key := getKey(remoteService, t)
+	clusterX := k8sfake.NewSimpleClientset(remoteService)
+	clusterY := k8sfake.NewSimpleClientset()
+
+	// Yb mirrors the service of X
+	yb := symmetricController(t, clusterY, clusterX, "x", stopCh)
+	if _, err := yb.syncHandler(key); err != nil {
+		t.Fatalf("Error syncing %s in Y: %v", key, err)
+	}
+	dummy, err := clusterY.CoreV1().Services(serviceNamespace).Get(serviceName, metaV1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected service %s in Y: %v", key, err)
+	}
+	if !utils.OwnerOfService(dummy) || dummy.Spec.Type != v1.ServiceTypeNodePort {
+		t.Errorf("Expected NodePort dummy service in Y, got %s", spew.Sdump(dummy))
+	}
+
+	// Service is deleted in X while the dummy in Y is still there, Xb must not mirror it back
+	if err := clusterX.CoreV1().Services(serviceNamespace).Delete(serviceName, &metaV1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	clusterX.ClearActions()
+	xb := symmetricController(t, clusterX, clusterY, "y", stopCh)
+	if action, err := xb.syncHandler(key); err != nil || action != ActionTypeNone {
+		t.Errorf("Expected no action in X, got %q (error: %v)", action, err)
+	}
+	for _, action := range clusterX.Actions() {
+		if action.GetVerb() == "create" {
+			t.Errorf("Unexpected action in X: %s", spew.Sdump(action))
+		}
+	}
+
+	// Yb removes the dummy
+	yb = symmetricController(t, clusterY, clusterX, "x", stopCh)
+	if action, err := yb.syncHandler(key); err != nil || action != ActionTypeDelete {
+		t.Errorf("Expected dummy to be deleted in Y, got %q (error: %v)", action, err)
+	}
+}
@@ -0,0 +1,151 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"barrelman/config"
+	"barrelman/metrics"
+	"barrelman/utils"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+// OrphanSweeper periodically looks for objects barrelman would not clean up on its own because the events
+// leading to their removal have been missed (e.g. while barrelman was down):
+//   - dummy services without a remote service are enqueued in ServiceController (which deletes them)
+//   - endpoints labeled with utils.ServiceLabel whose service is gone are deleted
+//
+// A single sweep handles at most maxDeletions orphans, the remaining ones are left for the next sweep.
+type OrphanSweeper struct {
+	localClient kubernetes.Interface
+	sc          *ServiceController
+
+	interval     time.Duration
+	maxDeletions int
+
+	// plan collects the endpoints that would be deleted in dry-run mode
+	plan *Plan
+}
+
+// NewOrphanSweeper returns a OrphanSweeper for the services managed by sc
+func NewOrphanSweeper(localClient kubernetes.Interface, sc *ServiceController, cfg config.OrphanSweepConfig) *OrphanSweeper {
+	return &OrphanSweeper{
+		localClient:  localClient,
+		sc:           sc,
+		interval:     cfg.Interval.Duration,
+		maxDeletions: cfg.MaxDeletions,
+	}
+}
+
+// SetDryRun enables dry-run mode, endpoints that would be deleted are logged and added to plan instead
+// (orphaned services are planned by ServiceController)
+func (s *OrphanSweeper) SetDryRun(plan *Plan) {
+	s.plan = plan
+}
+
+// Run sweeps every interval (once the caches of ServiceController are synced) until stopCh is closed
+func (s *OrphanSweeper) Run(stopCh <-chan struct{}) {
+	klog.Infof("Starting OrphanSweeper (interval %s, max. %d deletions per sweep)", s.interval, s.maxDeletions)
+	if !cache.WaitForCacheSync(stopCh, append(s.sc.remoteSynced, s.sc.localSynced)...) {
+		runtime.HandleError(fmt.Errorf("failed to wait for caches to sync"))
+		return
+	}
+	wait.Until(s.sweep, s.interval, stopCh)
+	klog.Info("Shutting down OrphanSweeper")
+}
+
+// sweep handles orphaned services and endpoints once
+func (s *OrphanSweeper) sweep() {
+	// Without remote clusters, every service would be an orphan
+	if len(s.sc.getRemotes()) == 0 {
+		klog.V(3).Info("No remote clusters, skipping orphan sweep")
+		return
+	}
+
+	remaining := s.maxDeletions
+	services, err := s.orphanedServices()
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	for _, key := range services {
+		if remaining == 0 {
+			klog.Warningf("Reached limit of %d deletions, %d orphaned services left for the next sweep",
+				s.maxDeletions, len(services)-s.maxDeletions)
+			return
+		}
+		klog.Infof("Service %s has no remote service, enqueuing it for removal", key)
+		s.sc.queue.Add(key)
+		metrics.OrphansSwept.WithLabelValues("Service").Inc()
+		remaining--
+	}
+
+	endpoints, err := s.localClient.CoreV1().Endpoints("").List(metaV1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(utils.ServiceLabel).String(),
+	})
+	if err != nil {
+		runtime.HandleError(fmt.Errorf("error listing endpoints: %v", err))
+		return
+	}
+	for i := range endpoints.Items {
+		endpoint := &endpoints.Items[i]
+		key := endpoint.Namespace + "/" + endpoint.Name
+		_, err := s.sc.localServiceLister.Services(endpoint.Namespace).Get(endpoint.Name)
+		if errors.IsNotFound(err) {
+			// Endpoints are listed live, the lister may not know a just created service yet
+			_, err = s.localClient.CoreV1().Services(endpoint.Namespace).Get(endpoint.Name, metaV1.GetOptions{})
+		}
+		if err == nil || !errors.IsNotFound(err) {
+			if s.plan != nil {
+				s.plan.Set("OrphanSweeper", key, nil)
+			}
+			continue
+		}
+		if remaining == 0 {
+			klog.Warningf("Reached limit of %d deletions, orphaned endpoints left for the next sweep", s.maxDeletions)
+			return
+		}
+		remaining--
+
+		if s.plan != nil {
+			s.plan.Set("OrphanSweeper", key, []PlannedAction{newPlannedAction(ActionTypeDelete, "Endpoints", key, endpoint, nil)})
+			continue
+		}
+		klog.Infof("performing \"%s\" action for endpoints %s (service is gone)", ActionTypeDelete, key)
+		err = s.localClient.CoreV1().Endpoints(endpoint.Namespace).Delete(endpoint.Name, &metaV1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			runtime.HandleError(fmt.Errorf("error deleting endpoints %s: %v", key, err))
+			continue
+		}
+		metrics.OrphansSwept.WithLabelValues("Endpoints").Inc()
+	}
+}
+
+// orphanedServices returns the keys of all dummy services barrelman is not responsible for anymore
+func (s *OrphanSweeper) orphanedServices() ([]string, error) {
+	services, err := s.sc.localServiceLister.List(labels.SelectorFromSet(utils.ResourceLabel))
+	if err != nil {
+		return nil, fmt.Errorf("error listing local services: %v", err)
+	}
+	var orphans []string
+	for _, service := range services {
+		remoteSvc, _, exists, err := s.sc.getRemoteService(service.GetNamespace(), service.GetName())
+		if err != nil {
+			// Collisions are reported by ServiceController, other errors are retried in the next sweep
+			klog.V(3).Infof("Skipping service %s/%s in orphan sweep: %v", service.GetNamespace(), service.GetName(), err)
+			continue
+		}
+		if !exists || !utils.ResponsibleForRemoteService(remoteSvc) {
+			orphans = append(orphans, service.GetNamespace()+"/"+service.GetName())
+		}
+	}
+	return orphans, nil
+}
@@ -0,0 +1,137 @@
+package controller
+
+import (
+	"fmt"
+	"testing"
+
+	"barrelman/config"
+	"barrelman/utils"
+
+	v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	core "k8s.io/client-go/testing"
+)
+
+func sweeperNewEndpoints(name string) *v1.Endpoints {
+	return &v1.Endpoints{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      name,
+			Namespace: serviceNamespace,
+			Labels:    utils.ServiceLabel,
+		},
+	}
+}
+
+// sweeperNewDummy returns a dummy service (created by barrelman) named name
+func sweeperNewDummy(name string) *v1.Service {
+	service := scNewService()
+	service.Name = name
+	service.Labels = utils.ResourceLabel
+	return service
+}
+
+// runSweeper sweeps once and returns the keys enqueued in ServiceController
+func (f *scFixture) runSweeper(maxDeletions int) []string {
+	c, rSI, lSI := f.newController(false)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	rSI.Start(stopCh)
+	lSI.Start(stopCh)
+
+	cfg := config.Default().OrphanSweep
+	cfg.MaxDeletions = maxDeletions
+	s := NewOrphanSweeper(f.localClient, c, cfg)
+	if f.plan != nil {
+		s.SetDryRun(f.plan)
+	}
+	s.sweep()
+
+	var keys []string
+	for c.queue.Len() > 0 {
+		key, _ := c.queue.Get()
+		keys = append(keys, key.(string))
+		c.queue.Done(key)
+	}
+	f.checkActions()
+	return keys
+}
+
+func (f *scFixture) expectDeleteEndpointsAction(e *v1.Endpoints) {
+	f.localExpectedActions = append(
+		f.localExpectedActions,
+		core.NewDeleteAction(schema.GroupVersionResource{Resource: "endpoints"}, e.Namespace, e.Name),
+	)
+}
+
+func newSweeperFixture(t *testing.T) *scFixture {
+	f := newScFixture(t)
+	// Endpoints are listed and their services checked by the sweeper
+	f.informerFilter = append(f.informerFilter, filterAction{"list", "endpoints"}, filterAction{"get", "services"})
+	return f
+}
+
+func TestSweeperOrphans(t *testing.T) {
+	f := newSweeperFixture(t)
+
+	// Mirrored service is kept
+	remoteService := scNewService()
+	f.remoteServiceLister = append(f.remoteServiceLister, remoteService)
+	f.localServiceLister = append(f.localServiceLister, sweeperNewDummy(serviceName))
+	f.localObjects = append(f.localObjects, sweeperNewEndpoints(serviceName))
+
+	// Remote service has been deleted while barrelman was down
+	f.localServiceLister = append(f.localServiceLister, sweeperNewDummy("orphan"))
+
+	// Endpoints of a deleted service
+	stale := sweeperNewEndpoints("stale")
+	f.localObjects = append(f.localObjects, stale)
+	f.expectDeleteEndpointsAction(stale)
+
+	keys := f.runSweeper(10)
+	if len(keys) != 1 || keys[0] != serviceNamespace+"/orphan" {
+		t.Errorf("Expected orphan to be enqueued, got %v", keys)
+	}
+}
+
+func TestSweeperLaggingLister(t *testing.T) {
+	f := newSweeperFixture(t)
+	f.remoteServiceLister = append(f.remoteServiceLister, scNewService())
+
+	// Service has just been created, its endpoints are listed but the lister does not know it yet
+	service := scNewService()
+	service.Name = "new"
+	f.localObjects = append(f.localObjects, service, sweeperNewEndpoints("new"))
+
+	// No actions expected in local cluster
+	f.runSweeper(10)
+}
+
+func TestSweeperMaxDeletions(t *testing.T) {
+	f := newSweeperFixture(t)
+	f.remoteServiceLister = append(f.remoteServiceLister, scNewService())
+	for i := 0; i < 3; i++ {
+		f.localServiceLister = append(f.localServiceLister, sweeperNewDummy(fmt.Sprintf("orphan-%d", i)))
+	}
+	// Not deleted, limit is reached by the services already
+	f.localObjects = append(f.localObjects, sweeperNewEndpoints("stale"))
+
+	if keys := f.runSweeper(2); len(keys) != 2 {
+		t.Errorf("Expected 2 orphans to be enqueued, got %v", keys)
+	}
+}
+
+func TestSweeperDryRun(t *testing.T) {
+	f := newSweeperFixture(t)
+	f.plan = NewPlan()
+	f.remoteServiceLister = append(f.remoteServiceLister, scNewService())
+	f.localObjects = append(f.localObjects, sweeperNewEndpoints("stale"))
+
+	// No actions expected in local cluster
+	f.runSweeper(10)
+
+	planned := f.plan.List()
+	if len(planned) != 1 || planned[0].Action != ActionTypeDelete || planned[0].Kind != "Endpoints" {
+		t.Errorf("Expected planned delete of endpoints, got %v", planned)
+	}
+}
@@ -6,19 +6,26 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	"barrelman/config"
 	"barrelman/controller"
+	"barrelman/metrics"
 	"barrelman/utils"
 
+	v1 "k8s.io/api/core/v1"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
 	"k8s.io/klog"
 )
 
@@ -27,14 +34,58 @@ var (
 	addr              = flag.String("listen-address", ":9193", "the address to listen for HTTP requests")
 	localKubeConfig   = flag.String("local-kubeconfig", "", "absolute path to the kubeconfig file for the \"local\" cluster (where to maintain endpoints)")
 	localContext      = flag.String("local-context", "", "context to use as the \"local\" cluster (where to maintain endpoints)")
-	remoteProject     = flag.String("remote-project", "", "Remote clusters project id")
-	remoteZone        = flag.String("remote-zone", "europe-west1-c", "Remote clusters zone")
-	remoteClusterName = flag.String("remote-cluster-name", "", "Remote clusters name")
+	remoteName        = flag.String("remote-name", "remote", "name of the remote cluster (used in logs and metrics, a DNS-1123 label)")
+	remoteProvider    = flag.String("remote-provider", utils.ProviderGKE, "how to authenticate against the remote cluster (gke, kubeconfig or token)")
+	remoteProject     = flag.String("remote-project", "", "Remote clusters project id (provider gke)")
+	remoteZone        = flag.String("remote-zone", "europe-west1-c", "Remote clusters zone (provider gke)")
+	remoteClusterName = flag.String("remote-cluster-name", "", "Remote clusters name (provider gke)")
+	remoteKubeConfig  = flag.String("remote-kubeconfig", "", "absolute path to the kubeconfig file for the remote cluster (provider kubeconfig)")
+	remoteContext     = flag.String("remote-context", "", "context to use for the remote cluster (provider kubeconfig)")
+	remoteHost        = flag.String("remote-host", "", "URL of the remote clusters API server (provider token)")
+	remoteTokenFile   = flag.String("remote-token-file", "", "path to a file containing a bearer token for the remote cluster (provider token)")
+	remoteCAFile      = flag.String("remote-ca-file", "", "path to the CA certificate of the remote clusters API server (provider token)")
 	resyncPeriod      = flag.Duration("resync-period", 2*time.Hour, "how often should all nodes be considered \"old\" (and processed again)")
 	necWorkers        = flag.Uint("nec-workers", 4, "number of workers for NodeEndpointController")
 	scWorkers         = flag.Uint("sc-workers", 2, "number of workers for ServiceController")
 	createNodePortSvc = flag.Bool("nodeportsvc", false, "create services of type NodePort in \"local\" cluster (instead of ClusterIP)")
-	// See init() for "ignore-namespace"
+	endpointMode      = flag.String("endpoint-mode", string(config.EndpointModeEndpoints), "which objects to maintain for services in \"local\" cluster (endpoints, endpointslices or both)")
+	maxSliceSize      = flag.Int("endpointslice-max-size", 100, "maximum number of endpoints per EndpointSlice")
+	leaderElect       = flag.Bool("leader-elect", false, "use leader election (in \"local\" cluster), only the leader runs the controllers")
+	leaderLockType    = flag.String("leader-elect-lock-type", resourcelock.LeasesResourceLock, "resource type to use for the leader election lock (leases, configmaps or endpoints)")
+	leaderLockName    = flag.String("leader-elect-lock-name", "barrelman", "name of the leader election lock")
+	leaderLockNS      = flag.String("leader-elect-lock-namespace", "kube-system", "namespace of the leader election lock")
+	leaseDuration     = flag.Duration("leader-elect-lease-duration", 15*time.Second, "duration non-leaders will wait before trying to acquire leadership")
+	renewDeadline     = flag.Duration("leader-elect-renew-deadline", 10*time.Second, "duration the leader retries refreshing leadership before giving up")
+	retryPeriod       = flag.Duration("leader-elect-retry-period", 2*time.Second, "duration between leader election actions")
+	configFile        = flag.String("config", "", "path to a config file (overrides flags), changes are applied without restart")
+	configInterval    = flag.Duration("config-reload-interval", 10*time.Second, "how often to check the config file for changes")
+	dryRun            = flag.Bool("dry-run", false, "do not modify the \"local\" cluster, log planned actions and expose them on /plan instead")
+	nodeSelector      = flag.String("node-selector", "", "label selector restricting the remote nodes used as endpoints (e.g. pool=ingress)")
+	nodeAddressTypes  = flag.String("node-address-types", "InternalIP", "comma separated list of node address types to use as endpoint address, first found wins (InternalIP, ExternalIP, Hostname or annotation:<key>)")
+	ipFamilies        = flag.String("ip-families", "IPv4", "comma separated list of IP families (IPv4, IPv6) of endpoints for services without cluster IP")
+	probe             = flag.Bool("probe", false, "actively probe remote node ports, addresses failing probes are marked as not ready")
+	namespaceGC       = flag.Bool("namespace-gc", false, "delete namespaces created by barrelman once they are unused and gone in all remote clusters")
+	namespaceGCInt    = flag.Duration("namespace-gc-interval", 10*time.Minute, "how often to check namespaces created by barrelman")
+	namespaceGCGrace  = flag.Duration("namespace-gc-grace-period", time.Hour, "how long a namespace has to be unused before it's deleted")
+	orphanSweep       = flag.Bool("orphan-sweep", false, "periodically remove services without remote service and endpoints without service")
+	orphanSweepInt    = flag.Duration("orphan-sweep-interval", 10*time.Minute, "how often to look for orphaned services and endpoints")
+	orphanSweepMax    = flag.Int("orphan-sweep-max-deletions", 10, "maximum number of orphans removed by a single sweep")
+	mirrorLBs         = flag.Bool("mirror-loadbalancers", false, "mirror remote LoadBalancer services, endpoints point to their load balancer IPs")
+	podIPs            = flag.Bool("pod-ips", false, "mirror remote services via the pod IPs of their endpoints instead of node ports (requires a routable pod network)")
+	mirrorHeadless    = flag.Bool("mirror-headless", false, "mirror remote headless services via the pod IPs and hostnames of their endpoints (requires a routable pod network)")
+	externalName      = flag.Bool("external-name", false, "create services of type ExternalName in \"local\" cluster, pointing to -external-name-template")
+	externalNameTmpl  = flag.String("external-name-template", "", "hostname template of ExternalName services (e.g. {{.Name}}.{{.Namespace}}.remote.example.com, {{.Cluster}} is the remote cluster)")
+	serviceNameTmpl   = flag.String("service-name-template", "", "name template of services in \"local\" cluster (e.g. {{.Name}}-remote or {{.Cluster}}-{{.Name}}), remote names are kept by default")
+	optIn             = flag.Bool("opt-in", false, "only mirror remote services annotated with \"tfw.io/barrelman: export\" or matching -export-selector (instead of all but ignored ones)")
+	exportSelector    = flag.String("export-selector", "", "label selector of remote services to mirror in opt-in mode (e.g. barrelman/export=true)")
+	localClusterName  = flag.String("local-cluster-name", "", "name barrelman instances mirroring from the \"local\" cluster use for it, their services are never mirrored back")
+	namespaceSelector = flag.String("namespace-selector", "", "label selector restricting the mirrored remote namespaces (e.g. mirror!=false)")
+	propagateLabels   = flag.String("propagate-labels", "", "comma separated list of label keys or prefixes (e.g. team,app.kubernetes.io/*) copied from remote to \"local\" services")
+	propagateAnnots   = flag.String("propagate-annotations", "", "comma separated list of annotation keys or prefixes copied from remote to \"local\" services")
+	// See init() for "ignore-namespace", "include-namespace", "namespace-mapping" and "remote"
+
+	remoteClusters    utils.RemoteClusterList
+	namespaceMappings utils.NamespaceMappingList
 )
 
 func init() {
@@ -45,19 +96,37 @@ The remote cluster will be watched for node changes.
 On change, service endpoints in local cluster will be modify to always contain a up to date list of node ips.
 
 Local cluster may be defined via 'local-kubeconfig' and 'local-context'.
-Remote cluster must be defined via 'remote-project', 'remote-zone' and 'remote-cluster-name'.
-The the needed config will be auto generated via a Google service account (GOOGLE_APPLICATION_CREDENTIALS).
+Remote cluster authentication is selected via 'remote-provider':
+  gke:        'remote-project', 'remote-zone' and 'remote-cluster-name'. The the needed config will be
+              auto generated via a Google service account (GOOGLE_APPLICATION_CREDENTIALS).
+  kubeconfig: 'remote-kubeconfig' and (optional) 'remote-context'.
+  token:      'remote-host', 'remote-token-file' and (optional) 'remote-ca-file'.
+
+Multiple remote clusters may be defined via 'remote' (which may be given multiple times), the 'remote-*'
+flags are ignored then. The value is a comma separated list of key=value pairs, keys are the 'remote-*'
+flags without the prefix, e.g.:
+  -remote name=eu,provider=kubeconfig,kubeconfig=/kube/eu
+  -remote name=us,provider=gke,project=p,zone=us-east1-b,cluster-name=us
+
+Alternatively all of this may be defined in a config file given via 'config'. Values from the config file
+override the ones from flags. The file is watched for changes, which are applied without a restart.
 `)
 		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "\nUsage of %s:\n", os.Args[0])
 		flag.PrintDefaults()
 	}
 
 	flag.Var(utils.IgnoredNamespaces, "ignore-namespace",
-		"namespace to ignore services in, may be given multiple times. Prefix namespace with a dash to remove it from default")
+		"namespace to ignore services in (or a pattern like ci-* or ~regex), may be given multiple times. Prefix namespace with a dash to remove it from default")
+	flag.Var(utils.IncludedNamespaces, "include-namespace",
+		"only mirror services of this namespace (or a pattern like shop-* or ~regex), may be given multiple times")
+	flag.Var(&namespaceMappings, "namespace-mapping",
+		"map remote namespaces to a local namespace (remote=local, *-prod=* or ~regex=$1), may be given multiple times. The first matching mapping is used")
+	flag.Var(&remoteClusters, "remote",
+		"remote cluster definition (name=foo,provider=kubeconfig,kubeconfig=/path), may be given multiple times")
 	klog.InitFlags(nil)
 }
 
-func getLocalClientset() *kubernetes.Clientset {
+func getLocalClientset() (*kubernetes.Clientset, dynamic.Interface) {
 	// creates the kubernetes config for the local cluster
 	// if kubeconfig is not given, master url is tried
 	// if both are omitted, inCluster config is tried
@@ -70,13 +139,8 @@ func getLocalClientset() *kubernetes.Clientset {
 			klog.Fatal(err)
 		}
 	} else {
-		config, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
-			&clientcmd.ClientConfigLoadingRules{
-				ExplicitPath: *localKubeConfig,
-			},
-			&clientcmd.ConfigOverrides{
-				CurrentContext: *localContext,
-			}).ClientConfig()
+		provider := &utils.KubeconfigProvider{Path: *localKubeConfig, Context: *localContext}
+		config, err = provider.Config()
 		if err != nil {
 			klog.Fatal(err)
 		}
@@ -87,30 +151,300 @@ func getLocalClientset() *kubernetes.Clientset {
 		klog.Fatal(err)
 	}
 
-	return clientset
+	// The dynamic client is used for EndpointSlices
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		klog.Fatal(err)
+	}
+
+	return clientset, dynamicClient
 }
 
-func getRemoteClientset() *kubernetes.Clientset {
-	if *remoteProject == "" || *remoteZone == "" || *remoteClusterName == "" {
-		klog.Fatalln("You have to specify -remote-project, -remote-zone and -remote-cluster-name")
+// getRemoteClusterConfigs returns the remote cluster definitions given via -remote
+// or a single one build from the -remote-* flags
+func getRemoteClusterConfigs() utils.RemoteClusterList {
+	if len(remoteClusters) > 0 {
+		return remoteClusters
 	}
 
-	clientset, err := utils.NewGKEClientset(*remoteProject, *remoteZone, *remoteClusterName)
+	return utils.RemoteClusterList{{
+		Name: *remoteName,
+		ProviderConfig: utils.ProviderConfig{
+			Provider:    *remoteProvider,
+			Kubeconfig:  *remoteKubeConfig,
+			Context:     *remoteContext,
+			Host:        *remoteHost,
+			TokenFile:   *remoteTokenFile,
+			CAFile:      *remoteCAFile,
+			Project:     *remoteProject,
+			Zone:        *remoteZone,
+			ClusterName: *remoteClusterName,
+		},
+	}}
+}
+
+// splitList splits a comma separated flag value, empty entries are dropped
+func splitList(value string) []string {
+	var list []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+// getBaseConfig returns the configuration defined by flags
+func getBaseConfig() *config.Config {
+	cfg := config.Default()
+	cfg.ResyncPeriod = metaV1.Duration{Duration: *resyncPeriod}
+	cfg.Workers = config.Workers{
+		NodeEndpointController: int(*necWorkers),
+		ServiceController:      int(*scWorkers),
+	}
+	cfg.RemoteClusters = getRemoteClusterConfigs()
+	cfg.IgnoredNamespaces = utils.IgnoredNamespaces.List()
+	cfg.IncludedNamespaces = utils.IncludedNamespaces.List()
+	cfg.NamespaceSelector = *namespaceSelector
+	cfg.NamespaceMappings = namespaceMappings
+	cfg.PropagatedLabels = splitList(*propagateLabels)
+	cfg.PropagatedAnnotations = splitList(*propagateAnnots)
+	if *createNodePortSvc {
+		cfg.ServiceType = v1.ServiceTypeNodePort
+	}
+	cfg.EndpointMode = config.EndpointMode(*endpointMode)
+	cfg.EndpointSliceMaxSize = *maxSliceSize
+	cfg.NodeSelector = *nodeSelector
+	if types, err := utils.ParseNodeAddressTypes(*nodeAddressTypes); err != nil {
+		klog.Fatalf("Invalid -node-address-types: %v", err)
+	} else {
+		cfg.NodeAddressTypes = types
+	}
+	if families, err := utils.ParseIPFamilies(*ipFamilies); err != nil {
+		klog.Fatalf("Invalid -ip-families: %v", err)
+	} else {
+		cfg.IPFamilies = families
+	}
+	cfg.Probe.Enabled = *probe
+	cfg.NamespaceGC = config.NamespaceGCConfig{
+		Enabled:     *namespaceGC,
+		Interval:    metaV1.Duration{Duration: *namespaceGCInt},
+		GracePeriod: metaV1.Duration{Duration: *namespaceGCGrace},
+	}
+	cfg.OrphanSweep = config.OrphanSweepConfig{
+		Enabled:      *orphanSweep,
+		Interval:     metaV1.Duration{Duration: *orphanSweepInt},
+		MaxDeletions: *orphanSweepMax,
+	}
+	cfg.MirrorLoadBalancers = *mirrorLBs
+	cfg.PodIPs = *podIPs
+	cfg.MirrorHeadless = *mirrorHeadless
+	cfg.ExternalName = *externalName
+	cfg.ExternalNameTemplate = *externalNameTmpl
+	cfg.ServiceNameTemplate = *serviceNameTmpl
+	cfg.LocalClusterName = *localClusterName
+	cfg.OptIn = *optIn
+	cfg.ExportSelector = *exportSelector
+	return cfg
+}
+
+// getRemoteCluster creates the clientset for a remote cluster. Watching endpoints (pod IP mode and headless services)
+// and the namespace selector are taken from startup, as they are only applied on startup.
+func getRemoteCluster(r utils.RemoteClusterConfig, resyncPeriod time.Duration, startup *config.Config) (*controller.RemoteCluster, error) {
+	provider, err := r.NewProvider()
 	if err != nil {
-		klog.Fatal(err)
+		return nil, fmt.Errorf("invalid configuration for remote cluster %s: %v", r.Name, err)
+	}
+
+	clientset, err := provider.Clientset()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create clientset for remote cluster %s: %v", r.Name, err)
 	}
-	return clientset
+	remote := controller.NewRemoteCluster(r.Name, clientset, resyncPeriod)
+	remote.WatchEndpoints = startup.WatchEndpoints()
+	if startup.NamespaceSelector != "" {
+		if remote.NamespaceSelector, err = labels.Parse(startup.NamespaceSelector); err != nil {
+			return nil, fmt.Errorf("invalid namespace selector: %v", err)
+		}
+	}
+	return remote, nil
+}
+
+func getRemoteClusters(cfg *config.Config) []*controller.RemoteCluster {
+	var remotes []*controller.RemoteCluster
+	for _, r := range cfg.RemoteClusters {
+		remote, err := getRemoteCluster(r, cfg.ResyncPeriod.Duration, cfg)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		remotes = append(remotes, remote)
+	}
+	return remotes
+}
+
+// applyRemoteClusters adds, removes and replaces remote clusters (while the controllers are running)
+// so that running matches cfg. Clusters that fail to start are logged and skipped. As pod IP mode, headless
+// services and the namespace selector are only applied on startup, they are taken from initial.
+func applyRemoteClusters(running map[string]*controller.RemoteCluster, old, cfg, initial *config.Config,
+	sc *controller.ServiceController, nec *controller.NodeEndpointController, stopCh <-chan struct{}) {
+	oldConfigs := make(map[string]utils.RemoteClusterConfig, len(old.RemoteClusters))
+	for _, r := range old.RemoteClusters {
+		oldConfigs[r.Name] = r
+	}
+	newConfigs := make(map[string]utils.RemoteClusterConfig, len(cfg.RemoteClusters))
+	for _, r := range cfg.RemoteClusters {
+		newConfigs[r.Name] = r
+	}
+
+	for name, remote := range running {
+		if r, ok := newConfigs[name]; ok && r == oldConfigs[name] {
+			continue
+		}
+		klog.Infof("Removing remote cluster %s", name)
+		sc.RemoveRemoteCluster(name)
+		nec.RemoveRemoteCluster(name)
+		remote.Stop()
+		delete(running, name)
+	}
+
+	for _, r := range cfg.RemoteClusters {
+		if _, ok := running[r.Name]; ok {
+			continue
+		}
+		klog.Infof("Adding remote cluster %s", r.Name)
+		remote, err := getRemoteCluster(r, cfg.ResyncPeriod.Duration, initial)
+		if err != nil {
+			klog.Error(err)
+			continue
+		}
+		if err := remote.StartAndSync(stopCh, time.Minute); err != nil {
+			klog.Error(err)
+			continue
+		}
+		sc.AddRemoteCluster(remote)
+		nec.AddRemoteCluster(remote)
+		running[r.Name] = remote
+	}
+}
+
+// newLeaderElector creates a LeaderElector calling run as soon as we become leader.
+// Informers keep running in all replicas so a new leader can start immediately.
+func newLeaderElector(client kubernetes.Interface, healthz *leaderelection.HealthzAdaptor,
+	run func(stopCh <-chan struct{}), stopCh <-chan struct{}) *leaderelection.LeaderElector {
+	id, err := os.Hostname()
+	if err != nil {
+		klog.Fatalf("Failed to get hostname for leader election: %v", err)
+	}
+
+	lock, err := resourcelock.New(*leaderLockType, *leaderLockNS, *leaderLockName,
+		client.CoreV1(), client.CoordinationV1(),
+		resourcelock.ResourceLockConfig{Identity: id})
+	if err != nil {
+		klog.Fatalf("Failed to create leader election lock: %v", err)
+	}
+
+	le, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   *leaseDuration,
+		RenewDeadline:   *renewDeadline,
+		RetryPeriod:     *retryPeriod,
+		WatchDog:        healthz,
+		ReleaseOnCancel: true,
+		Name:            *leaderLockName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				klog.Infof("Started leading as %s", id)
+				metrics.Leader.Set(1)
+				run(ctx.Done())
+			},
+			OnStoppedLeading: func() {
+				metrics.Leader.Set(0)
+				select {
+				case <-stopCh:
+					klog.Infof("Stopped leader election")
+				default:
+					// Workers may still be running, the safest thing is to start over
+					klog.Fatalf("Lost leadership, exiting")
+				}
+			},
+			OnNewLeader: func(identity string) {
+				if identity != id {
+					klog.Infof("New leader elected: %s", identity)
+				}
+			},
+		},
+	})
+	if err != nil {
+		klog.Fatalf("Failed to create leader elector: %v", err)
+	}
+	healthz.SetLeaderElection(le)
+	return le
 }
 
 func main() {
 	flag.Parse()
 
+	cfg := getBaseConfig()
+	var configWatcher *config.Watcher
+	if *configFile != "" {
+		var err error
+		configWatcher, err = config.NewWatcher(*configFile, cfg, nil)
+		if err != nil {
+			klog.Fatalf("Failed to load config file %s: %v", *configFile, err)
+		}
+		cfg = configWatcher.Config()
+	} else if err := cfg.Validate(); err != nil {
+		klog.Fatalf("Invalid configuration: %v", err)
+	}
+	if err := utils.SetLabelAnnotationKey(cfg.LabelAnnotationKey); err != nil {
+		klog.Fatal(err)
+	}
+	if err := utils.IgnoredNamespaces.Replace(cfg.IgnoredNamespaces); err != nil {
+		klog.Fatal(err)
+	}
+	if err := utils.IncludedNamespaces.Replace(cfg.IncludedNamespaces); err != nil {
+		klog.Fatal(err)
+	}
+	if err := utils.Exports.Replace(cfg.OptIn, cfg.ExportSelector); err != nil {
+		klog.Fatal(err)
+	}
+	if err := utils.NamespaceMappings.Replace(cfg.NamespaceMappings); err != nil {
+		klog.Fatal(err)
+	}
+	if err := utils.ServiceNames.Replace(cfg.ServiceNameTemplate); err != nil {
+		klog.Fatal(err)
+	}
+	if err := utils.PortSelections.Replace(cfg.PortSelections); err != nil {
+		klog.Fatal(err)
+	}
+	if err := utils.Propagation.Replace(cfg.PropagatedLabels, cfg.PropagatedAnnotations); err != nil {
+		klog.Fatal(err)
+	}
+	utils.SetLocalClusterName(cfg.LocalClusterName)
+	utils.SetMirrorLoadBalancers(cfg.MirrorLoadBalancers)
+	utils.SetMirrorPodIPs(cfg.PodIPs)
+	utils.SetMirrorHeadless(cfg.MirrorHeadless)
+	utils.SetMirrorExternalName(cfg.ExternalName)
+
 	// set up signals so we handle the first shutdown signal gracefully
 	stopCh := utils.SetupSignalHandler()
 
 	// create the clientsets
-	localClientset := getLocalClientset()
-	remoteClientset := getRemoteClientset()
+	localClientset, localDynamicClient := getLocalClientset()
+	remotes := getRemoteClusters(cfg)
+
+	// EndpointSlices are served as discovery.k8s.io/v1 or v1beta1, depending on the version of local-cluster
+	if version, err := utils.DetectEndpointSliceVersion(localClientset.Discovery()); err != nil {
+		if cfg.EndpointMode != config.EndpointModeEndpoints {
+			klog.Fatalf("Endpoint mode %s is not supported by local-cluster: %v", cfg.EndpointMode, err)
+		}
+		klog.Warningf("EndpointSlices are not available in local-cluster, only endpoint mode %s works: %v",
+			config.EndpointModeEndpoints, err)
+	} else if err := utils.SetEndpointSliceVersion(version); err != nil {
+		klog.Fatal(err)
+	} else {
+		klog.Infof("Using EndpointSlices of %s", utils.EndpointSliceResource.GroupVersion())
+	}
 
 	lservices, err := localClientset.CoreV1().Services("").List(metaV1.ListOptions{
 		LabelSelector: utils.ServiceSelector.String(),
@@ -119,69 +453,183 @@ func main() {
 		klog.Fatal(err)
 	}
 	klog.Infof("%d services to manage endpoints for in local-cluster\n", len(lservices.Items))
-	rnodes, err := remoteClientset.CoreV1().Nodes().List(metaV1.ListOptions{})
-	if err != nil {
-		klog.Fatal(err)
+	for _, remote := range remotes {
+		rnodes, err := remote.Client.CoreV1().Nodes().List(metaV1.ListOptions{})
+		if err != nil {
+			klog.Fatal(err)
+		}
+		klog.Infof("%d nodes in remote-cluster %s\n", len(rnodes.Items), remote.Name)
 	}
-	klog.Infof("%d nodes in remote-cluster\n", len(rnodes.Items))
 
 	// FIXME: Would be nice to have only one localInformerFactory
 	// and apply the filter in NodeEndpointController
 	localFilteredInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(
 		localClientset,
-		*resyncPeriod,
+		cfg.ResyncPeriod.Duration,
 		kubeinformers.WithTweakListOptions(func(options *metaV1.ListOptions) {
 			options.LabelSelector = utils.ServiceSelector.String()
 		}),
 	)
-	localInformerFactory := kubeinformers.NewSharedInformerFactory(localClientset, *resyncPeriod)
-	remoteInformerFactory := kubeinformers.NewSharedInformerFactory(remoteClientset, *resyncPeriod)
+	localInformerFactory := kubeinformers.NewSharedInformerFactory(localClientset, cfg.ResyncPeriod.Duration)
 
 	nodeEndpointController := controller.NewNodeEndpointController(
-		localClientset, remoteClientset,
+		localClientset, localDynamicClient, remotes,
 		localFilteredInformerFactory.Core().V1().Services(),
-		remoteInformerFactory.Core().V1().Nodes(),
+		cfg,
 	)
 
 	serviceController := controller.NewServiceController(
-		localClientset, remoteClientset,
-		remoteInformerFactory.Core().V1().Services(), localInformerFactory.Core().V1().Services(),
-		*createNodePortSvc,
+		localClientset, remotes,
+		localInformerFactory.Core().V1().Services(),
+		cfg,
 	)
 
+	var namespaceGarbageCollector *controller.NamespaceGC
+	if cfg.NamespaceGC.Enabled {
+		namespaceGarbageCollector = controller.NewNamespaceGC(localClientset, localDynamicClient, serviceController, cfg.NamespaceGC)
+	}
+	var orphanSweeper *controller.OrphanSweeper
+	if cfg.OrphanSweep.Enabled {
+		orphanSweeper = controller.NewOrphanSweeper(localClientset, serviceController, cfg.OrphanSweep)
+	}
+
+	if *dryRun {
+		klog.Infof("Running in dry-run mode, \"local\" cluster will not be modified")
+		plan := controller.NewPlan()
+		nodeEndpointController.SetDryRun(plan)
+		serviceController.SetDryRun(plan)
+		if namespaceGarbageCollector != nil {
+			namespaceGarbageCollector.SetDryRun(plan)
+		}
+		if orphanSweeper != nil {
+			orphanSweeper.SetDryRun(plan)
+		}
+		http.Handle("/plan", plan)
+	}
+
+	var prober *controller.Prober
+	if cfg.Probe.Enabled {
+		prober = controller.NewProber(cfg.Probe)
+		nodeEndpointController.SetProber(prober)
+	}
+
 	// Ramp up the informer loops
 	// They run all registered informer in go routines
 	localFilteredInformerFactory.Start(stopCh)
-	remoteInformerFactory.Start(stopCh)
 	localInformerFactory.Start(stopCh)
+	runningRemotes := make(map[string]*controller.RemoteCluster, len(remotes))
+	for _, remote := range remotes {
+		remote.Start(stopCh)
+		runningRemotes[remote.Name] = remote
+	}
 
-	// Register http handler for metrics and readiness/liveness probe
-	http.Handle("/metrics", promhttp.Handler())
-	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		_, _ = fmt.Fprint(w, "OK")
-	})
-	httpServer := &http.Server{Addr: *addr}
-	go func() {
-		// Launch HTTP server
-		_ = httpServer.ListenAndServe()
-	}()
+	if configWatcher != nil {
+		initialCfg := cfg
+		configWatcher.OnChange = func(old, cur *config.Config) {
+			if changes := initialCfg.StartupOnlyChanges(cur); len(changes) > 0 {
+				klog.Warningf("Changes to %s require a restart to be applied", strings.Join(changes, ", "))
+			}
+			if err := utils.IgnoredNamespaces.Replace(cur.IgnoredNamespaces); err != nil {
+				klog.Errorf("Failed to apply ignored namespaces: %v", err)
+			}
+			if err := utils.IncludedNamespaces.Replace(cur.IncludedNamespaces); err != nil {
+				klog.Errorf("Failed to apply included namespaces: %v", err)
+			}
+			if err := utils.Exports.Replace(cur.OptIn, cur.ExportSelector); err != nil {
+				klog.Errorf("Failed to apply opt-in mode: %v", err)
+			}
+			if err := utils.NamespaceMappings.Replace(cur.NamespaceMappings); err != nil {
+				klog.Errorf("Failed to apply namespace mappings: %v", err)
+			}
+			if err := utils.ServiceNames.Replace(cur.ServiceNameTemplate); err != nil {
+				klog.Errorf("Failed to apply service name template: %v", err)
+			}
+			if err := utils.PortSelections.Replace(cur.PortSelections); err != nil {
+				klog.Errorf("Failed to apply port selections: %v", err)
+			}
+			if err := utils.Propagation.Replace(cur.PropagatedLabels, cur.PropagatedAnnotations); err != nil {
+				klog.Errorf("Failed to apply propagated labels and annotations: %v", err)
+			}
+			utils.SetMirrorLoadBalancers(cur.MirrorLoadBalancers)
+			utils.SetMirrorExternalName(cur.ExternalName)
+			serviceController.SetConfig(cur)
+			nodeEndpointController.SetConfig(cur)
+			applyRemoteClusters(runningRemotes, old, cur, initialCfg, serviceController, nodeEndpointController, stopCh)
+		}
+	}
 
 	// Launch the controllers
 	// This will block 'till stopCh
-	func() {
+	runControllers := func(stopCh <-chan struct{}) {
 		go func() {
-			if err = nodeEndpointController.Run(int(*necWorkers), stopCh); err != nil {
+			if err := nodeEndpointController.Run(cfg.Workers.NodeEndpointController, stopCh); err != nil {
 				klog.Fatalf("Error running nodeEndpointController: %s", err.Error())
 			}
 		}()
 		go func() {
-			if err = serviceController.Run(int(*scWorkers), stopCh); err != nil {
+			if err := serviceController.Run(cfg.Workers.ServiceController, stopCh); err != nil {
 				klog.Fatalf("Error running serviceController: %s", err.Error())
 			}
 		}()
+		if prober != nil {
+			go prober.Run(stopCh)
+		}
+		if namespaceGarbageCollector != nil {
+			go namespaceGarbageCollector.Run(stopCh)
+		}
+		if orphanSweeper != nil {
+			go orphanSweeper.Run(stopCh)
+		}
+		// Only the leader applies config changes, changes made while standing by are applied on the first check
+		if configWatcher != nil {
+			go configWatcher.Run(*configInterval, stopCh)
+		}
 		<-stopCh
+	}
+
+	var leaderElector *leaderelection.LeaderElector
+	leaderHealthz := leaderelection.NewLeaderHealthzAdaptor(*renewDeadline)
+	if *leaderElect {
+		leaderElector = newLeaderElector(localClientset, leaderHealthz, runControllers, stopCh)
+	}
+
+	// Register http handler for metrics and readiness/liveness probe
+	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if leaderElector == nil {
+			_, _ = fmt.Fprint(w, "OK")
+			return
+		}
+		// Fails if we are leader but did not renew the lease in time
+		if err := leaderHealthz.Check(r); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if leaderElector.IsLeader() {
+			_, _ = fmt.Fprint(w, "OK (leader)")
+		} else {
+			_, _ = fmt.Fprintf(w, "OK (standby, leader: %s)", leaderElector.GetLeader())
+		}
+	})
+	httpServer := &http.Server{Addr: *addr}
+	go func() {
+		// Launch HTTP server
+		_ = httpServer.ListenAndServe()
 	}()
 
+	if leaderElector == nil {
+		metrics.Leader.Set(1)
+		runControllers(stopCh)
+	} else {
+		// Blocks until stopCh or leadership is lost
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			<-stopCh
+			cancel()
+		}()
+		leaderElector.Run(ctx)
+	}
+
 	// Gracefully stop HTTP server
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	if err := httpServer.Shutdown(ctx); err != nil {
@@ -4,10 +4,13 @@ import "github.com/prometheus/client_golang/prometheus"
 
 var (
 	// Prometheus metrics
-	NodeCount = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "barrelman_current_nodes_count",
-		Help: "Number of nodes in watched cluster.",
-	})
+	NodeCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "barrelman_current_nodes_count",
+			Help: "Number of nodes in watched cluster (by remote cluster).",
+		},
+		[]string{"cluster"},
+	)
 	EndpointUpdates = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "barrelman_endpoint_update_total",
 		Help: "Count of service endpoints updates",
@@ -30,6 +33,38 @@ var (
 		},
 		[]string{"action"},
 	)
+	Leader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "barrelman_leader",
+		Help: "1 if this instance is the leader (and runs the controllers), 0 otherwise.",
+	})
+	ConfigReloads = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "barrelman_config_reload_total",
+			Help: "Count of config file reloads (by result)",
+		},
+		[]string{"result"},
+	)
+	Probes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "barrelman_probe_total",
+			Help: "Count of node port probes (by probe type and result)",
+		},
+		[]string{"type", "result"},
+	)
+	ProbeDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "barrelman_probe_duration_seconds",
+			Help: "Duration of node port probes (by probe type)",
+		},
+		[]string{"type"},
+	)
+	ProbeTargets = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "barrelman_probe_targets",
+			Help: "Number of probed node ports (by state)",
+		},
+		[]string{"state"},
+	)
 	ObjectsQueued = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "barrelman_services_queued_total",
@@ -37,6 +72,17 @@ var (
 		},
 		[]string{"controller", "requeued"},
 	)
+	OrphansSwept = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "barrelman_orphans_swept_total",
+			Help: "Count of orphaned objects found by the orphan sweeper (by kind)",
+		},
+		[]string{"kind"},
+	)
+	NamespaceGCBlocked = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "barrelman_namespace_gc_blocked_total",
+		Help: "Count of namespace GC runs skipped because the namespaced resources could not be discovered",
+	})
 )
 
 func init() {
@@ -47,4 +93,11 @@ func init() {
 	prometheus.MustRegister(ServiceUpdates)
 	prometheus.MustRegister(ServiceUpdateErrors)
 	prometheus.MustRegister(ObjectsQueued)
+	prometheus.MustRegister(Leader)
+	prometheus.MustRegister(ConfigReloads)
+	prometheus.MustRegister(Probes)
+	prometheus.MustRegister(ProbeDuration)
+	prometheus.MustRegister(ProbeTargets)
+	prometheus.MustRegister(OrphansSwept)
+	prometheus.MustRegister(NamespaceGCBlocked)
 }
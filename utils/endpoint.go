@@ -16,15 +16,54 @@ func endpointPorts(service *v1.Service) ([]v1.EndpointPort, error) {
 		endpointPorts = append(
 			endpointPorts,
 			v1.EndpointPort{
-				Port: port.TargetPort.IntVal,
-				Name: port.Name,
+				Port:     port.TargetPort.IntVal,
+				Name:     port.Name,
+				Protocol: port.Protocol,
 			},
 		)
 	}
 	return endpointPorts, nil
 }
 
-func endpointAddresses(nodes []*v1.Node) []v1.EndpointAddress {
+// remoteEndpointPorts returns the NodePorts of remoteService for all ports of service (the service ports for
+// mirrored LoadBalancer services). Ports are matched by name and port (the local port of the mirrored ports,
+// see MirroredPorts), ports missing in remoteService are skipped.
+func remoteEndpointPorts(service, remoteService *v1.Service) ([]v1.EndpointPort, error) {
+	loadBalancer := IsLoadBalancerMirrored(remoteService)
+	mirroredPorts, err := MirroredPorts(remoteService)
+	if err != nil {
+		return nil, err
+	}
+	var endpointPorts []v1.EndpointPort
+	for _, port := range service.Spec.Ports {
+		for _, mirrored := range mirroredPorts {
+			remotePort := mirrored.Remote
+			targetPort := remotePort.NodePort
+			if loadBalancer {
+				targetPort = remotePort.Port
+			}
+			if port.Name != remotePort.Name || port.Port != mirrored.Port || targetPort == 0 {
+				continue
+			}
+			endpointPorts = append(
+				endpointPorts,
+				v1.EndpointPort{
+					Port:     targetPort,
+					Name:     port.Name,
+					Protocol: remotePort.Protocol,
+				},
+			)
+			break
+		}
+	}
+	if len(endpointPorts) < 1 {
+		return nil, fmt.Errorf("no matching node ports in remote service for service: %s", service.GetName())
+	}
+	return endpointPorts, nil
+}
+
+// endpointAddresses returns the addresses of family (see GetNodeFamilyAddress) of all ready nodes
+func endpointAddresses(nodes []*v1.Node, addressTypes []string, family IPFamily) []v1.EndpointAddress {
 	var endpointAddresses []v1.EndpointAddress
 
 	for _, node := range nodes {
@@ -32,7 +71,7 @@ func endpointAddresses(nodes []*v1.Node) []v1.EndpointAddress {
 			continue
 		}
 
-		ip, err := GetNodeInternalIP(node)
+		ip, err := GetNodeFamilyAddress(node, addressTypes, family)
 		if err != nil {
 			continue
 		}
@@ -47,22 +86,58 @@ func endpointAddresses(nodes []*v1.Node) []v1.EndpointAddress {
 	return endpointAddresses
 }
 
+// loadBalancerIPs returns the load balancer ingress IPs of family of service (ingresses with hostname only
+// are skipped)
+func loadBalancerIPs(service *v1.Service, family IPFamily) []string {
+	var ips []string
+	for _, ingress := range service.Status.LoadBalancer.Ingress {
+		if f, ok := IPFamilyOf(ingress.IP); ok && f == family {
+			ips = append(ips, ingress.IP)
+		}
+	}
+	return ips
+}
+
+// ClusterEndpointSubset builds the EndpointSubset pointing to the nodes of a single remote cluster.
+// For mirrored LoadBalancer services (see IsLoadBalancerMirrored), it points to the load balancer IPs instead.
+// If remoteService is nil, the endpoint ports are the targetPorts of service. Otherwise they are the NodePorts
+// of remoteService, as they may differ between remote clusters. Node addresses of family are chosen via addressTypes.
+func ClusterEndpointSubset(service, remoteService *v1.Service, nodes []*v1.Node, addressTypes []string, family IPFamily) (v1.EndpointSubset, error) {
+	var epPorts []v1.EndpointPort
+	var err error
+	if remoteService == nil {
+		epPorts, err = endpointPorts(service)
+	} else {
+		epPorts, err = remoteEndpointPorts(service, remoteService)
+	}
+	if err != nil {
+		return v1.EndpointSubset{}, err
+	}
+
+	if IsLoadBalancerMirrored(remoteService) {
+		var addresses []v1.EndpointAddress
+		for _, ip := range loadBalancerIPs(remoteService, family) {
+			addresses = append(addresses, v1.EndpointAddress{IP: ip})
+		}
+		return v1.EndpointSubset{Addresses: addresses, Ports: epPorts}, nil
+	}
+
+	return v1.EndpointSubset{
+		Addresses: endpointAddresses(nodes, addressTypes, family),
+		Ports:     epPorts,
+	}, nil
+}
+
 func EndpointSubset(service *v1.Service, nodes []*v1.Node) ([]v1.EndpointSubset, error) {
-	epPorts, err := endpointPorts(service)
+	epSubset, err := ClusterEndpointSubset(service, nil, nodes, DefaultNodeAddressTypes, IPv4)
 	if err != nil {
 		return nil, err
 	}
 
-	epAddresses := endpointAddresses(nodes)
-	if len(epAddresses) < 1 {
+	if len(epSubset.Addresses) < 1 {
 		return nil, fmt.Errorf("No valid (ready) node IPs found")
 	}
-	return []v1.EndpointSubset{
-		v1.EndpointSubset{
-			Addresses: epAddresses,
-			Ports:     epPorts,
-		},
-	}, nil
+	return []v1.EndpointSubset{epSubset}, nil
 }
 
 // NewEndPoints creates a new Endpoints object for the given service
@@ -73,13 +148,64 @@ func NewEndpoint(service *v1.Service, nodes []*v1.Node) (*v1.Endpoints, error) {
 		return nil, err
 	}
 
-	result := &v1.Endpoints{
+	return NewEndpointWithSubsets(service, epSubset), nil
+}
+
+// podEndpointPorts returns the ports of a remote endpoint subset that match a port of service by name
+func podEndpointPorts(service *v1.Service, remotePorts []v1.EndpointPort) []v1.EndpointPort {
+	var ports []v1.EndpointPort
+	for _, remotePort := range remotePorts {
+		for _, port := range service.Spec.Ports {
+			if port.Name == remotePort.Name {
+				ports = append(ports, remotePort)
+				break
+			}
+		}
+	}
+	return ports
+}
+
+// podAddresses copies the addresses of family. References to remote objects (node, pod) are dropped.
+func podAddresses(remoteAddresses []v1.EndpointAddress, family IPFamily) []v1.EndpointAddress {
+	var addresses []v1.EndpointAddress
+	for _, address := range remoteAddresses {
+		if f, ok := IPFamilyOf(address.IP); !ok || f != family {
+			continue
+		}
+		addresses = append(addresses, v1.EndpointAddress{IP: address.IP, Hostname: address.Hostname})
+	}
+	return addresses
+}
+
+// PodEndpointSubsets builds the EndpointSubsets pointing to the pods of a single remote cluster for services
+// mirrored in pod IP mode (see IsPodIPMirrored). Addresses of family are copied from remoteEndpoints, keeping
+// their readiness and hostname (for DNS records of headless services). Remote ports are used as is if service
+// has a port of the same name, services without ports (headless) get subsets without ports.
+func PodEndpointSubsets(service *v1.Service, remoteEndpoints *v1.Endpoints, family IPFamily) []v1.EndpointSubset {
+	var subsets []v1.EndpointSubset
+	for _, remoteSubset := range remoteEndpoints.Subsets {
+		subset := v1.EndpointSubset{
+			Addresses:         podAddresses(remoteSubset.Addresses, family),
+			NotReadyAddresses: podAddresses(remoteSubset.NotReadyAddresses, family),
+			Ports:             podEndpointPorts(service, remoteSubset.Ports),
+		}
+		if (len(subset.Ports) == 0 && len(service.Spec.Ports) > 0) ||
+			len(subset.Addresses)+len(subset.NotReadyAddresses) == 0 {
+			continue
+		}
+		subsets = append(subsets, subset)
+	}
+	return subsets
+}
+
+// NewEndpointWithSubsets creates a new Endpoints object for the given service containing subsets
+func NewEndpointWithSubsets(service *v1.Service, subsets []v1.EndpointSubset) *v1.Endpoints {
+	return &v1.Endpoints{
 		ObjectMeta: metaV1.ObjectMeta{
 			Name:      service.GetName(),
 			Namespace: service.GetNamespace(),
 			Labels:    ServiceLabel,
 		},
-		Subsets: epSubset,
+		Subsets: subsets,
 	}
-	return result, nil
 }
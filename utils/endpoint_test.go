@@ -190,7 +190,7 @@ func Test_endpointAddresses(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := endpointAddresses(tt.nodes); !reflect.DeepEqual(got, tt.want) {
+			if got := endpointAddresses(tt.nodes, DefaultNodeAddressTypes, IPv4); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("endpointAddresses() = %v, want %v", got, tt.want)
 			}
 		})
@@ -354,3 +354,232 @@ func TestNewEndpoint(t *testing.T) {
 		})
 	}
 }
+
+func Test_remoteEndpointPorts(t *testing.T) {
+	tests := []struct {
+		name          string
+		service       *v1.Service
+		remoteService *v1.Service
+		want          []v1.EndpointPort
+		wantErr       bool
+	}{
+		{
+			"NoMatchingPort",
+			&v1.Service{
+				Spec: v1.ServiceSpec{
+					Ports: []v1.ServicePort{{Name: "fooo", Port: 80, TargetPort: intstr.FromInt(30080)}},
+				},
+			},
+			&v1.Service{
+				Spec: v1.ServiceSpec{
+					Ports: []v1.ServicePort{{Name: "bar", Port: 80, NodePort: 30080}},
+				},
+			},
+			nil,
+			true,
+		},
+		{
+			"DifferentNodePort",
+			&v1.Service{
+				Spec: v1.ServiceSpec{
+					Ports: []v1.ServicePort{
+						{Name: "fooo", Port: 80, TargetPort: intstr.FromInt(30080)},
+						{Name: "bar", Port: 443, TargetPort: intstr.FromInt(30443)},
+					},
+				},
+			},
+			&v1.Service{
+				Spec: v1.ServiceSpec{
+					Ports: []v1.ServicePort{
+						{Name: "bar", Port: 443, NodePort: 31443},
+						{Name: "fooo", Port: 80, NodePort: 31080},
+					},
+				},
+			},
+			[]v1.EndpointPort{
+				{Name: "fooo", Port: 31080},
+				{Name: "bar", Port: 31443},
+			},
+			false,
+		},
+		{
+			"MissingPortSkipped",
+			&v1.Service{
+				Spec: v1.ServiceSpec{
+					Ports: []v1.ServicePort{
+						{Name: "fooo", Port: 80, TargetPort: intstr.FromInt(30080)},
+						{Name: "bar", Port: 443, TargetPort: intstr.FromInt(30443)},
+					},
+				},
+			},
+			&v1.Service{
+				Spec: v1.ServiceSpec{
+					Ports: []v1.ServicePort{{Name: "fooo", Port: 80, NodePort: 31080}},
+				},
+			},
+			[]v1.EndpointPort{
+				{Name: "fooo", Port: 31080},
+			},
+			false,
+		},
+		{
+			"LoadBalancer",
+			&v1.Service{
+				Spec: v1.ServiceSpec{
+					Ports: []v1.ServicePort{{Name: "fooo", Port: 80, TargetPort: intstr.FromInt(80)}},
+				},
+			},
+			&v1.Service{
+				ObjectMeta: metaV1.ObjectMeta{Annotations: map[string]string{LoadBalancerAnnotation: "true"}},
+				Spec: v1.ServiceSpec{
+					Type:  v1.ServiceTypeLoadBalancer,
+					Ports: []v1.ServicePort{{Name: "fooo", Port: 80, NodePort: 31080}},
+				},
+			},
+			[]v1.EndpointPort{
+				{Name: "fooo", Port: 80},
+			},
+			false,
+		},
+		{
+			"Protocol",
+			&v1.Service{
+				Spec: v1.ServiceSpec{
+					Ports: []v1.ServicePort{{Name: "dns", Port: 53, TargetPort: intstr.FromInt(30053)}},
+				},
+			},
+			&v1.Service{
+				Spec: v1.ServiceSpec{
+					Ports: []v1.ServicePort{{Name: "dns", Port: 53, NodePort: 31053, Protocol: v1.ProtocolUDP}},
+				},
+			},
+			[]v1.EndpointPort{
+				{Name: "dns", Port: 31053, Protocol: v1.ProtocolUDP},
+			},
+			false,
+		},
+		{
+			"SelectedPorts",
+			&v1.Service{
+				Spec: v1.ServiceSpec{
+					Ports: []v1.ServicePort{
+						{Name: "http", Port: 8080, TargetPort: intstr.FromInt(30080)},
+						{Name: "admin", Port: 9090, TargetPort: intstr.FromInt(30090)},
+					},
+				},
+			},
+			&v1.Service{
+				ObjectMeta: metaV1.ObjectMeta{Annotations: map[string]string{PortsAnnotation: "http=8080"}},
+				Spec: v1.ServiceSpec{
+					Ports: []v1.ServicePort{
+						{Name: "http", Port: 80, NodePort: 31080},
+						{Name: "admin", Port: 9090, NodePort: 31090},
+					},
+				},
+			},
+			[]v1.EndpointPort{
+				{Name: "http", Port: 31080},
+			},
+			false,
+		},
+		{
+			"InvalidPortSelection",
+			&v1.Service{
+				Spec: v1.ServiceSpec{
+					Ports: []v1.ServicePort{{Name: "http", Port: 80, TargetPort: intstr.FromInt(30080)}},
+				},
+			},
+			&v1.Service{
+				ObjectMeta: metaV1.ObjectMeta{Annotations: map[string]string{PortsAnnotation: "-http=80"}},
+				Spec: v1.ServiceSpec{
+					Ports: []v1.ServicePort{{Name: "http", Port: 80, NodePort: 31080}},
+				},
+			},
+			nil,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := remoteEndpointPorts(tt.service, tt.remoteService)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("remoteEndpointPorts() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("remoteEndpointPorts() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPodEndpointSubsets(t *testing.T) {
+	nodeName := "node-1"
+	remoteEndpoints := &v1.Endpoints{
+		Subsets: []v1.EndpointSubset{
+			{
+				Addresses: []v1.EndpointAddress{
+					{IP: "10.8.0.1", NodeName: &nodeName, TargetRef: &v1.ObjectReference{Kind: "Pod", Name: "foo-1"}},
+					{IP: "fd00::1"},
+				},
+				NotReadyAddresses: []v1.EndpointAddress{{IP: "10.8.0.2", Hostname: "foo-2"}},
+				Ports: []v1.EndpointPort{
+					{Name: "fooo", Port: 8080, Protocol: v1.ProtocolTCP},
+					{Name: "unknown", Port: 9090, Protocol: v1.ProtocolTCP},
+				},
+			},
+			{
+				// No port of the service
+				Addresses: []v1.EndpointAddress{{IP: "10.8.0.3"}},
+				Ports:     []v1.EndpointPort{{Name: "unknown", Port: 9090, Protocol: v1.ProtocolTCP}},
+			},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		family IPFamily
+		want   []v1.EndpointSubset
+	}{
+		{
+			"IPv4",
+			IPv4,
+			[]v1.EndpointSubset{{
+				Addresses:         []v1.EndpointAddress{{IP: "10.8.0.1"}},
+				NotReadyAddresses: []v1.EndpointAddress{{IP: "10.8.0.2", Hostname: "foo-2"}},
+				Ports:             []v1.EndpointPort{{Name: "fooo", Port: 8080, Protocol: v1.ProtocolTCP}},
+			}},
+		},
+		{
+			"IPv6",
+			IPv6,
+			[]v1.EndpointSubset{{
+				Addresses: []v1.EndpointAddress{{IP: "fd00::1"}},
+				Ports:     []v1.EndpointPort{{Name: "fooo", Port: 8080, Protocol: v1.ProtocolTCP}},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PodEndpointSubsets(aService, remoteEndpoints, tt.family); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PodEndpointSubsets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPodEndpointSubsetsHeadless(t *testing.T) {
+	// Headless services without ports keep subsets without ports
+	service := &v1.Service{Spec: v1.ServiceSpec{ClusterIP: v1.ClusterIPNone}}
+	remoteEndpoints := &v1.Endpoints{
+		Subsets: []v1.EndpointSubset{{
+			Addresses: []v1.EndpointAddress{{IP: "10.8.0.1", Hostname: "foo-0"}, {IP: "10.8.0.2", Hostname: "foo-1"}},
+		}},
+	}
+	want := []v1.EndpointSubset{{
+		Addresses: []v1.EndpointAddress{{IP: "10.8.0.1", Hostname: "foo-0"}, {IP: "10.8.0.2", Hostname: "foo-1"}},
+	}}
+	if got := PodEndpointSubsets(service, remoteEndpoints, IPv4); !reflect.DeepEqual(got, want) {
+		t.Errorf("PodEndpointSubsets() = %v, want %v", got, want)
+	}
+}
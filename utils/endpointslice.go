@@ -0,0 +1,301 @@
+package utils
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+)
+
+/*
+The vendored k8s.io/api does not know about discovery.k8s.io, so EndpointSlices are handled via the dynamic client.
+The types below mirror the parts of discovery.k8s.io/v1 barrelman needs, they are the same in v1beta1.
+*/
+
+const (
+	// LabelServiceName is the label used by kubernetes to map EndpointSlices to services
+	LabelServiceName = "kubernetes.io/service-name"
+	// LabelManagedBy is the label used to declare which controller manages an EndpointSlice
+	LabelManagedBy = "endpointslice.kubernetes.io/managed-by"
+	// LabelValueManagedBy is the value of LabelManagedBy for EndpointSlices managed by barrelman
+	LabelValueManagedBy = "barrelman.tfw.io"
+	// LabelSkipMirror tells the kubernetes EndpointSlice mirroring controller to ignore an Endpoints object
+	LabelSkipMirror = "endpointslice.kubernetes.io/skip-mirror"
+)
+
+var (
+	// EndpointSliceResource is the resource to use with the dynamic client.
+	// Use SetEndpointSliceVersion to change the version.
+	EndpointSliceResource = schema.GroupVersionResource{Group: "discovery.k8s.io", Version: "v1", Resource: "endpointslices"}
+
+	// EndpointSliceVersions are the supported versions of discovery.k8s.io, preferred first.
+	// v1 is served since Kubernetes 1.21, v1beta1 since 1.17.
+	EndpointSliceVersions = []string{"v1", "v1beta1"}
+)
+
+// DetectEndpointSliceVersion returns the first of EndpointSliceVersions served by the cluster
+func DetectEndpointSliceVersion(client discovery.DiscoveryInterface) (string, error) {
+	groups, err := client.ServerGroups()
+	if err != nil {
+		return "", err
+	}
+	served := make(map[string]bool)
+	for _, group := range groups.Groups {
+		if group.Name != EndpointSliceResource.Group {
+			continue
+		}
+		for _, version := range group.Versions {
+			served[version.Version] = true
+		}
+	}
+	for _, version := range EndpointSliceVersions {
+		if served[version] {
+			return version, nil
+		}
+	}
+	return "", fmt.Errorf("%s is not served in version %s (EndpointSlices require Kubernetes 1.17 or later)",
+		EndpointSliceResource.Group, strings.Join(EndpointSliceVersions, " or "))
+}
+
+// SetEndpointSliceVersion changes the version of EndpointSliceResource (one of EndpointSliceVersions).
+// It is not safe to call this while controllers are running.
+func SetEndpointSliceVersion(version string) error {
+	for _, v := range EndpointSliceVersions {
+		if v == version {
+			EndpointSliceResource.Version = version
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported EndpointSlice version %q", version)
+}
+
+// EndpointSlice mirrors discovery.k8s.io/v1 EndpointSlice
+type EndpointSlice struct {
+	metaV1.TypeMeta   `json:",inline"`
+	metaV1.ObjectMeta `json:"metadata,omitempty"`
+	AddressType       string                  `json:"addressType"`
+	Endpoints         []EndpointSliceEndpoint `json:"endpoints"`
+	Ports             []EndpointSlicePort     `json:"ports"`
+}
+
+// EndpointSliceEndpoint mirrors discovery.k8s.io/v1 Endpoint
+type EndpointSliceEndpoint struct {
+	Addresses  []string                `json:"addresses"`
+	Conditions EndpointSliceConditions `json:"conditions,omitempty"`
+	Hostname   *string                 `json:"hostname,omitempty"`
+}
+
+// EndpointSliceConditions mirrors discovery.k8s.io/v1 EndpointConditions
+type EndpointSliceConditions struct {
+	Ready *bool `json:"ready,omitempty"`
+}
+
+// EndpointSlicePort mirrors discovery.k8s.io/v1 EndpointPort
+type EndpointSlicePort struct {
+	Name     *string      `json:"name,omitempty"`
+	Protocol *v1.Protocol `json:"protocol,omitempty"`
+	Port     *int32       `json:"port,omitempty"`
+}
+
+// ToUnstructured converts the EndpointSlice to be used with the dynamic client
+func (e *EndpointSlice) ToUnstructured() (*unstructured.Unstructured, error) {
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(e)
+	if err != nil {
+		return nil, err
+	}
+	return &unstructured.Unstructured{Object: obj}, nil
+}
+
+// EndpointSliceFromUnstructured converts a object returned by the dynamic client to EndpointSlice
+func EndpointSliceFromUnstructured(u *unstructured.Unstructured) (*EndpointSlice, error) {
+	e := &EndpointSlice{}
+	err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), e)
+	return e, err
+}
+
+// EndpointSliceSelector selects all EndpointSlices barrelman manages for service
+func EndpointSliceSelector(service *v1.Service) labels.Selector {
+	return labels.SelectorFromSet(labels.Set{
+		LabelServiceName: service.GetName(),
+		LabelManagedBy:   LabelValueManagedBy,
+	})
+}
+
+// EndpointSlicesEqual compares the parts of two EndpointSlices managed by barrelman
+func EndpointSlicesEqual(a, b *EndpointSlice) bool {
+	return reflect.DeepEqual(a.Labels, b.Labels) &&
+		reflect.DeepEqual(a.OwnerReferences, b.OwnerReferences) &&
+		a.AddressType == b.AddressType &&
+		reflect.DeepEqual(a.Endpoints, b.Endpoints) &&
+		reflect.DeepEqual(a.Ports, b.Ports)
+}
+
+// sliceEndpoints returns one endpoint per node (that has an address of family and addressTypes), ready condition
+// is set via IsNodeReady
+func sliceEndpoints(nodes []*v1.Node, addressTypes []string, family IPFamily) []EndpointSliceEndpoint {
+	var endpoints []EndpointSliceEndpoint
+	for _, node := range nodes {
+		ip, err := GetNodeFamilyAddress(node, addressTypes, family)
+		if err != nil {
+			continue
+		}
+		ready := IsNodeReady(node)
+		endpoints = append(endpoints, EndpointSliceEndpoint{
+			Addresses:  []string{ip},
+			Conditions: EndpointSliceConditions{Ready: &ready},
+		})
+	}
+
+	// Sort to keep the distribution over slices stable
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].Addresses[0] < endpoints[j].Addresses[0] })
+	return endpoints
+}
+
+// ClusterEndpointSlices builds the EndpointSlices for service pointing to the nodes of a single remote cluster.
+// Ports and addresses are chosen like in ClusterEndpointSubset. Every slice contains at most maxEndpoints endpoints.
+// Slices are named <service>-<cluster>-<n> for IPv4 and <service>-<cluster>-ipv6-<n> for IPv6.
+func ClusterEndpointSlices(service, remoteService *v1.Service, nodes []*v1.Node, addressTypes []string, family IPFamily, cluster string, maxEndpoints int) ([]*EndpointSlice, error) {
+	var epPorts []v1.EndpointPort
+	var err error
+	if remoteService == nil {
+		epPorts, err = endpointPorts(service)
+	} else {
+		epPorts, err = remoteEndpointPorts(service, remoteService)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if maxEndpoints < 1 {
+		return nil, fmt.Errorf("invalid maximum number of endpoints per slice: %d", maxEndpoints)
+	}
+
+	ports := make([]EndpointSlicePort, len(epPorts))
+	for i := range epPorts {
+		// Protocol is defaulted by kubernetes, set it to be able to compare slices
+		protocol := v1.ProtocolTCP
+		for _, port := range service.Spec.Ports {
+			if port.Name == epPorts[i].Name && port.Protocol != "" {
+				protocol = port.Protocol
+			}
+		}
+		ports[i] = EndpointSlicePort{Name: &epPorts[i].Name, Protocol: &protocol, Port: &epPorts[i].Port}
+	}
+
+	var endpoints []EndpointSliceEndpoint
+	if IsLoadBalancerMirrored(remoteService) {
+		// Load balancers are always ready
+		for _, ip := range loadBalancerIPs(remoteService, family) {
+			ready := true
+			endpoints = append(endpoints, EndpointSliceEndpoint{
+				Addresses:  []string{ip},
+				Conditions: EndpointSliceConditions{Ready: &ready},
+			})
+		}
+	} else {
+		endpoints = sliceEndpoints(nodes, addressTypes, family)
+	}
+	return splitEndpointSlices(service, slicePrefix(service, cluster, family), 0, family, endpoints, ports,
+		maxEndpoints), nil
+}
+
+// PodEndpointSlices builds the EndpointSlices for service pointing to the pods of a single remote cluster,
+// see PodEndpointSubsets. Slices are named like in ClusterEndpointSlices, each remote subset gets its own slices.
+func PodEndpointSlices(service *v1.Service, remoteEndpoints *v1.Endpoints, family IPFamily, cluster string, maxEndpoints int) ([]*EndpointSlice, error) {
+	if maxEndpoints < 1 {
+		return nil, fmt.Errorf("invalid maximum number of endpoints per slice: %d", maxEndpoints)
+	}
+
+	prefix := slicePrefix(service, cluster, family)
+	var slices []*EndpointSlice
+	for _, subset := range PodEndpointSubsets(service, remoteEndpoints, family) {
+		ports := make([]EndpointSlicePort, len(subset.Ports))
+		for i := range subset.Ports {
+			protocol := subset.Ports[i].Protocol
+			if protocol == "" {
+				protocol = v1.ProtocolTCP
+			}
+			ports[i] = EndpointSlicePort{Name: &subset.Ports[i].Name, Protocol: &protocol, Port: &subset.Ports[i].Port}
+		}
+
+		endpoints := podSliceEndpoints(subset.Addresses, true)
+		endpoints = append(endpoints, podSliceEndpoints(subset.NotReadyAddresses, false)...)
+		sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].Addresses[0] < endpoints[j].Addresses[0] })
+
+		slices = append(slices, splitEndpointSlices(service, prefix, len(slices), family, endpoints, ports,
+			maxEndpoints)...)
+	}
+	return slices, nil
+}
+
+// podSliceEndpoints returns one endpoint per address with the given ready condition (and hostname, if set)
+func podSliceEndpoints(addresses []v1.EndpointAddress, ready bool) []EndpointSliceEndpoint {
+	endpoints := make([]EndpointSliceEndpoint, len(addresses))
+	for i, address := range addresses {
+		endpoints[i] = EndpointSliceEndpoint{
+			Addresses:  []string{address.IP},
+			Conditions: EndpointSliceConditions{Ready: &ready},
+		}
+		if address.Hostname != "" {
+			hostname := address.Hostname
+			endpoints[i].Hostname = &hostname
+		}
+	}
+	return endpoints
+}
+
+// slicePrefix returns the name prefix of the slices of service for cluster and family
+func slicePrefix(service *v1.Service, cluster string, family IPFamily) string {
+	prefix := fmt.Sprintf("%s-%s", service.GetName(), cluster)
+	if family != IPv4 {
+		prefix += "-" + strings.ToLower(string(family))
+	}
+	return prefix
+}
+
+// splitEndpointSlices distributes endpoints over slices of at most maxEndpoints endpoints each, named
+// <prefix>-<n> starting with n = first
+func splitEndpointSlices(service *v1.Service, prefix string, first int, family IPFamily, endpoints []EndpointSliceEndpoint, ports []EndpointSlicePort, maxEndpoints int) []*EndpointSlice {
+	var slices []*EndpointSlice
+	for i := 0; i*maxEndpoints < len(endpoints); i++ {
+		end := (i + 1) * maxEndpoints
+		if end > len(endpoints) {
+			end = len(endpoints)
+		}
+		slices = append(slices, newEndpointSlice(service, fmt.Sprintf("%s-%d", prefix, first+i), family,
+			endpoints[i*maxEndpoints:end], ports))
+	}
+	return slices
+}
+
+func newEndpointSlice(service *v1.Service, name string, family IPFamily, endpoints []EndpointSliceEndpoint, ports []EndpointSlicePort) *EndpointSlice {
+	return &EndpointSlice{
+		TypeMeta: metaV1.TypeMeta{
+			APIVersion: EndpointSliceResource.GroupVersion().String(),
+			Kind:       "EndpointSlice",
+		},
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      name,
+			Namespace: service.GetNamespace(),
+			Labels: map[string]string{
+				LabelServiceName:   service.GetName(),
+				LabelManagedBy:     LabelValueManagedBy,
+				LabelAnnotationKey: LabelValueTrue,
+			},
+			// EndpointSlices are removed by kubernetes garbage collection with the service
+			OwnerReferences: []metaV1.OwnerReference{
+				*metaV1.NewControllerRef(service, v1.SchemeGroupVersion.WithKind("Service")),
+			},
+		},
+		AddressType: string(family),
+		Endpoints:   endpoints,
+		Ports:       ports,
+	}
+}
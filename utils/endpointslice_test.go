@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"testing"
+
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	discoveryfake "k8s.io/client-go/discovery/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestDetectEndpointSliceVersion(t *testing.T) {
+	tests := []struct {
+		name          string
+		groupVersions []string
+		want          string
+		wantErr       bool
+	}{
+		{"V1", []string{"v1", "discovery.k8s.io/v1beta1", "discovery.k8s.io/v1"}, "v1", false},
+		{"V1beta1", []string{"v1", "discovery.k8s.io/v1beta1"}, "v1beta1", false},
+		{"Unsupported", []string{"v1", "discovery.k8s.io/v1alpha1"}, "", true},
+		{"NotServed", []string{"v1"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &discoveryfake.FakeDiscovery{Fake: &k8stesting.Fake{}}
+			for _, gv := range tt.groupVersions {
+				client.Resources = append(client.Resources, &metaV1.APIResourceList{GroupVersion: gv})
+			}
+			got, err := DetectEndpointSliceVersion(client)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DetectEndpointSliceVersion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("DetectEndpointSliceVersion() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetEndpointSliceVersion(t *testing.T) {
+	defer SetEndpointSliceVersion("v1")
+	if err := SetEndpointSliceVersion("v1beta1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := EndpointSliceResource.GroupVersion().String(); got != "discovery.k8s.io/v1beta1" {
+		t.Errorf("EndpointSliceResource = %s, want discovery.k8s.io/v1beta1", got)
+	}
+	if err := SetEndpointSliceVersion("v2"); err == nil {
+		t.Error("SetEndpointSliceVersion() expected error for unsupported version")
+	}
+}
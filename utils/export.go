@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"fmt"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Exports decides which remote services are mirrored in opt-in mode, see IsExported
+var Exports = &exportFilter{selector: labels.Nothing()}
+
+// exportFilter holds the opt-in mode settings, they may be replaced on config reload
+type exportFilter struct {
+	lock     sync.RWMutex
+	optIn    bool
+	selector labels.Selector
+}
+
+// Replace enables (optIn) or disables opt-in mode. In opt-in mode, remote services matching selector
+// (a label selector, empty matches nothing) are mirrored in addition to the ones annotated with ExportAnnotation.
+func (e *exportFilter) Replace(optIn bool, selector string) error {
+	s := labels.Nothing()
+	if selector != "" {
+		var err error
+		if s, err = labels.Parse(selector); err != nil {
+			return fmt.Errorf("invalid export selector %q: %v", selector, err)
+		}
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.optIn = optIn
+	e.selector = s
+	return nil
+}
+
+// OptIn checks if opt-in mode is enabled
+func (e *exportFilter) OptIn() bool {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.optIn
+}
+
+// IsExported checks if service should be mirrored: opt-in mode is disabled, service is annotated with
+// ExportAnnotation or its labels match the export selector.
+func IsExported(service *v1.Service) bool {
+	if service == nil {
+		return false
+	}
+	Exports.lock.RLock()
+	defer Exports.lock.RUnlock()
+	if !Exports.optIn {
+		return true
+	}
+	if service.Annotations[LabelAnnotationKey] == AnnotationValueExport {
+		return true
+	}
+	return Exports.selector.Matches(labels.Set(service.Labels))
+}
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIsExported(t *testing.T) {
+	defer Exports.Replace(false, "")
+	tests := []struct {
+		name        string
+		optIn       bool
+		selector    string
+		labels      map[string]string
+		annotations map[string]string
+		want        bool
+	}{
+		{"OptOut", false, "", nil, nil, true},
+		{"OptOutIgnoresSelector", false, "export=true", nil, nil, true},
+		{"NotExported", true, "", nil, nil, false},
+		{"Annotation", true, "", nil, ExportAnnotation, true},
+		{"OtherAnnotationValue", true, "", nil, map[string]string{LabelAnnotationKey: "true"}, false},
+		{"ExportLabelOnly", true, "", ExportAnnotation, nil, false},
+		{"Selector", true, "export=true", map[string]string{"export": "true"}, nil, true},
+		{"SelectorMismatch", true, "export=true", map[string]string{"export": "false"}, nil, false},
+		{"SetBasedSelector", true, "tier in (frontend,api)", map[string]string{"tier": "api"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Exports.Replace(tt.optIn, tt.selector); err != nil {
+				t.Fatal(err)
+			}
+			service := &v1.Service{ObjectMeta: metaV1.ObjectMeta{Labels: tt.labels, Annotations: tt.annotations}}
+			if got := IsExported(service); got != tt.want {
+				t.Errorf("IsExported() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExportsReplaceInvalid(t *testing.T) {
+	defer Exports.Replace(false, "")
+	if err := Exports.Replace(true, "export in ("); err == nil {
+		t.Error("Expected error for invalid selector")
+	}
+	if Exports.OptIn() {
+		t.Error("Expected opt-in mode to be unchanged after error")
+	}
+}
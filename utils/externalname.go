@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"text/template"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// ExternalNameData is passed to the hostname template of ExternalName services
+type ExternalNameData struct {
+	// Name and Namespace of the remote service
+	Name      string
+	Namespace string
+	// Cluster is the name of the remote cluster
+	Cluster string
+}
+
+// mirrorExternalName is 1 if remote services should be mirrored as ExternalName services, see SetMirrorExternalName
+var mirrorExternalName int32
+
+// SetMirrorExternalName enables or disables mirroring of remote services as local ExternalName services.
+// Single services may opt in or out via ExternalNameAnnotation.
+func SetMirrorExternalName(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&mirrorExternalName, value)
+}
+
+// IsExternalNameMirrored checks if service should be mirrored as local ExternalName service (pointing to a
+// hostname, see ExternalNameHostname): ExternalNameAnnotation is "true" or ExternalName mode is enabled globally
+// and the annotation is not "false". Remote ExternalName services are never mirrored.
+func IsExternalNameMirrored(service *v1.Service) bool {
+	if service == nil || service.Spec.Type == v1.ServiceTypeExternalName {
+		return false
+	}
+	switch service.Annotations[ExternalNameAnnotation] {
+	case LabelValueTrue:
+		return true
+	case "false":
+		return false
+	}
+	return atomic.LoadInt32(&mirrorExternalName) == 1
+}
+
+// ExternalNameHostname renders the hostname template text (see ExternalNameData) for a remote service
+func ExternalNameHostname(text string, service *v1.Service, cluster string) (string, error) {
+	if text == "" {
+		return "", fmt.Errorf("no externalName template configured")
+	}
+	return executeExternalNameTemplate(text, ExternalNameData{
+		Name:      service.GetName(),
+		Namespace: service.GetNamespace(),
+		Cluster:   cluster,
+	})
+}
+
+// ValidateExternalNameTemplate checks if text is a valid hostname template
+func ValidateExternalNameTemplate(text string) error {
+	_, err := executeExternalNameTemplate(text, ExternalNameData{Name: "name", Namespace: "namespace", Cluster: "cluster"})
+	return err
+}
+
+func executeExternalNameTemplate(text string, data ExternalNameData) (string, error) {
+	tmpl, err := template.New("externalName").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid externalName template %q: %v", text, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("invalid externalName template %q: %v", text, err)
+	}
+	hostname := strings.ToLower(buf.String())
+	if errs := validation.IsDNS1123Subdomain(hostname); len(errs) > 0 {
+		return "", fmt.Errorf("invalid externalName %q: %s", hostname, strings.Join(errs, ", "))
+	}
+	return hostname, nil
+}
@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestExternalNameHostname(t *testing.T) {
+	service := &v1.Service{ObjectMeta: metaV1.ObjectMeta{Name: "foo", Namespace: "Bar"}}
+	tests := []struct {
+		name     string
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{"NameNamespace", "{{.Name}}.{{.Namespace}}.remote.example.com", "foo.bar.remote.example.com", false},
+		{"Cluster", "{{.Name}}.{{.Namespace}}.{{.Cluster}}.example.com", "foo.bar.eu.example.com", false},
+		{"Empty", "", "", true},
+		{"InvalidTemplate", "{{.Name", "", true},
+		{"UnknownField", "{{.Foo}}.example.com", "", true},
+		{"InvalidHostname", "{{.Name}}_{{.Namespace}}", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExternalNameHostname(tt.template, service, "eu")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ExternalNameHostname() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ExternalNameHostname() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsExternalNameMirrored(t *testing.T) {
+	defer SetMirrorExternalName(false)
+	tests := []struct {
+		name    string
+		global  bool
+		service *v1.Service
+		want    bool
+	}{
+		{"Disabled", false, &v1.Service{}, false},
+		{"Enabled", true, &v1.Service{}, true},
+		{"RemoteExternalName", true, &v1.Service{Spec: v1.ServiceSpec{Type: v1.ServiceTypeExternalName}}, false},
+		{"nil", true, nil, false},
+		{
+			"OptIn",
+			false,
+			&v1.Service{ObjectMeta: metaV1.ObjectMeta{Annotations: map[string]string{ExternalNameAnnotation: "true"}}},
+			true,
+		},
+		{
+			"OptOut",
+			true,
+			&v1.Service{ObjectMeta: metaV1.ObjectMeta{Annotations: map[string]string{ExternalNameAnnotation: "false"}}},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetMirrorExternalName(tt.global)
+			if got := IsExternalNameMirrored(tt.service); got != tt.want {
+				t.Errorf("IsExternalNameMirrored() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,7 +2,10 @@ package utils
 
 import (
 	"fmt"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
 )
 
 var (
@@ -10,25 +13,87 @@ var (
 		// List namespaces ignored by default here
 		"kube-system": struct{}{},
 	}
+
+	// IncludedNamespaces restricts the mirrored remote namespaces, all namespaces are included if it's empty
+	IncludedNamespaces = &namespaceMap{}
 )
 
+// namespaceMapLock guards all namespaceMaps as IgnoredNamespaces may be replaced on config reload
+var namespaceMapLock sync.RWMutex
+
+// namespaceMap is a set of namespaces and namespace patterns: globs containing "*" or "?" (like "ci-*") and
+// regular expressions prefixed with "~" (like "~^preview-[0-9]+$"), see ValidateNamespacePattern
 type namespaceMap map[string]struct{}
 
+// namespaceRegexps caches the compiled regular expressions of namespace patterns
+var namespaceRegexps sync.Map
+
+// IsNamespacePattern checks if v is a glob or regular expression instead of a namespace name
+func IsNamespacePattern(v string) bool {
+	return strings.HasPrefix(v, "~") || strings.ContainsAny(v, "*?")
+}
+
+// compileNamespacePattern returns the regular expression matching the namespaces of a pattern.
+// Regular expressions have to match the whole namespace.
+func compileNamespacePattern(pattern string) (*regexp.Regexp, error) {
+	if regex, ok := namespaceRegexps.Load(pattern); ok {
+		return regex.(*regexp.Regexp), nil
+	}
+	var expr string
+	if strings.HasPrefix(pattern, "~") {
+		expr = "^(?:" + strings.TrimPrefix(pattern, "~") + ")$"
+	} else {
+		expr = regexp.QuoteMeta(pattern)
+		expr = strings.Replace(expr, `\*`, ".*", -1)
+		expr = "^" + strings.Replace(expr, `\?`, ".", -1) + "$"
+	}
+	regex, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid namespace pattern %q: %v", pattern, err)
+	}
+	namespaceRegexps.Store(pattern, regex)
+	return regex, nil
+}
+
+// ValidateNamespacePattern checks if v is a namespace or a valid namespace pattern
+func ValidateNamespacePattern(v string) error {
+	if v == "" || v == "~" {
+		return fmt.Errorf("empty string not allowed as namespace")
+	}
+	if !IsNamespacePattern(v) {
+		return nil
+	}
+	_, err := compileNamespacePattern(v)
+	return err
+}
+
 func (n namespaceMap) String() string {
+	return strings.Join(n.List(), ",")
+}
+
+// List returns the sorted list of namespaces
+func (n namespaceMap) List() []string {
+	namespaceMapLock.RLock()
+	defer namespaceMapLock.RUnlock()
+
 	ns := make([]string, len(n))
 	i := 0
 	for k := range n {
 		ns[i] = k
 		i++
 	}
-	return strings.Join(ns, ",")
+	sort.Strings(ns)
+	return ns
 }
 
 func (n namespaceMap) Set(v string) error {
-	if v == "" {
-		return fmt.Errorf("empty string not allowed as namespace")
+	if err := ValidateNamespacePattern(strings.TrimPrefix(v, "-")); err != nil {
+		return err
 	}
 
+	namespaceMapLock.Lock()
+	defer namespaceMapLock.Unlock()
+
 	if strings.HasPrefix(v, "-") {
 		// Remove strings prefixed with a dash from map
 		delete(n, strings.TrimPrefix(v, "-"))
@@ -39,7 +104,53 @@ func (n namespaceMap) Set(v string) error {
 	return nil
 }
 
+// Replace replaces all namespaces with the given ones
+func (n namespaceMap) Replace(namespaces []string) error {
+	for _, ns := range namespaces {
+		if err := ValidateNamespacePattern(ns); err != nil {
+			return err
+		}
+	}
+
+	namespaceMapLock.Lock()
+	defer namespaceMapLock.Unlock()
+	for k := range n {
+		delete(n, k)
+	}
+	for _, ns := range namespaces {
+		n[ns] = struct{}{}
+	}
+	return nil
+}
+
+// IsIgnored checks if ns is one of the namespaces or matches one of the patterns
 func (n namespaceMap) IsIgnored(ns string) bool {
-	_, ignored := n[ns]
-	return ignored
+	namespaceMapLock.RLock()
+	defer namespaceMapLock.RUnlock()
+	return n.matches(ns)
+}
+
+// IsIncluded checks if ns is one of the namespaces or matches one of the patterns, every namespace is
+// included if there are none
+func (n namespaceMap) IsIncluded(ns string) bool {
+	namespaceMapLock.RLock()
+	defer namespaceMapLock.RUnlock()
+	return len(n) == 0 || n.matches(ns)
+}
+
+// matches checks if ns is one of the namespaces or matches one of the patterns, namespaceMapLock has to be held
+func (n namespaceMap) matches(ns string) bool {
+	if _, ok := n[ns]; ok {
+		return true
+	}
+	for pattern := range n {
+		if !IsNamespacePattern(pattern) {
+			continue
+		}
+		// Patterns are validated when they are added
+		if regex, err := compileNamespacePattern(pattern); err == nil && regex.MatchString(ns) {
+			return true
+		}
+	}
+	return false
 }
@@ -67,3 +67,48 @@ func Test_namespaceMap_Set(t *testing.T) {
 		})
 	}
 }
+
+func Test_namespaceMap_matches(t *testing.T) {
+	tests := []struct {
+		name         string
+		values       []string
+		wantErr      bool
+		namespace    string
+		wantIgnored  bool
+		wantIncluded bool
+	}{
+		{"Empty", nil, false, "foo", false, true},
+		{"Exact", []string{"foo"}, false, "foo", true, true},
+		{"ExactMismatch", []string{"foo"}, false, "foo-bar", false, false},
+		{"Glob", []string{"ci-*"}, false, "ci-1234", true, true},
+		{"GlobMismatch", []string{"ci-*"}, false, "preview-ci-1234", false, false},
+		{"GlobQuestionMark", []string{"team-?"}, false, "team-a", true, true},
+		{"GlobDot", []string{"a.*"}, false, "abc", false, false},
+		{"Regex", []string{"~preview-[0-9]+"}, false, "preview-42", true, true},
+		{"RegexWholeNamespace", []string{"~preview-[0-9]+"}, false, "preview-42-old", false, false},
+		{"RegexAlternation", []string{"~ci|preview"}, false, "preview", true, true},
+		{"InvalidRegex", []string{"~preview-("}, true, "preview-(", false, true},
+		{"EmptyRegex", []string{"~"}, true, "", false, true},
+		{"RemovePattern", []string{"ci-*", "-ci-*"}, false, "ci-1", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := namespaceMap{}
+			var err error
+			for _, v := range tt.values {
+				if setErr := n.Set(v); setErr != nil {
+					err = setErr
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Set() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := n.IsIgnored(tt.namespace); got != tt.wantIgnored {
+				t.Errorf("IsIgnored() = %v, want %v", got, tt.wantIgnored)
+			}
+			if got := n.IsIncluded(tt.namespace); got != tt.wantIncluded {
+				t.Errorf("IsIncluded() = %v, want %v", got, tt.wantIncluded)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// IPFamily is the family of a IP address. The values match the address types of EndpointSlices.
+type IPFamily string
+
+const (
+	IPv4 IPFamily = "IPv4"
+	IPv6 IPFamily = "IPv6"
+)
+
+// DefaultIPFamilies are the families used for services without a cluster IP, if nothing else is configured
+var DefaultIPFamilies = []IPFamily{IPv4}
+
+// IPFamilyOf returns the family of ip. ok is false if ip is not a valid IP address.
+func IPFamilyOf(ip string) (family IPFamily, ok bool) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", false
+	}
+	if parsed.To4() != nil {
+		return IPv4, true
+	}
+	return IPv6, true
+}
+
+// ValidateIPFamilies checks if families contains only known families without duplicates
+func ValidateIPFamilies(families []IPFamily) error {
+	if len(families) == 0 {
+		return fmt.Errorf("no IP families given")
+	}
+	seen := make(map[IPFamily]bool, len(families))
+	for _, family := range families {
+		if family != IPv4 && family != IPv6 {
+			return fmt.Errorf("invalid IP family %q (must be %s or %s)", family, IPv4, IPv6)
+		}
+		if seen[family] {
+			return fmt.Errorf("duplicate IP family %q", family)
+		}
+		seen[family] = true
+	}
+	return nil
+}
+
+// ParseIPFamilies parses a comma separated list of IP families (e.g. "IPv4,IPv6")
+func ParseIPFamilies(value string) ([]IPFamily, error) {
+	var families []IPFamily
+	for _, family := range strings.Split(value, ",") {
+		families = append(families, IPFamily(strings.TrimSpace(family)))
+	}
+	return families, ValidateIPFamilies(families)
+}
+
+// ServiceIPFamilies returns the IP families endpoints of a (local) service need addresses of:
+// The IPFamiliesAnnotation if set, the family of the cluster IP if the service has one, defaults otherwise.
+func ServiceIPFamilies(service *v1.Service, defaults []IPFamily) ([]IPFamily, error) {
+	if value, ok := service.Annotations[IPFamiliesAnnotation]; ok {
+		families, err := ParseIPFamilies(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP families annotation on service %s/%s: %v",
+				service.GetNamespace(), service.GetName(), err)
+		}
+		return families, nil
+	}
+	if family, ok := IPFamilyOf(service.Spec.ClusterIP); ok {
+		return []IPFamily{family}, nil
+	}
+	return defaults, nil
+}
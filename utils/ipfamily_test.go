@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIPFamilyOf(t *testing.T) {
+	tests := []struct {
+		ip     string
+		want   IPFamily
+		wantOk bool
+	}{
+		{"10.0.0.1", IPv4, true},
+		{"fd00::1", IPv6, true},
+		{"::ffff:10.0.0.1", IPv4, true},
+		{"None", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			got, ok := IPFamilyOf(tt.ip)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("IPFamilyOf() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestServiceIPFamilies(t *testing.T) {
+	tests := []struct {
+		name        string
+		clusterIP   string
+		annotations map[string]string
+		want        []IPFamily
+		wantErr     bool
+	}{
+		{"ClusterIPv4", "10.96.0.10", nil, []IPFamily{IPv4}, false},
+		{"ClusterIPv6", "fd00:10:96::a", nil, []IPFamily{IPv6}, false},
+		{"Headless", v1.ClusterIPNone, nil, DefaultIPFamilies, false},
+		{"Annotation", "10.96.0.10", map[string]string{IPFamiliesAnnotation: "IPv6, IPv4"}, []IPFamily{IPv6, IPv4}, false},
+		{"InvalidAnnotation", "", map[string]string{IPFamiliesAnnotation: "IPv5"}, nil, true},
+		{"DuplicateAnnotation", "", map[string]string{IPFamiliesAnnotation: "IPv4,IPv4"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &v1.Service{
+				ObjectMeta: metaV1.ObjectMeta{Annotations: tt.annotations},
+				Spec:       v1.ServiceSpec{ClusterIP: tt.clusterIP},
+			}
+			got, err := ServiceIPFamilies(service, DefaultIPFamilies)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ServiceIPFamilies() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ServiceIPFamilies() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
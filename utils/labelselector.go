@@ -1,14 +1,18 @@
 package utils
 
 import (
+	"fmt"
+	"strings"
+
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
 
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 const (
-	// LabelAnnotationKey is the key used for all barrelman specific labels and annotations
-	LabelAnnotationKey = "tfw.io/barrelman"
+	// DefaultLabelAnnotationKey is the default for LabelAnnotationKey
+	DefaultLabelAnnotationKey = "tfw.io/barrelman"
 	// LabelValueManagedResource is the label value to declare a resource as managed by barrelman (e.g. services)
 	LabelValueManagedResource = "managed-resource"
 	// LabelValueTrue is the label value used to mark service objects where barrelman should manage endpoints for
@@ -16,35 +20,109 @@ const (
 	// AnnotationValueIgnore is the annotation value used to tell barrelman to ignore a certain service
 	// (ServiceController)
 	AnnotationValueIgnore = "ignore"
+	// AnnotationValueExport is the annotation value used to tell barrelman to mirror a certain service in
+	// opt-in mode (ServiceController), see IsExported
+	AnnotationValueExport = "export"
 )
 
 var (
+	// LabelAnnotationKey is the key used for all barrelman specific labels and annotations.
+	// Use SetLabelAnnotationKey to change it.
+	LabelAnnotationKey = DefaultLabelAnnotationKey
+
 	// Label to be manually put on services their endpoints should be managed by barrelman.
 	// (NodeEndpointController)
-	ServiceLabel         = map[string]string{LabelAnnotationKey: LabelValueTrue}
-	ServiceLabelSelector = metaV1.LabelSelector{
-		MatchExpressions: []metaV1.LabelSelectorRequirement{{
-			Key:      LabelAnnotationKey,
-			Operator: metaV1.LabelSelectorOpIn,
-			Values:   []string{LabelValueTrue, LabelValueManagedResource},
-		}},
-	}
-	// ServiceSelector is converted from ServiceLabelSelector by init()
+	ServiceLabel         map[string]string
+	ServiceLabelSelector metaV1.LabelSelector
+	// ServiceSelector is converted from ServiceLabelSelector by SetLabelAnnotationKey
 	ServiceSelector labels.Selector
 
 	// Label for (dummy) services managed by barrelman. (ServiceController)
-	ResourceLabel = map[string]string{LabelAnnotationKey: LabelValueManagedResource}
+	ResourceLabel map[string]string
 
 	// Annotation to be placed on service objects that should be ignored by barrelman
 	// E.g. no dummy services are created for. (ServiceController)
-	IgnoreAnnotation = map[string]string{LabelAnnotationKey: AnnotationValueIgnore}
+	IgnoreAnnotation map[string]string
+
+	// Annotation to be placed on remote service objects that should be mirrored in opt-in mode (ServiceController)
+	ExportAnnotation map[string]string
+
+	// Annotation to configure how the node ports of a service are probed (NodeEndpointController), see ParseProbeSpec
+	ProbeAnnotation string
+
+	// Annotation to restrict the remote nodes used as endpoints of a service (NodeEndpointController).
+	// The value is a label selector, it is combined with the global node selector.
+	NodeSelectorAnnotation string
+
+	// Annotation to override the node address types used for a service (NodeEndpointController),
+	// see ParseNodeAddressTypes
+	NodeAddressTypesAnnotation string
+
+	// Annotation to override the IP families of the endpoints of a (local) service (NodeEndpointController),
+	// see ParseIPFamilies
+	IPFamiliesAnnotation string
+
+	// Annotation on remote LoadBalancer services to opt in ("true") or out ("false") of being mirrored via
+	// their load balancer IPs, see IsLoadBalancerMirrored
+	LoadBalancerAnnotation string
+
+	// Annotation on remote services to opt out ("false") of pod IP mode, see IsPodIPMirrored
+	PodIPsAnnotation string
+
+	// Annotation on remote services to opt in ("true") or out ("false") of being mirrored as ExternalName
+	// service, see IsExternalNameMirrored
+	ExternalNameAnnotation string
+
+	// Annotation on remote services to select (and renumber) the mirrored ports, see ParsePortSelection
+	PortsAnnotation string
 )
 
 func init() {
+	if err := SetLabelAnnotationKey(DefaultLabelAnnotationKey); err != nil {
+		panic(err)
+	}
+}
+
+// SetLabelAnnotationKey changes LabelAnnotationKey and all labels, annotations and selectors derived from it.
+// It is not safe to call this while informers or controllers are running.
+func SetLabelAnnotationKey(key string) error {
+	if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+		return fmt.Errorf("invalid label/annotation key %q: %s", key, strings.Join(errs, ", "))
+	}
+	// Status annotations are derived from key, ensure the longest one is valid as well
+	if errs := validation.IsQualifiedName(key + statusSuffixRemoteResourceVersion); len(errs) > 0 {
+		return fmt.Errorf("invalid label/annotation key %q (too long for status annotations): %s",
+			key, strings.Join(errs, ", "))
+	}
+
+	serviceLabelSelector := metaV1.LabelSelector{
+		MatchExpressions: []metaV1.LabelSelectorRequirement{{
+			Key:      key,
+			Operator: metaV1.LabelSelectorOpIn,
+			Values:   []string{LabelValueTrue, LabelValueManagedResource},
+		}},
+	}
 	// Ensure we can convert ServiceLabelSelector so label.Selector
-	var err error
-	ServiceSelector, err = metaV1.LabelSelectorAsSelector(&ServiceLabelSelector)
+	serviceSelector, err := metaV1.LabelSelectorAsSelector(&serviceLabelSelector)
 	if err != nil {
-		panic(err)
+		return err
 	}
+
+	LabelAnnotationKey = key
+	ServiceLabel = map[string]string{key: LabelValueTrue}
+	ServiceLabelSelector = serviceLabelSelector
+	ServiceSelector = serviceSelector
+	ResourceLabel = map[string]string{key: LabelValueManagedResource}
+	IgnoreAnnotation = map[string]string{key: AnnotationValueIgnore}
+	ExportAnnotation = map[string]string{key: AnnotationValueExport}
+	ProbeAnnotation = key + ".probe"
+	NodeSelectorAnnotation = key + ".node-selector"
+	NodeAddressTypesAnnotation = key + ".node-address-types"
+	IPFamiliesAnnotation = key + ".ip-families"
+	LoadBalancerAnnotation = key + ".load-balancer"
+	PodIPsAnnotation = key + ".pod-ips"
+	ExternalNameAnnotation = key + ".external-name"
+	PortsAnnotation = key + ".ports"
+	setStatusAnnotationKeys(key)
+	return nil
 }
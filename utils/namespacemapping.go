@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// NamespaceMapping maps remote namespaces to local namespaces
+type NamespaceMapping struct {
+	// Remote is a namespace (explicit pair), a pattern containing a single "*" (like "*-prod" or "eu-*")
+	// or a regular expression matching the whole namespace (if Regex is set)
+	Remote string `json:"remote"`
+	// Local is the local namespace. A "*" is replaced with the part of the remote namespace matched by "*",
+	// for regular expressions, submatches are expanded ($1 or ${name}).
+	Local string `json:"local"`
+	// Regex declares Remote a regular expression
+	Regex bool `json:"regex,omitempty"`
+}
+
+// NamespaceMappingList is a flag.Value collecting namespace mappings like remote=local, *-prod=* or
+// ~^prod-(.*)$=$1 (regular expression).
+type NamespaceMappingList []NamespaceMapping
+
+func (l *NamespaceMappingList) String() string {
+	mappings := make([]string, len(*l))
+	for i, m := range *l {
+		mappings[i] = m.String()
+	}
+	return strings.Join(mappings, ",")
+}
+
+func (l *NamespaceMappingList) Set(v string) error {
+	idx := strings.LastIndex(v, "=")
+	if idx < 1 {
+		return fmt.Errorf("invalid namespace mapping %q, expected remote=local", v)
+	}
+	m := NamespaceMapping{Remote: v[:idx], Local: v[idx+1:]}
+	if strings.HasPrefix(m.Remote, "~") {
+		m.Remote = strings.TrimPrefix(m.Remote, "~")
+		m.Regex = true
+	}
+	if _, err := compileNamespaceMapping(m); err != nil {
+		return err
+	}
+	*l = append(*l, m)
+	return nil
+}
+
+func (m NamespaceMapping) String() string {
+	if m.Regex {
+		return "~" + m.Remote + "=" + m.Local
+	}
+	return m.Remote + "=" + m.Local
+}
+
+// namespaceRule is a compiled NamespaceMapping
+type namespaceRule struct {
+	regex    *regexp.Regexp
+	template string
+}
+
+// compileNamespaceMapping converts all kinds of mappings to a regular expression and an expansion template
+func compileNamespaceMapping(m NamespaceMapping) (namespaceRule, error) {
+	if m.Remote == "" || m.Local == "" {
+		return namespaceRule{}, fmt.Errorf("invalid namespace mapping %q: remote and local must not be empty", m)
+	}
+	if m.Regex {
+		regex, err := regexp.Compile("^(?:" + m.Remote + ")$")
+		if err != nil {
+			return namespaceRule{}, fmt.Errorf("invalid namespace mapping %q: %v", m, err)
+		}
+		return namespaceRule{regex: regex, template: m.Local}, nil
+	}
+
+	if strings.Count(m.Remote, "*") > 1 || strings.Count(m.Local, "*") > 1 {
+		return namespaceRule{}, fmt.Errorf("invalid namespace mapping %q: only a single * is allowed", m)
+	}
+	if strings.Contains(m.Local, "*") && !strings.Contains(m.Remote, "*") {
+		return namespaceRule{}, fmt.Errorf("invalid namespace mapping %q: local contains * but remote doesn't", m)
+	}
+	// Check the local namespace (with a placeholder for *)
+	if errs := validation.IsDNS1123Label(strings.Replace(m.Local, "*", "x", 1)); len(errs) > 0 {
+		return namespaceRule{}, fmt.Errorf("invalid namespace mapping %q: %s", m, strings.Join(errs, ", "))
+	}
+	parts := strings.SplitN(m.Remote, "*", 2)
+	pattern := regexp.QuoteMeta(parts[0])
+	if len(parts) == 2 {
+		pattern += "(.+)" + regexp.QuoteMeta(parts[1])
+	}
+	template := strings.Replace(strings.Replace(m.Local, "$", "$$", -1), "*", "${1}", 1)
+	return namespaceRule{regex: regexp.MustCompile("^" + pattern + "$"), template: template}, nil
+}
+
+// ValidateNamespaceMappings checks all mappings and if explicit pairs map different remote namespaces to the
+// same local namespace (collisions of patterns and regular expressions can only be detected at runtime)
+func ValidateNamespaceMappings(mappings []NamespaceMapping) error {
+	locals := make(map[string]string)
+	for _, m := range mappings {
+		if _, err := compileNamespaceMapping(m); err != nil {
+			return err
+		}
+		if m.Regex || strings.Contains(m.Remote, "*") {
+			continue
+		}
+		if remote, ok := locals[m.Local]; ok && remote != m.Remote {
+			return fmt.Errorf("namespaces %q and %q are both mapped to %q", remote, m.Remote, m.Local)
+		}
+		locals[m.Local] = m.Remote
+	}
+	return nil
+}
+
+var (
+	// NamespaceMappings maps remote to local namespaces, replaced on config reload
+	NamespaceMappings = &namespaceMapper{}
+)
+
+type namespaceMapper struct {
+	lock  sync.RWMutex
+	rules []namespaceRule
+}
+
+// Replace replaces all mappings with the given ones
+func (n *namespaceMapper) Replace(mappings []NamespaceMapping) error {
+	rules := make([]namespaceRule, len(mappings))
+	for i, m := range mappings {
+		rule, err := compileNamespaceMapping(m)
+		if err != nil {
+			return err
+		}
+		rules[i] = rule
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.rules = rules
+	return nil
+}
+
+// Enabled returns true if any mapping is configured
+func (n *namespaceMapper) Enabled() bool {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	return len(n.rules) > 0
+}
+
+// Local returns the local namespace of the remote namespace. The first matching mapping is used,
+// namespaces not matching any mapping are not changed.
+func (n *namespaceMapper) Local(remote string) string {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	for _, rule := range n.rules {
+		match := rule.regex.FindStringSubmatchIndex(remote)
+		if match == nil {
+			continue
+		}
+		return string(rule.regex.ExpandString(nil, rule.template, remote, match))
+	}
+	return remote
+}
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_namespaceMapper_Local(t *testing.T) {
+	mappings := []NamespaceMapping{
+		{Remote: "shop-prod", Local: "shop"},
+		{Remote: "*-prod", Local: "*"},
+		{Remote: "eu-*", Local: "remote-*"},
+		{Remote: "^team-([a-z]+)-(svc|api)$", Local: "$1", Regex: true},
+	}
+	tests := []struct {
+		remote string
+		want   string
+	}{
+		{"shop-prod", "shop"},
+		{"billing-prod", "billing"},
+		{"eu-billing", "remote-billing"},
+		{"team-foo-api", "foo"},
+		{"team-foo-web", "team-foo-web"},
+		{"unmapped", "unmapped"},
+		// The first matching mapping wins
+		{"eu-shop-prod", "eu-shop"},
+	}
+	n := &namespaceMapper{}
+	if err := n.Replace(mappings); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.remote, func(t *testing.T) {
+			if got := n.Local(tt.remote); got != tt.want {
+				t.Errorf("Local() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateNamespaceMappings(t *testing.T) {
+	tests := []struct {
+		name     string
+		mappings []NamespaceMapping
+		wantErr  bool
+	}{
+		{"Valid", []NamespaceMapping{{Remote: "a", Local: "b"}, {Remote: "*-prod", Local: "*"}}, false},
+		{"Empty", []NamespaceMapping{{Remote: "a"}}, true},
+		{"InvalidLocal", []NamespaceMapping{{Remote: "a", Local: "B_"}}, true},
+		{"MultipleWildcards", []NamespaceMapping{{Remote: "*-*", Local: "*"}}, true},
+		{"LocalWildcardOnly", []NamespaceMapping{{Remote: "a", Local: "*"}}, true},
+		{"InvalidRegex", []NamespaceMapping{{Remote: "(a", Local: "b", Regex: true}}, true},
+		{"Collision", []NamespaceMapping{{Remote: "a", Local: "c"}, {Remote: "b", Local: "c"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateNamespaceMappings(tt.mappings); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateNamespaceMappings() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNamespaceMappingList_Set(t *testing.T) {
+	var l NamespaceMappingList
+	for _, v := range []string{"shop-prod=shop", "*-prod=*", "~^prod-(.*)$=$1"} {
+		if err := l.Set(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := NamespaceMappingList{
+		{Remote: "shop-prod", Local: "shop"},
+		{Remote: "*-prod", Local: "*"},
+		{Remote: "^prod-(.*)$", Local: "$1", Regex: true},
+	}
+	if !reflect.DeepEqual(l, want) {
+		t.Errorf("Set() = %v, want %v", l, want)
+	}
+	if err := l.Set("foo"); err == nil {
+		t.Error("Set() expected error for value without =")
+	}
+}
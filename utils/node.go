@@ -2,10 +2,20 @@ package utils
 
 import (
 	"fmt"
+	"net"
+	"strings"
 
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
+// NodeAddressAnnotationPrefix prefixes node address types taking the address from a node annotation,
+// e.g. "annotation:example.com/public-ip"
+const NodeAddressAnnotationPrefix = "annotation:"
+
+// DefaultNodeAddressTypes are the node address types used if nothing else is configured
+var DefaultNodeAddressTypes = []string{string(v1.NodeInternalIP)}
+
 // GetNodeInternalIP extracts the external IP from nodes adresses
 func GetNodeInternalIP(node *v1.Node) (string, error) {
 	for _, x := range node.Status.Addresses {
@@ -35,3 +45,116 @@ func IsNodeReady(node *v1.Node) bool {
 	}
 	return ready
 }
+
+// NodeSelector returns the selector for the remote nodes to use as endpoints of a service.
+// global is combined with the NodeSelectorAnnotation of the first of services (nil is skipped) that has it set.
+func NodeSelector(global string, services ...*v1.Service) (labels.Selector, error) {
+	selector, err := labels.Parse(global)
+	if err != nil {
+		return nil, fmt.Errorf("invalid node selector %q: %v", global, err)
+	}
+	for _, service := range services {
+		if service == nil {
+			continue
+		}
+		value, ok := service.Annotations[NodeSelectorAnnotation]
+		if !ok {
+			continue
+		}
+		serviceSelector, err := labels.Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid node selector annotation %q on service %s/%s: %v",
+				value, service.GetNamespace(), service.GetName(), err)
+		}
+		requirements, _ := serviceSelector.Requirements()
+		return selector.Add(requirements...), nil
+	}
+	return selector, nil
+}
+
+// ValidateNodeAddressTypes checks if all types are v1.NodeAddressTypes or annotation references
+func ValidateNodeAddressTypes(types []string) error {
+	if len(types) == 0 {
+		return fmt.Errorf("no node address types given")
+	}
+	for _, t := range types {
+		if strings.HasPrefix(t, NodeAddressAnnotationPrefix) {
+			if strings.TrimPrefix(t, NodeAddressAnnotationPrefix) == "" {
+				return fmt.Errorf("node address type %q needs an annotation key", t)
+			}
+			continue
+		}
+		switch v1.NodeAddressType(t) {
+		case v1.NodeInternalIP, v1.NodeExternalIP, v1.NodeHostName, v1.NodeInternalDNS, v1.NodeExternalDNS:
+		default:
+			return fmt.Errorf("invalid node address type %q", t)
+		}
+	}
+	return nil
+}
+
+// ParseNodeAddressTypes parses a comma separated list of node address types (e.g. "ExternalIP,InternalIP")
+func ParseNodeAddressTypes(value string) ([]string, error) {
+	var types []string
+	for _, t := range strings.Split(value, ",") {
+		types = append(types, strings.TrimSpace(t))
+	}
+	return types, ValidateNodeAddressTypes(types)
+}
+
+// NodeAddressTypes returns the node address types to use for a service: The NodeAddressTypesAnnotation of the
+// first of services (nil is skipped) that has it set, global otherwise.
+func NodeAddressTypes(global []string, services ...*v1.Service) ([]string, error) {
+	for _, service := range services {
+		if service == nil {
+			continue
+		}
+		value, ok := service.Annotations[NodeAddressTypesAnnotation]
+		if !ok {
+			continue
+		}
+		types, err := ParseNodeAddressTypes(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid node address types annotation on service %s/%s: %v",
+				service.GetNamespace(), service.GetName(), err)
+		}
+		return types, nil
+	}
+	return global, nil
+}
+
+// GetNodeAddress returns the first IP address of node found via types (in order).
+// Addresses that are no IPs (e.g. a Hostname that is a DNS name) are skipped.
+func GetNodeAddress(node *v1.Node, types []string) (string, error) {
+	return getNodeAddress(node, types, func(string) bool { return true })
+}
+
+// GetNodeFamilyAddress returns the first IP address of the given family of node found via types (in order)
+func GetNodeFamilyAddress(node *v1.Node, types []string, family IPFamily) (string, error) {
+	address, err := getNodeAddress(node, types, func(ip string) bool {
+		f, _ := IPFamilyOf(ip)
+		return f == family
+	})
+	if err != nil {
+		return "", fmt.Errorf("%v (family %s)", err, family)
+	}
+	return address, nil
+}
+
+func getNodeAddress(node *v1.Node, types []string, match func(ip string) bool) (string, error) {
+	for _, t := range types {
+		if strings.HasPrefix(t, NodeAddressAnnotationPrefix) {
+			address := node.Annotations[strings.TrimPrefix(t, NodeAddressAnnotationPrefix)]
+			if net.ParseIP(address) != nil && match(address) {
+				return address, nil
+			}
+			continue
+		}
+		for _, x := range node.Status.Addresses {
+			if string(x.Type) == t && net.ParseIP(x.Address) != nil && match(x.Address) {
+				return x.Address, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("Could not find address of type %s for Node: %s", strings.Join(types, ", "), node.GetName())
+}
@@ -1,9 +1,11 @@
 package utils
 
 import (
+	"reflect"
 	"testing"
 
 	v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func TestGetNodeInternalIP(t *testing.T) {
@@ -171,3 +173,143 @@ func TestIsNodeReady(t *testing.T) {
 		})
 	}
 }
+
+func TestNodeSelector(t *testing.T) {
+	annotated := &v1.Service{
+		ObjectMeta: metaV1.ObjectMeta{Annotations: map[string]string{NodeSelectorAnnotation: "zone=a"}},
+	}
+	invalid := &v1.Service{
+		ObjectMeta: metaV1.ObjectMeta{Annotations: map[string]string{NodeSelectorAnnotation: "zone in (a"}},
+	}
+
+	tests := []struct {
+		name     string
+		global   string
+		services []*v1.Service
+		want     string
+		wantErr  bool
+	}{
+		{"Everything", "", []*v1.Service{nil, {}}, "", false},
+		{"Global", "pool=ingress", []*v1.Service{{}}, "pool=ingress", false},
+		{"Combined", "pool=ingress", []*v1.Service{nil, annotated}, "pool=ingress,zone=a", false},
+		{"InvalidGlobal", "pool in (", nil, "", true},
+		{"InvalidAnnotation", "", []*v1.Service{invalid}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NodeSelector(tt.global, tt.services...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NodeSelector() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && got.String() != tt.want {
+				t.Errorf("NodeSelector() = %q, want %q", got.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestGetNodeAddress(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:        "node",
+			Annotations: map[string]string{"example.com/public-ip": "5.6.7.8", "example.com/broken": "foo"},
+		},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{
+				{Type: v1.NodeHostName, Address: "node.example.com"},
+				{Type: v1.NodeInternalIP, Address: "10.0.0.1"},
+				{Type: v1.NodeExternalIP, Address: "1.2.3.4"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		types   []string
+		want    string
+		wantErr bool
+	}{
+		{"InternalIP", []string{"InternalIP"}, "10.0.0.1", false},
+		{"ExternalIP", []string{"ExternalIP", "InternalIP"}, "1.2.3.4", false},
+		{"Annotation", []string{"annotation:example.com/public-ip", "ExternalIP"}, "5.6.7.8", false},
+		{"Fallback", []string{"annotation:example.com/missing", "annotation:example.com/broken", "InternalIP"}, "10.0.0.1", false},
+		{"HostnameNoIP", []string{"Hostname", "ExternalIP"}, "1.2.3.4", false},
+		{"NotFound", []string{"ExternalDNS"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetNodeAddress(node, tt.types)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetNodeAddress() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("GetNodeAddress() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNodeAddressTypes(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{"InternalIP", []string{"InternalIP"}, false},
+		{"ExternalIP, annotation:example.com/ip", []string{"ExternalIP", "annotation:example.com/ip"}, false},
+		{"annotation:", nil, true},
+		{"PublicIP", nil, true},
+		{"", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseNodeAddressTypes(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseNodeAddressTypes() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseNodeAddressTypes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetNodeFamilyAddress(t *testing.T) {
+	node := &v1.Node{
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{
+				{Type: v1.NodeInternalIP, Address: "fd00::1"},
+				{Type: v1.NodeInternalIP, Address: "10.0.0.1"},
+				{Type: v1.NodeExternalIP, Address: "1.2.3.4"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		types   []string
+		family  IPFamily
+		want    string
+		wantErr bool
+	}{
+		{"IPv4", []string{"InternalIP"}, IPv4, "10.0.0.1", false},
+		{"IPv6", []string{"InternalIP"}, IPv6, "fd00::1", false},
+		{"TypeOrder", []string{"ExternalIP", "InternalIP"}, IPv4, "1.2.3.4", false},
+		{"NotFound", []string{"ExternalIP"}, IPv6, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetNodeFamilyAddress(node, tt.types, tt.family)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetNodeFamilyAddress() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("GetNodeFamilyAddress() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
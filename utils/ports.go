@@ -0,0 +1,221 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// PortSelection selects (and renumbers) the ports of a remote service that are mirrored, see ParsePortSelection
+type PortSelection struct {
+	// include lists the mirrored ports, all ports are mirrored if it's empty
+	include []portRule
+	// exclude lists the ports that are never mirrored
+	exclude []portRule
+}
+
+// portRule references a remote port by name or number (port, not node port). If localPort is set, the port of the
+// local service differs from the remote one.
+type portRule struct {
+	name      string
+	number    int32
+	localPort int32
+}
+
+func (r portRule) matches(port v1.ServicePort) bool {
+	if r.number != 0 {
+		return port.Port == r.number
+	}
+	return port.Name == r.name
+}
+
+// MirroredPort is a port of a remote service and the port number it's mirrored as
+type MirroredPort struct {
+	Remote v1.ServicePort
+	// Port is the port of the local service
+	Port int32
+}
+
+// parsePortNumber parses a valid port number (1-65535)
+func parsePortNumber(value string) (int32, error) {
+	number, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	if errs := validation.IsValidPortNum(int(number)); len(errs) > 0 {
+		return 0, fmt.Errorf("invalid port %s: %s", value, strings.Join(errs, ", "))
+	}
+	return int32(number), nil
+}
+
+// ParsePortSelection parses the value of PortsAnnotation: a comma separated list of remote ports, referenced by
+// name or number. Ports prefixed with "-" are excluded, the others are included (all ports are included if
+// there are none). An included port may be mirrored with a different number (e.g. "http=8080").
+// An empty value selects all ports.
+func ParsePortSelection(value string) (*PortSelection, error) {
+	selection := &PortSelection{}
+	if strings.TrimSpace(value) == "" {
+		return selection, nil
+	}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		exclude := strings.HasPrefix(entry, "-")
+		entry = strings.TrimPrefix(entry, "-")
+
+		var rule portRule
+		ref := entry
+		if idx := strings.Index(entry, "="); idx >= 0 {
+			if exclude {
+				return nil, fmt.Errorf("excluded port %q can't be renumbered", entry)
+			}
+			ref = entry[:idx]
+			localPort, err := parsePortNumber(entry[idx+1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid local port of %q: %v", entry, err)
+			}
+			rule.localPort = localPort
+		}
+		if ref == "" {
+			return nil, fmt.Errorf("invalid port selection %q: empty port", value)
+		}
+		if ref[0] >= '0' && ref[0] <= '9' {
+			number, err := parsePortNumber(ref)
+			if err != nil {
+				return nil, fmt.Errorf("invalid port selection %q: %v", value, err)
+			}
+			rule.number = number
+		} else {
+			rule.name = ref
+		}
+
+		if exclude {
+			selection.exclude = append(selection.exclude, rule)
+		} else {
+			selection.include = append(selection.include, rule)
+		}
+	}
+	return selection, nil
+}
+
+// Select returns the selected ports (in the order of ports) and their local port numbers.
+// An error is returned if no port is selected or two ports would get the same local port (and protocol).
+func (s *PortSelection) Select(ports []v1.ServicePort) ([]MirroredPort, error) {
+	var selected []MirroredPort
+	seen := make(map[string]string)
+	for _, port := range ports {
+		if s.excluded(port) {
+			continue
+		}
+		localPort := port.Port
+		if len(s.include) > 0 {
+			rule, ok := s.included(port)
+			if !ok {
+				continue
+			}
+			if rule.localPort != 0 {
+				localPort = rule.localPort
+			}
+		}
+		key := fmt.Sprintf("%d/%s", localPort, port.Protocol)
+		if other, ok := seen[key]; ok {
+			return nil, fmt.Errorf("ports %q and %q are both mirrored as port %d", other, port.Name, localPort)
+		}
+		seen[key] = port.Name
+		selected = append(selected, MirroredPort{Remote: port, Port: localPort})
+	}
+	if len(selected) == 0 && len(ports) > 0 {
+		return nil, fmt.Errorf("no ports selected")
+	}
+	return selected, nil
+}
+
+func (s *PortSelection) excluded(port v1.ServicePort) bool {
+	for _, rule := range s.exclude {
+		if rule.matches(port) {
+			return true
+		}
+	}
+	return false
+}
+
+// included returns the first include rule matching port
+func (s *PortSelection) included(port v1.ServicePort) (portRule, bool) {
+	for _, rule := range s.include {
+		if rule.matches(port) {
+			return rule, true
+		}
+	}
+	return portRule{}, false
+}
+
+// ValidatePortSelections checks port selections keyed by remote service (namespace/name)
+func ValidatePortSelections(selections map[string]string) error {
+	for key, value := range selections {
+		if parts := strings.Split(key, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("invalid service %q, expected namespace/name", key)
+		}
+		if _, err := ParsePortSelection(value); err != nil {
+			return fmt.Errorf("service %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+var (
+	// PortSelections holds the configured port selections of remote services, replaced on config reload.
+	// PortsAnnotation on the remote service takes precedence.
+	PortSelections = &portSelector{}
+)
+
+type portSelector struct {
+	lock       sync.RWMutex
+	selections map[string]*PortSelection
+}
+
+// Replace replaces all port selections with the given ones (keyed by remote service namespace/name)
+func (p *portSelector) Replace(selections map[string]string) error {
+	if err := ValidatePortSelections(selections); err != nil {
+		return err
+	}
+	parsed := make(map[string]*PortSelection, len(selections))
+	for key, value := range selections {
+		parsed[key], _ = ParsePortSelection(value)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.selections = parsed
+	return nil
+}
+
+// get returns the configured selection of a remote service, nil if there is none
+func (p *portSelector) get(namespace, name string) *PortSelection {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.selections[namespace+"/"+name]
+}
+
+// MirroredPorts returns the ports of remoteService that are mirrored, selected via PortsAnnotation on
+// remoteService or PortSelections. All ports are mirrored as they are by default.
+func MirroredPorts(remoteService *v1.Service) ([]MirroredPort, error) {
+	selection := PortSelections.get(remoteService.GetNamespace(), remoteService.GetName())
+	if value, ok := remoteService.Annotations[PortsAnnotation]; ok {
+		var err error
+		if selection, err = ParsePortSelection(value); err != nil {
+			return nil, fmt.Errorf("invalid annotation %s of service %s/%s: %v", PortsAnnotation,
+				remoteService.GetNamespace(), remoteService.GetName(), err)
+		}
+	}
+	if selection == nil {
+		selection = &PortSelection{}
+	}
+	ports, err := selection.Select(remoteService.Spec.Ports)
+	if err != nil {
+		return nil, fmt.Errorf("service %s/%s: %v", remoteService.GetNamespace(), remoteService.GetName(), err)
+	}
+	return ports, nil
+}
@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMirroredPorts(t *testing.T) {
+	ports := []v1.ServicePort{
+		{Name: "http", Port: 80, Protocol: v1.ProtocolTCP},
+		{Name: "https", Port: 443, Protocol: v1.ProtocolTCP},
+		{Name: "admin", Port: 9090, Protocol: v1.ProtocolTCP},
+	}
+	mirrored := func(localPorts ...int32) []MirroredPort {
+		var m []MirroredPort
+		for i, port := range localPorts {
+			if port != 0 {
+				m = append(m, MirroredPort{Remote: ports[i], Port: port})
+			}
+		}
+		return m
+	}
+	tests := []struct {
+		name       string
+		annotation *string
+		configured string
+		want       []MirroredPort
+		wantErr    bool
+	}{
+		{"All", nil, "", mirrored(80, 443, 9090), false},
+		{"EmptyAnnotation", strPtr(""), "-admin", mirrored(80, 443, 9090), false},
+		{"ExcludeByName", strPtr("-admin"), "", mirrored(80, 443, 0), false},
+		{"ExcludeByNumber", strPtr("-9090"), "", mirrored(80, 443, 0), false},
+		{"Include", strPtr("http, 443"), "", mirrored(80, 443, 0), false},
+		{"IncludeAndExclude", strPtr("http,https,-https"), "", mirrored(80, 0, 0), false},
+		{"Renumber", strPtr("http=8080,https"), "", mirrored(8080, 443, 0), false},
+		{"Config", nil, "-admin", mirrored(80, 443, 0), false},
+		{"AnnotationOverridesConfig", strPtr("admin"), "-admin", mirrored(0, 0, 9090), false},
+		{"NothingSelected", strPtr("grpc"), "", nil, true},
+		{"DuplicateLocalPort", strPtr("http=443,https"), "", nil, true},
+		{"RenumberExcluded", strPtr("-http=8080"), "", nil, true},
+		{"InvalidLocalPort", strPtr("http=0"), "", nil, true},
+		{"InvalidPort", strPtr("70000"), "", nil, true},
+		{"EmptyEntry", strPtr("http,,https"), "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var selections map[string]string
+			if tt.configured != "" {
+				selections = map[string]string{"foo/bar": tt.configured}
+			}
+			if err := PortSelections.Replace(selections); err != nil {
+				t.Fatal(err)
+			}
+			defer PortSelections.Replace(nil)
+
+			service := &v1.Service{
+				ObjectMeta: metaV1.ObjectMeta{Namespace: "foo", Name: "bar"},
+				Spec:       v1.ServiceSpec{Ports: ports},
+			}
+			if tt.annotation != nil {
+				service.Annotations = map[string]string{PortsAnnotation: *tt.annotation}
+			}
+			got, err := MirroredPorts(service)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MirroredPorts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MirroredPorts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatePortSelections(t *testing.T) {
+	tests := []struct {
+		name       string
+		selections map[string]string
+		wantErr    bool
+	}{
+		{"Valid", map[string]string{"foo/bar": "http=8080,-admin"}, false},
+		{"InvalidKey", map[string]string{"bar": "http"}, true},
+		{"InvalidSelection", map[string]string{"foo/bar": "-"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidatePortSelections(tt.selections); (err != nil) != tt.wantErr {
+				t.Errorf("ValidatePortSelections() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
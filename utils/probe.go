@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// ProbeTypeTCP probes by opening a TCP connection (default)
+	ProbeTypeTCP = "tcp"
+	// ProbeTypeHTTP probes by sending a HTTP GET request, any status code < 400 is a success
+	ProbeTypeHTTP = "http"
+	// ProbeTypeNone disables probing
+	ProbeTypeNone = "none"
+)
+
+// ProbeSpec defines how the node ports of a service are probed
+type ProbeSpec struct {
+	Type string
+	// Path is the HTTP path to request (ProbeTypeHTTP only)
+	Path string
+}
+
+// ProbeTarget is a single node port to probe
+type ProbeTarget struct {
+	ProbeSpec
+	IP   string
+	Port int32
+}
+
+// String returns the target as URL, e.g. tcp://10.0.0.1:30000 or http://10.0.0.1:30000/healthz
+func (t ProbeTarget) String() string {
+	address := net.JoinHostPort(t.IP, strconv.Itoa(int(t.Port)))
+	if t.Type == ProbeTypeHTTP {
+		return "http://" + address + t.Path
+	}
+	return t.Type + "://" + address
+}
+
+// ParseProbeSpec parses the value of ProbeAnnotation: "tcp", "http", "http:/some/path" or "none"
+func ParseProbeSpec(value string) (ProbeSpec, error) {
+	parts := strings.SplitN(value, ":", 2)
+	switch parts[0] {
+	case ProbeTypeTCP, ProbeTypeNone:
+		if len(parts) > 1 {
+			return ProbeSpec{}, fmt.Errorf("probe type %s does not take a path", parts[0])
+		}
+		return ProbeSpec{Type: parts[0]}, nil
+	case ProbeTypeHTTP:
+		path := "/"
+		if len(parts) > 1 {
+			path = parts[1]
+		}
+		if !strings.HasPrefix(path, "/") {
+			return ProbeSpec{}, fmt.Errorf("probe path %q must start with /", path)
+		}
+		return ProbeSpec{Type: ProbeTypeHTTP, Path: path}, nil
+	}
+	return ProbeSpec{}, fmt.Errorf("invalid probe type %q (must be %s, %s or %s)",
+		parts[0], ProbeTypeTCP, ProbeTypeHTTP, ProbeTypeNone)
+}
+
+// ServiceProbeSpec returns the ProbeSpec of the first of services (nil is skipped) that has ProbeAnnotation set.
+// If none has, nodes ports are probed via TCP.
+func ServiceProbeSpec(services ...*v1.Service) (ProbeSpec, error) {
+	for _, service := range services {
+		if service == nil {
+			continue
+		}
+		if value, ok := service.Annotations[ProbeAnnotation]; ok {
+			return ParseProbeSpec(value)
+		}
+	}
+	return ProbeSpec{Type: ProbeTypeTCP}, nil
+}
+
+// ProbeTargets returns the targets to probe for the (ready) addresses and TCP ports of subset.
+// Other protocols (UDP, SCTP) can't be probed by connecting, their ports are skipped.
+func ProbeTargets(spec ProbeSpec, subset v1.EndpointSubset) []ProbeTarget {
+	if spec.Type == ProbeTypeNone {
+		return nil
+	}
+	var targets []ProbeTarget
+	for _, address := range subset.Addresses {
+		for _, port := range subset.Ports {
+			// Protocol defaults to TCP
+			if port.Protocol != "" && port.Protocol != v1.ProtocolTCP {
+				continue
+			}
+			targets = append(targets, ProbeTarget{ProbeSpec: spec, IP: address.IP, Port: port.Port})
+		}
+	}
+	return targets
+}
@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseProbeSpec(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    ProbeSpec
+		wantErr bool
+	}{
+		{"tcp", ProbeSpec{Type: ProbeTypeTCP}, false},
+		{"none", ProbeSpec{Type: ProbeTypeNone}, false},
+		{"http", ProbeSpec{Type: ProbeTypeHTTP, Path: "/"}, false},
+		{"http:/healthz", ProbeSpec{Type: ProbeTypeHTTP, Path: "/healthz"}, false},
+		{"http:healthz", ProbeSpec{}, true},
+		{"tcp:/foo", ProbeSpec{}, true},
+		{"udp", ProbeSpec{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseProbeSpec(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseProbeSpec() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseProbeSpec() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServiceProbeSpec(t *testing.T) {
+	annotated := &v1.Service{
+		ObjectMeta: metaV1.ObjectMeta{Annotations: map[string]string{ProbeAnnotation: "http:/ready"}},
+	}
+	plain := &v1.Service{}
+
+	tests := []struct {
+		name     string
+		services []*v1.Service
+		want     ProbeSpec
+	}{
+		{"Default", []*v1.Service{plain, nil}, ProbeSpec{Type: ProbeTypeTCP}},
+		{"Annotated", []*v1.Service{nil, plain, annotated}, ProbeSpec{Type: ProbeTypeHTTP, Path: "/ready"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ServiceProbeSpec(tt.services...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("ServiceProbeSpec() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbeTargets(t *testing.T) {
+	subset := v1.EndpointSubset{
+		Addresses: []v1.EndpointAddress{{IP: "10.0.0.1"}, {IP: "10.0.0.2"}},
+		Ports:     []v1.EndpointPort{{Name: "http", Port: 30080}},
+	}
+	spec := ProbeSpec{Type: ProbeTypeTCP}
+
+	want := []ProbeTarget{
+		{ProbeSpec: spec, IP: "10.0.0.1", Port: 30080},
+		{ProbeSpec: spec, IP: "10.0.0.2", Port: 30080},
+	}
+	if got := ProbeTargets(spec, subset); !reflect.DeepEqual(got, want) {
+		t.Errorf("ProbeTargets() = %v, want %v", got, want)
+	}
+	if got := ProbeTargets(ProbeSpec{Type: ProbeTypeNone}, subset); got != nil {
+		t.Errorf("ProbeTargets() = %v, want nil", got)
+	}
+
+	// Only TCP ports are probed
+	subset.Ports = []v1.EndpointPort{
+		{Name: "dns-udp", Port: 30053, Protocol: v1.ProtocolUDP},
+		{Name: "dns-tcp", Port: 30054, Protocol: v1.ProtocolTCP},
+	}
+	want = []ProbeTarget{
+		{ProbeSpec: spec, IP: "10.0.0.1", Port: 30054},
+		{ProbeSpec: spec, IP: "10.0.0.2", Port: 30054},
+	}
+	if got := ProbeTargets(spec, subset); !reflect.DeepEqual(got, want) {
+		t.Errorf("ProbeTargets() = %v, want %v", got, want)
+	}
+	subset.Ports = subset.Ports[:1]
+	if got := ProbeTargets(spec, subset); got != nil {
+		t.Errorf("ProbeTargets() = %v, want nil", got)
+	}
+}
@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// PropagationRules select labels or annotations by key ("team") or prefix ("app.kubernetes.io/*"),
+// see ParsePropagationRules
+type PropagationRules struct {
+	keys     map[string]bool
+	prefixes []string
+}
+
+// ParsePropagationRules parses a list of label or annotation keys. Entries ending with "*" select all keys
+// starting with the part before it.
+func ParsePropagationRules(entries []string) (*PropagationRules, error) {
+	rules := &PropagationRules{keys: make(map[string]bool)}
+	for _, entry := range entries {
+		key := entry
+		prefix := strings.HasSuffix(entry, "*")
+		if prefix {
+			key = strings.TrimSuffix(entry, "*")
+			if key == "" {
+				return nil, fmt.Errorf("invalid key %q: prefix must not be empty", entry)
+			}
+			// Keys starting with the prefix have to be valid, check one of them
+			if errs := validation.IsQualifiedName(key + "x"); len(errs) > 0 {
+				return nil, fmt.Errorf("invalid key prefix %q: %s", entry, strings.Join(errs, ", "))
+			}
+			rules.prefixes = append(rules.prefixes, key)
+			continue
+		}
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid key %q: %s", entry, strings.Join(errs, ", "))
+		}
+		rules.keys[key] = true
+	}
+	return rules, nil
+}
+
+// Matches checks if key is selected. Keys of barrelman (LabelAnnotationKey and keys derived from it) never match.
+func (r *PropagationRules) Matches(key string) bool {
+	if key == LabelAnnotationKey || strings.HasPrefix(key, LabelAnnotationKey+".") {
+		return false
+	}
+	if r.keys[key] {
+		return true
+	}
+	for _, prefix := range r.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply returns current with the selected keys of remote: selected keys are copied from remote and selected keys
+// missing in remote are removed. The returned bool is true if the result differs from current, current is
+// never modified.
+func (r *PropagationRules) Apply(current, remote map[string]string) (map[string]string, bool) {
+	changed := false
+	result := make(map[string]string, len(current))
+	for k, v := range current {
+		if _, ok := remote[k]; !ok && r.Matches(k) {
+			changed = true
+			continue
+		}
+		result[k] = v
+	}
+	for k, v := range remote {
+		if !r.Matches(k) {
+			continue
+		}
+		if current, ok := result[k]; !ok || current != v {
+			result[k] = v
+			changed = true
+		}
+	}
+	return result, changed
+}
+
+var (
+	// Propagation holds the rules selecting the labels and annotations copied from remote to local services,
+	// replaced on config reload
+	Propagation = &propagation{
+		labels:      &PropagationRules{},
+		annotations: &PropagationRules{},
+	}
+)
+
+type propagation struct {
+	lock        sync.RWMutex
+	labels      *PropagationRules
+	annotations *PropagationRules
+}
+
+// Replace replaces the label and annotation rules, see ParsePropagationRules
+func (p *propagation) Replace(labels, annotations []string) error {
+	labelRules, err := ParsePropagationRules(labels)
+	if err != nil {
+		return fmt.Errorf("labels: %v", err)
+	}
+	annotationRules, err := ParsePropagationRules(annotations)
+	if err != nil {
+		return fmt.Errorf("annotations: %v", err)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.labels = labelRules
+	p.annotations = annotationRules
+	return nil
+}
+
+// PropagateMetadata copies the labels and annotations of remoteService selected by Propagation to service and
+// removes selected ones remoteService doesn't have (anymore). Returns true if service changed.
+// The label and annotation maps of service are replaced, not modified, as they may be shared.
+func PropagateMetadata(service, remoteService *v1.Service) bool {
+	Propagation.lock.RLock()
+	defer Propagation.lock.RUnlock()
+
+	labels, labelsChanged := Propagation.labels.Apply(service.Labels, remoteService.Labels)
+	annotations, annotationsChanged := Propagation.annotations.Apply(service.Annotations, remoteService.Annotations)
+	if labelsChanged {
+		service.Labels = labels
+	}
+	if annotationsChanged {
+		service.Annotations = annotations
+	}
+	return labelsChanged || annotationsChanged
+}
@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParsePropagationRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		wantErr bool
+	}{
+		{"Empty", nil, false},
+		{"Keys", []string{"team", "app.kubernetes.io/name"}, false},
+		{"Prefixes", []string{"app.kubernetes.io/*", "team*"}, false},
+		{"EmptyPrefix", []string{"*"}, true},
+		{"InvalidKey", []string{"team name"}, true},
+		{"InvalidPrefix", []string{"example.com/foo/*"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParsePropagationRules(tt.entries); (err != nil) != tt.wantErr {
+				t.Errorf("ParsePropagationRules() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPropagateMetadata(t *testing.T) {
+	if err := Propagation.Replace([]string{"team", "app.kubernetes.io/*", "tfw.io/*"}, []string{"prometheus.io/*"}); err != nil {
+		t.Fatal(err)
+	}
+	defer Propagation.Replace(nil, nil)
+
+	tests := []struct {
+		name            string
+		labels          map[string]string
+		annotations     map[string]string
+		remoteLabels    map[string]string
+		remoteAnnots    map[string]string
+		wantLabels      map[string]string
+		wantAnnotations map[string]string
+		wantChanged     bool
+	}{
+		{
+			"Nothing",
+			ResourceLabel, nil,
+			nil, nil,
+			ResourceLabel, nil,
+			false,
+		},
+		{
+			"Copy",
+			ResourceLabel, nil,
+			map[string]string{"team": "shop", "app.kubernetes.io/name": "api", "other": "foo"},
+			map[string]string{"prometheus.io/scrape": "true", "other": "foo"},
+			map[string]string{LabelAnnotationKey: LabelValueManagedResource, "team": "shop", "app.kubernetes.io/name": "api"},
+			map[string]string{"prometheus.io/scrape": "true"},
+			true,
+		},
+		{
+			"Unchanged",
+			map[string]string{LabelAnnotationKey: LabelValueManagedResource, "team": "shop"},
+			map[string]string{"prometheus.io/scrape": "true"},
+			map[string]string{"team": "shop"},
+			map[string]string{"prometheus.io/scrape": "true"},
+			map[string]string{LabelAnnotationKey: LabelValueManagedResource, "team": "shop"},
+			map[string]string{"prometheus.io/scrape": "true"},
+			false,
+		},
+		{
+			"UpdateAndRemove",
+			map[string]string{LabelAnnotationKey: LabelValueManagedResource, "team": "shop", "app.kubernetes.io/name": "api"},
+			map[string]string{"prometheus.io/scrape": "true", "local": "keep"},
+			map[string]string{"team": "checkout"},
+			nil,
+			map[string]string{LabelAnnotationKey: LabelValueManagedResource, "team": "checkout"},
+			map[string]string{"local": "keep"},
+			true,
+		},
+		{
+			"OwnKeys",
+			ResourceLabel,
+			map[string]string{AnnotationSourceCluster: "remote"},
+			map[string]string{LabelAnnotationKey: LabelValueTrue},
+			map[string]string{AnnotationSourceCluster: "other", LabelAnnotationKey: AnnotationValueIgnore},
+			ResourceLabel,
+			map[string]string{AnnotationSourceCluster: "remote"},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &v1.Service{ObjectMeta: metaV1.ObjectMeta{Labels: tt.labels, Annotations: tt.annotations}}
+			remoteService := &v1.Service{ObjectMeta: metaV1.ObjectMeta{Labels: tt.remoteLabels, Annotations: tt.remoteAnnots}}
+			if got := PropagateMetadata(service, remoteService); got != tt.wantChanged {
+				t.Errorf("PropagateMetadata() = %v, want %v", got, tt.wantChanged)
+			}
+			if !reflect.DeepEqual(service.Labels, tt.wantLabels) {
+				t.Errorf("PropagateMetadata() labels = %v, want %v", service.Labels, tt.wantLabels)
+			}
+			if !reflect.DeepEqual(service.Annotations, tt.wantAnnotations) {
+				t.Errorf("PropagateMetadata() annotations = %v, want %v", service.Annotations, tt.wantAnnotations)
+			}
+			if len(ResourceLabel) != 1 {
+				t.Errorf("PropagateMetadata() modified ResourceLabel: %v", ResourceLabel)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"fmt"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// ProviderGKE resolves the cluster via the Google container API (see NewGKEClientset)
+	ProviderGKE = "gke"
+	// ProviderKubeconfig uses a kubeconfig file and (optional) context
+	ProviderKubeconfig = "kubeconfig"
+	// ProviderToken uses a API server URL, a bearer token file and a CA file
+	ProviderToken = "token"
+)
+
+// ClientsetProvider creates a kubernetes clientset for a cluster
+type ClientsetProvider interface {
+	Clientset() (*kubernetes.Clientset, error)
+}
+
+// ProviderConfig holds the configuration for all known ClientsetProviders.
+// Only the fields relevant for the chosen Provider need to be set.
+type ProviderConfig struct {
+	// Provider is one of ProviderGKE, ProviderKubeconfig or ProviderToken
+	Provider string `json:"provider"`
+
+	// ProviderKubeconfig
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	Context    string `json:"context,omitempty"`
+
+	// ProviderToken
+	Host      string `json:"host,omitempty"`
+	TokenFile string `json:"tokenFile,omitempty"`
+	CAFile    string `json:"caFile,omitempty"`
+
+	// ProviderGKE
+	Project     string `json:"project,omitempty"`
+	Zone        string `json:"zone,omitempty"`
+	ClusterName string `json:"clusterName,omitempty"`
+}
+
+// NewProvider validates the ProviderConfig and returns the matching ClientsetProvider
+func (p ProviderConfig) NewProvider() (ClientsetProvider, error) {
+	switch p.Provider {
+	case ProviderGKE:
+		if p.Project == "" || p.Zone == "" || p.ClusterName == "" {
+			return nil, fmt.Errorf("provider %q needs project, zone and cluster name", p.Provider)
+		}
+		return &GKEProvider{Project: p.Project, Zone: p.Zone, ClusterName: p.ClusterName}, nil
+	case ProviderKubeconfig:
+		if p.Kubeconfig == "" {
+			return nil, fmt.Errorf("provider %q needs a kubeconfig", p.Provider)
+		}
+		return &KubeconfigProvider{Path: p.Kubeconfig, Context: p.Context}, nil
+	case ProviderToken:
+		if p.Host == "" || p.TokenFile == "" {
+			return nil, fmt.Errorf("provider %q needs host and token file", p.Provider)
+		}
+		return &TokenProvider{Host: p.Host, TokenFile: p.TokenFile, CAFile: p.CAFile}, nil
+	}
+	return nil, fmt.Errorf("unknown provider %q", p.Provider)
+}
+
+// GKEProvider creates clientsets for GKE clusters (see NewGKEClientset)
+type GKEProvider struct {
+	Project, Zone, ClusterName string
+}
+
+func (p *GKEProvider) Clientset() (*kubernetes.Clientset, error) {
+	return NewGKEClientset(p.Project, p.Zone, p.ClusterName)
+}
+
+// KubeconfigProvider creates clientsets from a kubeconfig file.
+// If Context is empty, the current context of the kubeconfig is used.
+type KubeconfigProvider struct {
+	Path, Context string
+}
+
+func (p *KubeconfigProvider) Config() (*rest.Config, error) {
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{
+			ExplicitPath: p.Path,
+		},
+		&clientcmd.ConfigOverrides{
+			CurrentContext: p.Context,
+		}).ClientConfig()
+}
+
+func (p *KubeconfigProvider) Clientset() (*kubernetes.Clientset, error) {
+	config, err := p.Config()
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+// TokenProvider creates clientsets for a API server authenticating with a bearer token.
+// The token file is re-read periodically so rotated tokens are picked up.
+// If CAFile is empty, the system trust store is used.
+type TokenProvider struct {
+	Host, TokenFile, CAFile string
+}
+
+func (p *TokenProvider) Config() *rest.Config {
+	return &rest.Config{
+		Host:            p.Host,
+		BearerTokenFile: p.TokenFile,
+		TLSClientConfig: rest.TLSClientConfig{
+			CAFile: p.CAFile,
+		},
+	}
+}
+
+func (p *TokenProvider) Clientset() (*kubernetes.Clientset, error) {
+	return kubernetes.NewForConfig(p.Config())
+}
@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: foo
+  cluster:
+    server: https://foo.example.com
+- name: bar
+  cluster:
+    server: https://bar.example.com
+users:
+- name: foo
+  user:
+    token: foo-token
+contexts:
+- name: foo
+  context:
+    cluster: foo
+    user: foo
+- name: bar
+  context:
+    cluster: bar
+    user: foo
+current-context: foo
+`
+
+func TestProviderConfig_NewProvider(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  ProviderConfig
+		want    ClientsetProvider
+		wantErr bool
+	}{
+		{
+			"Unknown",
+			ProviderConfig{Provider: "foo"},
+			nil,
+			true,
+		},
+		{
+			"GKE",
+			ProviderConfig{Provider: ProviderGKE, Project: "p", Zone: "z", ClusterName: "c", Host: "ignored"},
+			&GKEProvider{Project: "p", Zone: "z", ClusterName: "c"},
+			false,
+		},
+		{
+			"GKEMissingCluster",
+			ProviderConfig{Provider: ProviderGKE, Project: "p", Zone: "z"},
+			nil,
+			true,
+		},
+		{
+			"Kubeconfig",
+			ProviderConfig{Provider: ProviderKubeconfig, Kubeconfig: "/foo", Context: "bar"},
+			&KubeconfigProvider{Path: "/foo", Context: "bar"},
+			false,
+		},
+		{
+			"KubeconfigMissingPath",
+			ProviderConfig{Provider: ProviderKubeconfig, Context: "bar"},
+			nil,
+			true,
+		},
+		{
+			"Token",
+			ProviderConfig{Provider: ProviderToken, Host: "https://foo", TokenFile: "/token"},
+			&TokenProvider{Host: "https://foo", TokenFile: "/token"},
+			false,
+		},
+		{
+			"TokenMissingTokenFile",
+			ProviderConfig{Provider: ProviderToken, Host: "https://foo", CAFile: "/ca"},
+			nil,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.config.NewProvider()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewProvider() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewProvider() got = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKubeconfigProvider_Config(t *testing.T) {
+	f, err := ioutil.TempFile("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(testKubeconfig); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+
+	tests := []struct {
+		name     string
+		context  string
+		wantHost string
+		wantErr  bool
+	}{
+		{"CurrentContext", "", "https://foo.example.com", false},
+		{"ExplicitContext", "bar", "https://bar.example.com", false},
+		{"UnknownContext", "baz", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &KubeconfigProvider{Path: f.Name(), Context: tt.context}
+			got, err := p.Config()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Config() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && got.Host != tt.wantHost {
+				t.Errorf("Config() host = %v, want %v", got.Host, tt.wantHost)
+			}
+		})
+	}
+}
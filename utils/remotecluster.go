@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// RemoteClusterConfig describes a remote cluster to watch
+type RemoteClusterConfig struct {
+	// Name identifies the remote cluster in logs and metrics, see ValidateRemoteClusterName
+	Name string `json:"name"`
+	ProviderConfig
+}
+
+// ValidateRemoteClusterName checks that name is a DNS-1123 label (like "eu-west"), as it is part of the names
+// of EndpointSlices and may be part of service names
+func ValidateRemoteClusterName(name string) error {
+	if name == "" {
+		return fmt.Errorf("remote cluster needs a name")
+	}
+	if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+		return fmt.Errorf("invalid remote cluster name %q: %s", name, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// RemoteClusterList is a flag.Value collecting remote cluster definitions.
+// Each definition is a comma separated list of key=value pairs, like:
+// name=foo,provider=kubeconfig,kubeconfig=/path/to/kubeconfig,context=bar
+type RemoteClusterList []RemoteClusterConfig
+
+func (l *RemoteClusterList) String() string {
+	names := make([]string, len(*l))
+	for i, r := range *l {
+		names[i] = r.Name
+	}
+	return strings.Join(names, ",")
+}
+
+func (l *RemoteClusterList) Set(v string) error {
+	r := RemoteClusterConfig{ProviderConfig: ProviderConfig{Provider: ProviderGKE}}
+	for _, kv := range strings.Split(v, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid remote cluster option %q, expected key=value", kv)
+		}
+		value := parts[1]
+		switch parts[0] {
+		case "name":
+			r.Name = value
+		case "provider":
+			r.Provider = value
+		case "kubeconfig":
+			r.Kubeconfig = value
+		case "context":
+			r.Context = value
+		case "host":
+			r.Host = value
+		case "token-file":
+			r.TokenFile = value
+		case "ca-file":
+			r.CAFile = value
+		case "project":
+			r.Project = value
+		case "zone":
+			r.Zone = value
+		case "cluster-name":
+			r.ClusterName = value
+		default:
+			return fmt.Errorf("unknown remote cluster option %q", parts[0])
+		}
+	}
+
+	if err := ValidateRemoteClusterName(r.Name); err != nil {
+		return err
+	}
+	for _, existing := range *l {
+		if existing.Name == r.Name {
+			return fmt.Errorf("duplicate remote cluster name %q", r.Name)
+		}
+	}
+	if _, err := r.NewProvider(); err != nil {
+		return fmt.Errorf("remote cluster %q: %v", r.Name, err)
+	}
+
+	*l = append(*l, r)
+	return nil
+}
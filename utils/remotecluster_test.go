@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/utils/diff"
+)
+
+func TestRemoteClusterList_Set(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		wantErr bool
+		want    RemoteClusterList
+	}{
+		{
+			"GKEDefault",
+			[]string{"name=eu,project=p,zone=z,cluster-name=c"},
+			false,
+			RemoteClusterList{
+				{Name: "eu", ProviderConfig: ProviderConfig{Provider: ProviderGKE, Project: "p", Zone: "z", ClusterName: "c"}},
+			},
+		},
+		{
+			"Many",
+			[]string{
+				"name=a,provider=kubeconfig,kubeconfig=/kube/a,context=ctx",
+				"name=b,provider=token,host=https://b,token-file=/token,ca-file=/ca",
+			},
+			false,
+			RemoteClusterList{
+				{Name: "a", ProviderConfig: ProviderConfig{Provider: ProviderKubeconfig, Kubeconfig: "/kube/a", Context: "ctx"}},
+				{Name: "b", ProviderConfig: ProviderConfig{Provider: ProviderToken, Host: "https://b", TokenFile: "/token", CAFile: "/ca"}},
+			},
+		},
+		{
+			"NoName",
+			[]string{"provider=kubeconfig,kubeconfig=/kube/a"},
+			true,
+			nil,
+		},
+		{
+			"InvalidName",
+			[]string{"name=EU_West,provider=kubeconfig,kubeconfig=/kube/a"},
+			true,
+			nil,
+		},
+		{
+			"DuplicateName",
+			[]string{"name=a,provider=kubeconfig,kubeconfig=/kube/a", "name=a,provider=kubeconfig,kubeconfig=/kube/b"},
+			true,
+			RemoteClusterList{
+				{Name: "a", ProviderConfig: ProviderConfig{Provider: ProviderKubeconfig, Kubeconfig: "/kube/a"}},
+			},
+		},
+		{
+			"UnknownKey",
+			[]string{"name=a,foo=bar"},
+			true,
+			nil,
+		},
+		{
+			"NoValue",
+			[]string{"name=a,provider"},
+			true,
+			nil,
+		},
+		{
+			"InvalidProviderConfig",
+			[]string{"name=a,provider=token,host=https://a"},
+			true,
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var l RemoteClusterList
+			var err error
+			for _, v := range tt.values {
+				if err = l.Set(v); err != nil {
+					break
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Set() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(tt.want, l) {
+				t.Errorf("Expected different RemoteClusterList: (expected, got)\n%s",
+					diff.ObjectGoPrintSideBySide(tt.want, l))
+			}
+		})
+	}
+}
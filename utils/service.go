@@ -2,11 +2,112 @@ package utils
 
 import (
 	"sort"
+	"sync/atomic"
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 )
 
+// mirrorLoadBalancers is 1 if all remote LoadBalancer services should be mirrored, see SetMirrorLoadBalancers
+var mirrorLoadBalancers int32
+
+// SetMirrorLoadBalancers enables or disables mirroring of all remote LoadBalancer services.
+// Single services may opt in or out via LoadBalancerAnnotation.
+func SetMirrorLoadBalancers(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&mirrorLoadBalancers, value)
+}
+
+// mirrorPodIPs is 1 if remote services should be mirrored via their pod IPs, see SetMirrorPodIPs
+var mirrorPodIPs int32
+
+// SetMirrorPodIPs enables or disables mirroring of remote services via the pod IPs of their endpoints.
+// Single services may opt out via PodIPsAnnotation.
+func SetMirrorPodIPs(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&mirrorPodIPs, value)
+}
+
+// mirrorHeadless is 1 if remote headless services should be mirrored, see SetMirrorHeadless
+var mirrorHeadless int32
+
+// SetMirrorHeadless enables or disables mirroring of remote headless services (always via pod IPs)
+func SetMirrorHeadless(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&mirrorHeadless, value)
+}
+
+// localClusterName is the name remote barrelman instances use for the local cluster, see SetLocalClusterName
+var localClusterName atomic.Value
+
+// SetLocalClusterName sets the name remote barrelman instances use for the local cluster (their remote cluster
+// name). Remote services mirrored from the local cluster (see AnnotationSourceCluster) are never mirrored back.
+// An empty name disables the check.
+func SetLocalClusterName(name string) {
+	localClusterName.Store(name)
+}
+
+// IsMirroredFromLocalCluster checks if service is a dummy service created by a barrelman instance mirroring
+// from the local cluster, e.g. in symmetric (X<->Y) setups
+func IsMirroredFromLocalCluster(service *v1.Service) bool {
+	if service == nil {
+		return false
+	}
+	name, _ := localClusterName.Load().(string)
+	return name != "" && service.Annotations[AnnotationSourceCluster] == name
+}
+
+// IsHeadless checks if service is a headless service (cluster IP "None")
+func IsHeadless(service *v1.Service) bool {
+	return service != nil && service.Spec.ClusterIP == v1.ClusterIPNone
+}
+
+// IsHeadlessMirrored checks if service is a headless service that should be mirrored
+func IsHeadlessMirrored(service *v1.Service) bool {
+	if !IsHeadless(service) || service.Spec.Type == v1.ServiceTypeExternalName {
+		return false
+	}
+	return atomic.LoadInt32(&mirrorHeadless) == 1
+}
+
+// IsPodIPMirrored checks if the endpoints of service should point to the remote pod IPs directly: pod IP mode is
+// enabled and PodIPsAnnotation is not "false". ExternalName services are never mirrored via pod IPs, mirrored
+// headless services always are (see IsHeadlessMirrored).
+func IsPodIPMirrored(service *v1.Service) bool {
+	if service == nil || service.Spec.Type == v1.ServiceTypeExternalName {
+		return false
+	}
+	if IsHeadlessMirrored(service) {
+		return true
+	}
+	return atomic.LoadInt32(&mirrorPodIPs) == 1 && service.Annotations[PodIPsAnnotation] != "false"
+}
+
+// IsLoadBalancerMirrored checks if service is a LoadBalancer service that should be mirrored via its
+// load balancer ingress IPs: LoadBalancerAnnotation is "true" or mirroring is enabled globally and
+// the annotation is not "false".
+func IsLoadBalancerMirrored(service *v1.Service) bool {
+	if service == nil || service.Spec.Type != v1.ServiceTypeLoadBalancer {
+		return false
+	}
+	switch service.Annotations[LoadBalancerAnnotation] {
+	case LabelValueTrue:
+		return true
+	case "false":
+		return false
+	}
+	return atomic.LoadInt32(&mirrorLoadBalancers) == 1
+}
+
 // ResponsibleForService checks if barrelman is responsible for this service
 // e.g. service is not not ignored by annotation or in an ignored namespace
 // Will return false if service is nil
@@ -31,15 +132,31 @@ func ResponsibleForService(service *v1.Service) bool {
 }
 
 // ResponsibleForRemoteService checks if barrelman is responsible in general (via ResponsibleForService)
-// and if the service is of type NodePort
+// and if the service is of type NodePort (or a mirrored LoadBalancer, see IsLoadBalancerMirrored).
+// In pod IP mode (see IsPodIPMirrored) and ExternalName mode (see IsExternalNameMirrored), services of all types
+// but ExternalName are accepted, as are mirrored headless services.
+// Dummy services of other barrelman instances are never mirrored to prevent loops in symmetric setups.
+// In opt-in mode, only exported services are mirrored (see IsExported), and only services in included namespaces
+// are mirrored if IncludedNamespaces is not empty.
 func ResponsibleForRemoteService(service *v1.Service) bool {
 	responsible := ResponsibleForService(service)
-	if !responsible {
+	if !responsible || !IsExported(service) {
+		return false
+	}
+
+	// The include list only applies to remote namespaces, see IncludedNamespaces
+	if !IncludedNamespaces.IsIncluded(service.GetNamespace()) {
 		return false
 	}
 
-	// Ignore all remote services that don't have node ports
-	if service.Spec.Type != v1.ServiceTypeNodePort {
+	// Don't mirror services that are mirrors themselves (a NodePort dummy would be mirrored back otherwise)
+	if OwnerOfService(service) || IsMirroredFromLocalCluster(service) {
+		return false
+	}
+
+	// Ignore all remote services that don't have node ports (unless load balancers are mirrored)
+	if service.Spec.Type != v1.ServiceTypeNodePort && !IsLoadBalancerMirrored(service) && !IsPodIPMirrored(service) &&
+		!IsExternalNameMirrored(service) {
 		return false
 	}
 
@@ -86,6 +203,7 @@ func GetService(getFunc GetServiceFunc) (service *v1.Service, exists bool, err e
 	return nil, false, err
 }
 
+// ServicePortsEqual checks if a and b contain the same ports (in any order)
 func ServicePortsEqual(a, b []v1.ServicePort) bool {
 	if (a == nil) != (b == nil) {
 		return false
@@ -99,8 +217,8 @@ func ServicePortsEqual(a, b []v1.ServicePort) bool {
 	sB := make([]v1.ServicePort, len(b))
 	copy(sA, a)
 	copy(sB, b)
-	sort.Slice(sA, func(i, j int) bool { return i == j })
-	sort.Slice(sB, func(i, j int) bool { return i == j })
+	sortServicePorts(sA)
+	sortServicePorts(sB)
 
 	for idx, port := range sA {
 		if port != sB[idx] {
@@ -109,3 +227,16 @@ func ServicePortsEqual(a, b []v1.ServicePort) bool {
 	}
 	return true
 }
+
+// sortServicePorts sorts ports by name, port and protocol
+func sortServicePorts(ports []v1.ServicePort) {
+	sort.Slice(ports, func(i, j int) bool {
+		if ports[i].Name != ports[j].Name {
+			return ports[i].Name < ports[j].Name
+		}
+		if ports[i].Port != ports[j].Port {
+			return ports[i].Port < ports[j].Port
+		}
+		return ports[i].Protocol < ports[j].Protocol
+	})
+}
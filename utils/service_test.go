@@ -15,6 +15,8 @@ import (
 )
 
 func TestResponsibleForRemoteService(t *testing.T) {
+	SetLocalClusterName("local")
+	defer SetLocalClusterName("")
 	tests := []struct {
 		name    string
 		service *v1.Service
@@ -64,6 +66,18 @@ func TestResponsibleForRemoteService(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"LoadBalancerOptIn",
+			&v1.Service{
+				ObjectMeta: metaV1.ObjectMeta{
+					Annotations: map[string]string{LoadBalancerAnnotation: "true"},
+				},
+				Spec: v1.ServiceSpec{
+					Type: v1.ServiceTypeLoadBalancer,
+				},
+			},
+			true,
+		},
 		{
 			"IgnoreResponsible",
 			&v1.Service{
@@ -94,6 +108,42 @@ func TestResponsibleForRemoteService(t *testing.T) {
 			nil,
 			false,
 		},
+		{
+			"Dummy",
+			&v1.Service{
+				ObjectMeta: metaV1.ObjectMeta{
+					Labels: ResourceLabel,
+				},
+				Spec: v1.ServiceSpec{
+					Type: v1.ServiceTypeNodePort,
+				},
+			},
+			false,
+		},
+		{
+			"MirroredFromLocalCluster",
+			&v1.Service{
+				ObjectMeta: metaV1.ObjectMeta{
+					Annotations: map[string]string{AnnotationSourceCluster: "local"},
+				},
+				Spec: v1.ServiceSpec{
+					Type: v1.ServiceTypeNodePort,
+				},
+			},
+			false,
+		},
+		{
+			"MirroredFromOtherCluster",
+			&v1.Service{
+				ObjectMeta: metaV1.ObjectMeta{
+					Annotations: map[string]string{AnnotationSourceCluster: "other"},
+				},
+				Spec: v1.ServiceSpec{
+					Type: v1.ServiceTypeNodePort,
+				},
+			},
+			true,
+		},
 		{
 			"IgnoredNamespace",
 			&v1.Service{
@@ -116,6 +166,103 @@ func TestResponsibleForRemoteService(t *testing.T) {
 	}
 }
 
+func TestIsLoadBalancerMirrored(t *testing.T) {
+	defer SetMirrorLoadBalancers(false)
+	tests := []struct {
+		name    string
+		global  bool
+		service *v1.Service
+		want    bool
+	}{
+		{"Disabled", false, &v1.Service{Spec: v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer}}, false},
+		{"Enabled", true, &v1.Service{Spec: v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer}}, true},
+		{"NodePort", true, &v1.Service{Spec: v1.ServiceSpec{Type: v1.ServiceTypeNodePort}}, false},
+		{"nil", true, nil, false},
+		{
+			"OptIn",
+			false,
+			&v1.Service{
+				ObjectMeta: metaV1.ObjectMeta{Annotations: map[string]string{LoadBalancerAnnotation: "true"}},
+				Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+			},
+			true,
+		},
+		{
+			"OptOut",
+			true,
+			&v1.Service{
+				ObjectMeta: metaV1.ObjectMeta{Annotations: map[string]string{LoadBalancerAnnotation: "false"}},
+				Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+			},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetMirrorLoadBalancers(tt.global)
+			if got := IsLoadBalancerMirrored(tt.service); got != tt.want {
+				t.Errorf("IsLoadBalancerMirrored() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsPodIPMirrored(t *testing.T) {
+	defer SetMirrorPodIPs(false)
+	tests := []struct {
+		name    string
+		global  bool
+		service *v1.Service
+		want    bool
+	}{
+		{"Disabled", false, &v1.Service{}, false},
+		{"Enabled", true, &v1.Service{}, true},
+		{"ExternalName", true, &v1.Service{Spec: v1.ServiceSpec{Type: v1.ServiceTypeExternalName}}, false},
+		{"nil", true, nil, false},
+		{
+			"OptOut",
+			true,
+			&v1.Service{ObjectMeta: metaV1.ObjectMeta{Annotations: map[string]string{PodIPsAnnotation: "false"}}},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetMirrorPodIPs(tt.global)
+			if got := IsPodIPMirrored(tt.service); got != tt.want {
+				t.Errorf("IsPodIPMirrored() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsHeadlessMirrored(t *testing.T) {
+	defer SetMirrorHeadless(false)
+	headless := &v1.Service{Spec: v1.ServiceSpec{ClusterIP: v1.ClusterIPNone}}
+	tests := []struct {
+		name    string
+		global  bool
+		service *v1.Service
+		want    bool
+	}{
+		{"Disabled", false, headless, false},
+		{"Enabled", true, headless, true},
+		{"NotHeadless", true, &v1.Service{Spec: v1.ServiceSpec{ClusterIP: "10.96.0.10"}}, false},
+		{"nil", true, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetMirrorHeadless(tt.global)
+			if got := IsHeadlessMirrored(tt.service); got != tt.want {
+				t.Errorf("IsHeadlessMirrored() = %v, want %v", got, tt.want)
+			}
+			if got := IsPodIPMirrored(tt.service); got != tt.want {
+				t.Errorf("IsPodIPMirrored() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestOwnerOfService(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -335,6 +482,14 @@ func TestServicePortsEqual(t *testing.T) {
 			},
 			true,
 		},
+		{
+			"DifferentOrder",
+			args{
+				[]v1.ServicePort{{Name: "http", Port: 80}, {Name: "https", Port: 443}},
+				[]v1.ServicePort{{Name: "https", Port: 443}, {Name: "http", Port: 80}},
+			},
+			true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+var (
+	// ServiceNames maps remote service names to the names of local (dummy) services, replaced on config reload
+	ServiceNames = &serviceNamer{}
+)
+
+type serviceNamer struct {
+	lock sync.RWMutex
+	tmpl *template.Template
+}
+
+// Replace replaces the name template. text is a Go template with the fields of ExternalNameData
+// (e.g. "{{.Name}}-remote" or "{{.Cluster}}-{{.Name}}"), an empty text keeps the remote name.
+func (n *serviceNamer) Replace(text string) error {
+	var tmpl *template.Template
+	if text != "" {
+		var err error
+		if tmpl, err = parseServiceNameTemplate(text); err != nil {
+			return err
+		}
+		if _, err := executeServiceNameTemplate(tmpl, sampleNameData); err != nil {
+			return err
+		}
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.tmpl = tmpl
+	return nil
+}
+
+// Enabled returns true if a name template is configured
+func (n *serviceNamer) Enabled() bool {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	return n.tmpl != nil
+}
+
+// Local returns the name of the local service mirroring the service namespace/name of cluster
+func (n *serviceNamer) Local(namespace, name, cluster string) (string, error) {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	if n.tmpl == nil {
+		return name, nil
+	}
+	return executeServiceNameTemplate(n.tmpl, ExternalNameData{Name: name, Namespace: namespace, Cluster: cluster})
+}
+
+// LocalServiceKey returns the key (namespace/name) of the local service mirroring the service namespace/name
+// of cluster, see NamespaceMappings and ServiceNames
+func LocalServiceKey(namespace, name, cluster string) (string, error) {
+	localName, err := ServiceNames.Local(namespace, name, cluster)
+	if err != nil {
+		return "", err
+	}
+	return NamespaceMappings.Local(namespace) + "/" + localName, nil
+}
+
+// ValidateServiceNameTemplate checks if text is a valid service name template
+func ValidateServiceNameTemplate(text string) error {
+	tmpl, err := parseServiceNameTemplate(text)
+	if err != nil {
+		return err
+	}
+	_, err = executeServiceNameTemplate(tmpl, sampleNameData)
+	return err
+}
+
+// sampleNameData is used to validate templates
+var sampleNameData = ExternalNameData{Name: "name", Namespace: "namespace", Cluster: "cluster"}
+
+func parseServiceNameTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("serviceName").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid service name template %q: %v", text, err)
+	}
+	return tmpl, nil
+}
+
+func executeServiceNameTemplate(tmpl *template.Template, data ExternalNameData) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("invalid service name template: %v", err)
+	}
+	name := strings.ToLower(buf.String())
+	if errs := validation.IsDNS1035Label(name); len(errs) > 0 {
+		return "", fmt.Errorf("invalid service name %q for %s/%s: %s", name, data.Namespace, data.Name,
+			strings.Join(errs, ", "))
+	}
+	return name, nil
+}
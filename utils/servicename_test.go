@@ -0,0 +1,55 @@
+package utils
+
+import "testing"
+
+func Test_serviceNamer_Local(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		remote   string
+		want     string
+		wantErr  bool
+	}{
+		{"NoTemplate", "", "foo", "foo", false},
+		{"Suffix", "{{.Name}}-remote", "foo", "foo-remote", false},
+		{"Cluster", "{{.Cluster}}-{{.Name}}", "foo", "eu-foo", false},
+		{"Lowercase", "{{.Name}}-EU", "foo", "foo-eu", false},
+		{"TooLong", "{{.Name}}-remote", "foo-0123456789012345678901234567890123456789012345678901234", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := &serviceNamer{}
+			if err := n.Replace(tt.template); err != nil {
+				t.Fatal(err)
+			}
+			got, err := n.Local("bar", tt.remote, "eu")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Local() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Local() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateServiceNameTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		wantErr  bool
+	}{
+		{"Valid", "{{.Cluster}}-{{.Name}}", false},
+		{"Unparsable", "{{.Name", true},
+		{"UnknownField", "{{.Foo}}", true},
+		{"InvalidName", "{{.Name}}.remote", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateServiceNameTemplate(tt.template); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateServiceNameTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Suffixes of the status annotations, appended to LabelAnnotationKey (e.g. tfw.io/barrelman.source-cluster)
+const (
+	statusSuffixSourceCluster         = ".source-cluster"
+	statusSuffixSourceNamespace       = ".source-namespace"
+	statusSuffixSourceName            = ".source-name"
+	statusSuffixRemoteResourceVersion = ".remote-resource-version"
+	statusSuffixRemoteNodePorts       = ".remote-node-ports"
+	statusSuffixReadyNodes            = ".ready-nodes"
+	statusSuffixLastSync              = ".last-sync"
+	statusSuffixLastError             = ".last-error"
+)
+
+var (
+	// Status annotations written by ServiceController on the services it manages
+	AnnotationSourceCluster         string
+	AnnotationSourceNamespace       string
+	AnnotationSourceName            string
+	AnnotationRemoteResourceVersion string
+	AnnotationRemoteNodePorts       string
+
+	// Status annotations written by NodeEndpointController on the services it manages endpoints for
+	AnnotationReadyNodes string
+	AnnotationLastSync   string
+	AnnotationLastError  string
+)
+
+// setStatusAnnotationKeys derives the status annotation keys from key (see SetLabelAnnotationKey)
+func setStatusAnnotationKeys(key string) {
+	AnnotationSourceCluster = key + statusSuffixSourceCluster
+	AnnotationSourceNamespace = key + statusSuffixSourceNamespace
+	AnnotationSourceName = key + statusSuffixSourceName
+	AnnotationRemoteResourceVersion = key + statusSuffixRemoteResourceVersion
+	AnnotationRemoteNodePorts = key + statusSuffixRemoteNodePorts
+	AnnotationReadyNodes = key + statusSuffixReadyNodes
+	AnnotationLastSync = key + statusSuffixLastSync
+	AnnotationLastError = key + statusSuffixLastError
+}
+
+func statusAnnotationKeys() []string {
+	return []string{
+		AnnotationSourceCluster, AnnotationSourceNamespace, AnnotationSourceName, AnnotationRemoteResourceVersion,
+		AnnotationRemoteNodePorts, AnnotationReadyNodes, AnnotationLastSync, AnnotationLastError,
+	}
+}
+
+// SourceAnnotations returns the status annotations describing the remote service a (dummy) service mirrors
+func SourceAnnotations(cluster string, remoteService *v1.Service) map[string]string {
+	nodePorts := make([]string, len(remoteService.Spec.Ports))
+	for i, port := range remoteService.Spec.Ports {
+		nodePorts[i] = fmt.Sprintf("%s:%d", port.Name, port.NodePort)
+	}
+	return map[string]string{
+		AnnotationSourceCluster:         cluster,
+		AnnotationSourceNamespace:       remoteService.GetNamespace(),
+		AnnotationSourceName:            remoteService.GetName(),
+		AnnotationRemoteResourceVersion: remoteService.GetResourceVersion(),
+		AnnotationRemoteNodePorts:       strings.Join(nodePorts, ","),
+	}
+}
+
+// HasAnnotations checks if all given annotations are set on service
+func HasAnnotations(service *v1.Service, annotations map[string]string) bool {
+	for k, v := range annotations {
+		if current, ok := service.Annotations[k]; !ok || current != v {
+			return false
+		}
+	}
+	return true
+}
+
+// SetAnnotations sets the given annotations on service (without modifying a possibly shared map)
+func SetAnnotations(service *v1.Service, annotations map[string]string) {
+	merged := make(map[string]string, len(service.Annotations)+len(annotations))
+	for k, v := range service.Annotations {
+		merged[k] = v
+	}
+	for k, v := range annotations {
+		merged[k] = v
+	}
+	service.Annotations = merged
+}
+
+// EndpointStatusPatch returns a merge patch setting the endpoint status annotations of service, nil if they are up
+// to date. On success (syncErr is nil), last sync time is updated and last error is removed. On error, only last
+// error is set. As every sync would change the last sync time, it is only refreshed if it is older than refresh
+// (unless ready nodes or last error changed).
+func EndpointStatusPatch(service *v1.Service, readyNodes int, now time.Time, refresh time.Duration, syncErr error) ([]byte, error) {
+	lastError, hasError := service.Annotations[AnnotationLastError]
+	changed := false
+	if syncErr != nil {
+		changed = !hasError || lastError != syncErr.Error()
+	} else {
+		changed = hasError
+	}
+	if readyNodes >= 0 && service.Annotations[AnnotationReadyNodes] != strconv.Itoa(readyNodes) {
+		changed = true
+	}
+	if !changed && syncErr == nil {
+		lastSync, err := time.Parse(time.RFC3339, service.Annotations[AnnotationLastSync])
+		changed = err != nil || now.Sub(lastSync) >= refresh
+	}
+	if !changed {
+		return nil, nil
+	}
+
+	annotations := make(map[string]interface{})
+	if syncErr != nil {
+		annotations[AnnotationLastError] = syncErr.Error()
+	} else {
+		annotations[AnnotationLastError] = nil
+		annotations[AnnotationLastSync] = now.UTC().Format(time.RFC3339)
+	}
+	if readyNodes >= 0 {
+		annotations[AnnotationReadyNodes] = strconv.Itoa(readyNodes)
+	}
+	return json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+}
+
+// OnlyStatusChanged checks if old and cur only differ in status annotations (or resourceVersion and managedFields,
+// which the API server updates on every write).
+// Used to not retrigger syncs when writing status annotations.
+func OnlyStatusChanged(old, cur *v1.Service) bool {
+	strip := func(service *v1.Service) *v1.Service {
+		s := service.DeepCopy()
+		s.ResourceVersion = ""
+		s.ManagedFields = nil
+		for _, k := range statusAnnotationKeys() {
+			delete(s.Annotations, k)
+		}
+		if len(s.Annotations) == 0 {
+			s.Annotations = nil
+		}
+		return s
+	}
+	o, c := strip(old), strip(cur)
+	return reflect.DeepEqual(o.ObjectMeta, c.ObjectMeta) && reflect.DeepEqual(o.Spec, c.Spec)
+}
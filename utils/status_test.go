@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestOnlyStatusChanged(t *testing.T) {
+	old := &v1.Service{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:            "foo",
+			ResourceVersion: "1",
+			Labels:          ServiceLabel,
+		},
+		Spec: v1.ServiceSpec{Ports: []v1.ServicePort{{Name: "http", Port: 80}}},
+	}
+
+	tests := []struct {
+		name   string
+		modify func(s *v1.Service)
+		want   bool
+	}{
+		{"StatusAnnotations", func(s *v1.Service) {
+			s.ResourceVersion = "2"
+			s.Annotations = map[string]string{AnnotationLastSync: "now", AnnotationReadyNodes: "3"}
+		}, true},
+		{"ManagedFields", func(s *v1.Service) {
+			s.ResourceVersion = "2"
+			s.Annotations = map[string]string{AnnotationLastSync: "now"}
+			s.ManagedFields = []metaV1.ManagedFieldsEntry{{Manager: "barrelman", Operation: metaV1.ManagedFieldsOperationUpdate}}
+		}, true},
+		{"OtherAnnotation", func(s *v1.Service) {
+			s.Annotations = map[string]string{AnnotationLastSync: "now", "foo": "bar"}
+		}, false},
+		{"Label", func(s *v1.Service) {
+			s.Labels = ResourceLabel
+		}, false},
+		{"Spec", func(s *v1.Service) {
+			s.Spec.Ports[0].Port = 8080
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cur := old.DeepCopy()
+			tt.modify(cur)
+			if got := OnlyStatusChanged(old, cur); got != tt.want {
+				t.Errorf("OnlyStatusChanged() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEndpointStatusPatch(t *testing.T) {
+	now := time.Date(2019, 5, 1, 14, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+	synced := map[string]string{AnnotationLastSync: "2019-05-01T11:55:00Z", AnnotationReadyNodes: "3"}
+	failed := map[string]string{AnnotationLastError: "failed", AnnotationReadyNodes: "0"}
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		readyNodes  int
+		err         error
+		want        string
+	}{
+		{"Success", nil, 3, nil,
+			`{"metadata":{"annotations":{"tfw.io/barrelman.last-error":null,"tfw.io/barrelman.last-sync":"2019-05-01T12:00:00Z","tfw.io/barrelman.ready-nodes":"3"}}}`},
+		{"Error", nil, 0, errors.New("failed"),
+			`{"metadata":{"annotations":{"tfw.io/barrelman.last-error":"failed","tfw.io/barrelman.ready-nodes":"0"}}}`},
+		{"UnknownReadyNodes", nil, -1, errors.New("failed"),
+			`{"metadata":{"annotations":{"tfw.io/barrelman.last-error":"failed"}}}`},
+		{"Unchanged", synced, 3, nil, ``},
+		{"ReadyNodesChanged", synced, 2, nil,
+			`{"metadata":{"annotations":{"tfw.io/barrelman.last-error":null,"tfw.io/barrelman.last-sync":"2019-05-01T12:00:00Z","tfw.io/barrelman.ready-nodes":"2"}}}`},
+		{"LastSyncOutdated", map[string]string{AnnotationLastSync: "2019-05-01T11:50:00Z", AnnotationReadyNodes: "3"}, 3, nil,
+			`{"metadata":{"annotations":{"tfw.io/barrelman.last-error":null,"tfw.io/barrelman.last-sync":"2019-05-01T12:00:00Z","tfw.io/barrelman.ready-nodes":"3"}}}`},
+		{"SameError", failed, 0, errors.New("failed"), ``},
+		{"Recovered", failed, 1, nil,
+			`{"metadata":{"annotations":{"tfw.io/barrelman.last-error":null,"tfw.io/barrelman.last-sync":"2019-05-01T12:00:00Z","tfw.io/barrelman.ready-nodes":"1"}}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &v1.Service{ObjectMeta: metaV1.ObjectMeta{Annotations: tt.annotations}}
+			got, err := EndpointStatusPatch(service, tt.readyNodes, now, 10*time.Minute, tt.err)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("EndpointStatusPatch() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}